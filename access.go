@@ -0,0 +1,238 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Write access control of servers
+ */
+
+package modbus
+
+import (
+	"net"
+	"sync"
+)
+
+// AccessRule grants write access to the clients it matches.
+type AccessRule struct {
+
+	// client networks, empty matches all addresses
+	Networks []*net.IPNet
+
+	// TLS roles of the client certificates, empty matches all clients
+	Roles []string
+
+	// function codes the clients may write with, empty allows all
+	Functions []uint8
+}
+
+func (r *AccessRule) matches(req *Request) bool {
+	if len(r.Networks) > 0 {
+		ip := remoteIP(req.RemoteAddr)
+		found := false
+		for _, n := range r.Networks {
+			if ip != nil && n.Contains(ip) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.Roles) > 0 {
+		found := false
+		for _, role := range r.Roles {
+			if req.Role != "" && role == req.Role {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.Functions) > 0 {
+		for _, f := range r.Functions {
+			if f == req.Pdu.Function {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func remoteIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+// readFunctions are the function codes that do not change the device.
+var readFunctions = map[uint8]bool{1: true, 2: true, 3: true, 4: true, 7: true, 11: true, 12: true, 17: true, 20: true, 24: true, 43: true}
+
+// AccessControl passes read requests to its handler and write requests
+// only if they are granted by a rule and do not touch read-only ranges.
+// Without any rule all writes are denied.
+type AccessControl struct {
+
+	// exception of denied requests (default ILLEGAL FUNCTION)
+	Exception uint8
+
+	// receives one logfmt line per write attempt
+	Audit Logger
+
+	handler  Handler
+	mu       sync.RWMutex
+	rules    []AccessRule
+	readOnly map[Table][]AddressRange
+}
+
+func NewAccessControl(h Handler) *AccessControl {
+	return &AccessControl{handler: h, readOnly: map[Table][]AddressRange{}}
+}
+
+// Allow adds a rule that grants write access.
+func (a *AccessControl) Allow(rule AccessRule) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rules = append(a.rules, rule)
+}
+
+// ReadOnly protects the coils or holding registers between start and end
+// (inclusive) from all clients.
+func (a *AccessControl) ReadOnly(t Table, start, end uint16) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.readOnly[t] = append(a.readOnly[t], AddressRange{start, end})
+}
+
+// writeRange returns the table and addresses written by the request.
+// Writes beyond the address space are reported with their range cut off
+// at 0xffff and ErrIllegalDataAddress.
+func writeRange(pdu *Pdu) (Table, AddressRange, bool, error) {
+	switch pdu.Function {
+	case 5:
+		req := &WriteSingleCoilRequest{}
+		if pdu.Decode(req) == nil {
+			return TableCoils, AddressRange{req.Address, req.Address}, true, nil
+		}
+	case 6:
+		req := &WriteSingleRegisterRequest{}
+		if pdu.Decode(req) == nil {
+			return TableHoldingRegisters, AddressRange{req.Address, req.Address}, true, nil
+		}
+	case 15:
+		req := &WriteMultipleCoilsRequest{}
+		if pdu.Decode(req) == nil {
+			r, err := span(req.Address, len(req.Values))
+			return TableCoils, r, true, err
+		}
+	case 16:
+		req := &WriteMultipleRegistersRequest{}
+		if pdu.Decode(req) == nil {
+			r, err := span(req.Address, len(req.Values))
+			return TableHoldingRegisters, r, true, err
+		}
+	case 22:
+		req := &MaskWriteRegisterRequest{}
+		if pdu.Decode(req) == nil {
+			return TableHoldingRegisters, AddressRange{req.Address, req.Address}, true, nil
+		}
+	case 23:
+		req := &ReadWriteMultipleRegistersRequest{}
+		if pdu.Decode(req) == nil {
+			r, err := span(req.WriteAddress, len(req.Values))
+			return TableHoldingRegisters, r, true, err
+		}
+	}
+	return 0, AddressRange{}, false, nil
+}
+
+// span returns the range of count addresses from start on.
+func span(start uint16, count int) (AddressRange, error) {
+	end := int(start) + count - 1
+	if end > 0xffff {
+		return AddressRange{start, 0xffff}, ErrIllegalDataAddress
+	}
+	return AddressRange{start, uint16(end)}, nil
+}
+
+// check returns the reason why the request is denied or an empty string.
+func (a *AccessControl) check(r *Request) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	granted := false
+	for i := range a.rules {
+		if a.rules[i].matches(r) {
+			granted = true
+			break
+		}
+	}
+	if !granted {
+		return "no rule"
+	}
+	if t, addr, ok, _ := writeRange(r.Pdu); ok {
+		for _, ro := range a.readOnly[t] {
+			if addr.Start <= ro.End && ro.Start <= addr.End {
+				return "read-only " + ro.String()
+			}
+		}
+	}
+	return ""
+}
+
+func (a *AccessControl) audit(r *Request, denied string, res *Pdu) {
+	if a.Audit == nil {
+		return
+	}
+	remote := ""
+	if r.RemoteAddr != nil {
+		remote = r.RemoteAddr.String()
+	}
+	result := "ok"
+	switch {
+	case denied != "":
+		result = "denied"
+	case res == nil:
+		result = "no response"
+	case res.Function&0x80 != 0 && len(res.Data) > 0:
+		result = ExceptionMessage(res.Data[0])
+	}
+	a.Audit.Printf("unit=%d remote=%q role=%q fn=%d name=%q req=\"% x\" result=%q reason=%q",
+		r.Unit, remote, r.Role, r.Pdu.Function, FunctionName(r.Pdu.Function), r.Pdu.Data, result, denied)
+}
+
+func (a *AccessControl) ServeModbus(r *Request) *Pdu {
+	if readFunctions[r.Pdu.Function] {
+		return serveRequest(a.handler, r)
+	}
+	if _, _, _, err := writeRange(r.Pdu); err != nil {
+		res := exceptionResponse(r.Pdu.Function, err)
+		a.audit(r, "beyond the address space", res)
+		return res
+	}
+	if reason := a.check(r); reason != "" {
+		exception := a.Exception
+		if exception == 0 {
+			exception = ExceptionIllegalFunction
+		}
+		res := exceptionPdu(r.Pdu.Function, exception)
+		a.audit(r, reason, res)
+		return res
+	}
+	res := serveRequest(a.handler, r)
+	a.audit(r, "", res)
+	return res
+}
+
+// Handle checks requests without metadata, so only rules without
+// networks and roles can grant write access.
+func (a *AccessControl) Handle(req *Pdu) *Pdu {
+	return a.ServeModbus(&Request{Pdu: req})
+}
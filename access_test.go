@@ -0,0 +1,90 @@
+package modbus
+
+import (
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"net"
+	"testing"
+)
+
+type auditLog []string
+
+func (l *auditLog) Printf(format string, v ...interface{}) {
+	*l = append(*l, fmt.Sprintf(format, v...))
+}
+
+func Test_AccessControl(t *testing.T) {
+
+	Convey("Given an access control in front of a data model", t, func() {
+		m := NewDataModel(10, 0, 0, 10)
+		a := NewAccessControl(m)
+		var log auditLog
+		a.Audit = &log
+		_, plant, _ := net.ParseCIDR("10.1.0.0/16")
+		a.Allow(AccessRule{Networks: []*net.IPNet{plant}, Functions: []uint8{6, 16}})
+		a.Allow(AccessRule{Roles: []string{"operator"}})
+		a.ReadOnly(TableHoldingRegisters, 8, 9)
+
+		req := func(ip, role string, pdu *Pdu) *Pdu {
+			return a.ServeModbus(&Request{RemoteAddr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}, Role: role, Pdu: pdu})
+		}
+		write := &Pdu{6, []byte{0, 1, 0, 7}}
+
+		Convey("reads should be allowed for everybody", func() {
+			So(req("192.168.1.1", "", &Pdu{3, []byte{0, 0, 0, 1}}), ShouldResemble, &Pdu{3, []byte{2, 0, 0}})
+			So(log, ShouldBeEmpty)
+		})
+
+		Convey("writes should be allowed by network and function code", func() {
+			So(req("10.1.2.3", "", write), ShouldResemble, write)
+			v, _ := m.ReadHoldingRegisters(1, 1)
+			So(v, ShouldResemble, []uint16{7})
+			So(req("10.1.2.3", "", &Pdu{5, []byte{0, 1, 0xff, 0}}), ShouldResemble, &Pdu{0x85, []byte{ExceptionIllegalFunction}})
+			So(req("10.2.0.1", "", write), ShouldResemble, &Pdu{0x86, []byte{ExceptionIllegalFunction}})
+		})
+
+		Convey("writes should be allowed by TLS role", func() {
+			So(req("192.168.1.1", "operator", &Pdu{5, []byte{0, 1, 0xff, 0}}), ShouldResemble, &Pdu{5, []byte{0, 1, 0xff, 0}})
+			So(req("192.168.1.1", "viewer", write), ShouldResemble, &Pdu{0x86, []byte{ExceptionIllegalFunction}})
+		})
+
+		Convey("read-only ranges should be protected from everybody", func() {
+			So(req("192.168.1.1", "operator", &Pdu{16, []byte{0, 7, 0, 2, 4, 0, 1, 0, 2}}), ShouldResemble, &Pdu{0x90, []byte{ExceptionIllegalFunction}})
+			So(req("192.168.1.1", "operator", &Pdu{16, []byte{0, 6, 0, 2, 4, 0, 1, 0, 2}}), ShouldResemble, &Pdu{16, []byte{0, 6, 0, 2}})
+		})
+
+		Convey("writes beyond the address space should be rejected before the rules", func() {
+			beyond := &Pdu{16, []byte{0xff, 0xff, 0, 2, 4, 0, 1, 0, 2}}
+			So(req("192.168.1.1", "operator", beyond), ShouldResemble, &Pdu{0x90, []byte{ExceptionIllegalDataAddress}})
+			So(req("192.168.1.1", "", beyond), ShouldResemble, &Pdu{0x90, []byte{ExceptionIllegalDataAddress}})
+			So(log[1], ShouldContainSubstring, `reason="beyond the address space"`)
+			_, r, _, err := writeRange(beyond)
+			So(r, ShouldResemble, AddressRange{0xffff, 0xffff})
+			So(err, ShouldResemble, ErrIllegalDataAddress)
+			_, r, _, err = writeRange(&Pdu{15, []byte{0xff, 0xfe, 0, 2, 1, 0x03}})
+			So(r, ShouldResemble, AddressRange{0xfffe, 0xffff})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("the exception of denied writes should be configurable", func() {
+			a.Exception = ExceptionIllegalDataAddress
+			So(req("192.168.1.1", "", write), ShouldResemble, &Pdu{0x86, []byte{ExceptionIllegalDataAddress}})
+		})
+
+		Convey("every write attempt should be audited", func() {
+			req("10.1.2.3", "", write)
+			req("10.1.2.3", "", &Pdu{6, []byte{0, 20, 0, 7}})
+			req("192.168.1.1", "", write)
+			So(len(log), ShouldEqual, 3)
+			So(log[0], ShouldEqual, `unit=0 remote="10.1.2.3:1234" role="" fn=6 name="Write Single Register" req="00 01 00 07" result="ok" reason=""`)
+			So(log[1], ShouldContainSubstring, `result="ILLEGAL DATA ADDRESS"`)
+			So(log[2], ShouldContainSubstring, `result="denied" reason="no rule"`)
+		})
+
+		Convey("requests without metadata should only match unrestricted rules", func() {
+			So(a.Handle(write), ShouldResemble, &Pdu{0x86, []byte{ExceptionIllegalFunction}})
+			a.Allow(AccessRule{Functions: []uint8{6}})
+			So(a.Handle(write), ShouldResemble, write)
+		})
+	})
+}
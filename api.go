@@ -91,17 +91,14 @@ type SerialClient interface {
 	// Function Code 11
 	GetCommEventCounter() (status bool, count uint16, err error)
 
-	// TODO: specify method
 	// Function Code 12
-	// GetCommEventLog
+	GetCommEventLog() (status bool, eventCount, messageCount uint16, events []byte, err error)
 
 	// Function Code 17
 	ReportServerId() (response []byte, err error)
 
-	// TODO: specify method
-	// Function Code 43
-	// ReadDeviceIdentification
-
+	// Function Code 43 / MEI Type 14
+	ReadDeviceIdentification(readCode, objectId uint8) (objects map[uint8]string, err error)
 }
 
 type IoClient interface {
@@ -168,7 +165,18 @@ type Handler interface {
 }
 
 type Server interface {
-	SetHandler(h *Handler)
+	SetHandler(h Handler)
 	Start() error
 	Stop() error
 }
+
+/* Read Device Identification codes */
+
+const (
+	DeviceIdBasic      uint8 = 0x01
+	DeviceIdRegular    uint8 = 0x02
+	DeviceIdExtended   uint8 = 0x03
+	DeviceIdIndividual uint8 = 0x04
+)
+
+const meiReadDeviceId = 0x0E
@@ -0,0 +1,160 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Modbus ASCII (serial line) implementation
+ */
+
+package modbus
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	asciiStart = ":"
+	asciiEnd   = "\r\n"
+
+	// ':' + 2 * (slave + function + 252 data bytes + LRC) + CRLF
+	asciiMaxLength = 513
+)
+
+type asciiAdu struct {
+	slave uint8
+	pdu   *Pdu
+}
+
+func lrc(data []byte) uint8 {
+	var sum uint8
+	for _, b := range data {
+		sum += b
+	}
+	return -sum
+}
+
+func (adu *asciiAdu) pack() (bin []byte, err error) {
+	binPdu, err := adu.pdu.pack()
+	if err != nil {
+		return
+	}
+	raw := append([]byte{adu.slave}, binPdu...)
+	raw = append(raw, lrc(raw))
+	frame := make([]byte, 0, len(asciiStart)+len(raw)*2+len(asciiEnd))
+	frame = append(frame, asciiStart...)
+	frame = append(frame, bytes.ToUpper([]byte(hex.EncodeToString(raw)))...)
+	return append(frame, asciiEnd...), nil
+}
+
+func unpackAsciiAdu(frame []byte) (*asciiAdu, error) {
+	if !bytes.HasPrefix(frame, []byte(asciiStart)) || !bytes.HasSuffix(frame, []byte(asciiEnd)) {
+		return nil, FramingError{"missing ASCII start or end characters"}
+	}
+	data := frame[len(asciiStart) : len(frame)-len(asciiEnd)]
+	if l := len(data); l < 6 || l%2 != 0 {
+		return nil, FramingError{fmt.Sprintf("invalid ASCII frame length: %d characters", l)}
+	}
+	raw := make([]byte, len(data)/2)
+	if _, err := hex.Decode(raw, data); err != nil {
+		return nil, FramingError{err.Error()}
+	}
+	l := len(raw)
+	if sum := lrc(raw[:l-1]); sum != raw[l-1] {
+		return nil, CRCError{uint16(sum), uint16(raw[l-1])}
+	}
+	pdu, err := unpackPdu(raw[1 : l-1])
+	if err != nil {
+		return nil, FramingError{err.Error()}
+	}
+	return &asciiAdu{raw[0], pdu}, nil
+}
+
+// readAsciiFrame reads up to and including the next line feed.
+// Characters in front of the start character are dropped.
+func readAsciiFrame(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, FramingError{"ASCII frame exceeds maximum length"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if i := bytes.LastIndex(line, []byte(asciiStart)); i > 0 {
+		line = line[i:]
+	}
+	return append([]byte{}, line...), nil
+}
+
+type asciiTransporter struct {
+	port    io.ReadWriter
+	reader  *bufio.Reader
+	slave   uint8
+	timeout time.Duration
+}
+
+func (t *asciiTransporter) Connect() error {
+	return nil
+}
+
+func (t *asciiTransporter) Close() error {
+	if c, ok := t.port.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (t *asciiTransporter) Send(pdu *Pdu) (*Pdu, error) {
+	return t.sendTo(t.slave, pdu)
+}
+
+func (t *asciiTransporter) sendTo(slave uint8, pdu *Pdu) (*Pdu, error) {
+	bin, err := (&asciiAdu{slave, pdu}).pack()
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := t.port.(deadliner); ok && t.timeout > 0 {
+		d.SetReadDeadline(time.Now().Add(t.timeout))
+	}
+	if _, err := t.port.Write(bin); err != nil {
+		return nil, transportError("write data", err)
+	}
+	if slave == 0 {
+		// broadcast requests are not answered
+		return nil, nil
+	}
+	if t.reader == nil {
+		t.reader = bufio.NewReaderSize(t.port, asciiMaxLength)
+	}
+	frame, err := readAsciiFrame(t.reader)
+	if err != nil {
+		if _, ok := err.(FramingError); ok {
+			return nil, err
+		}
+		return nil, transportError("receive data", err)
+	}
+	res, err := unpackAsciiAdu(frame)
+	if err != nil {
+		return nil, err
+	}
+	if res.slave != slave {
+		return nil, FramingError{fmt.Sprintf("slave id %d instead of %d", res.slave, slave)}
+	}
+	return res.pdu, nil
+}
+
+// NewAsciiTransporter creates a transporter on port. The timeout also
+// applies to ports without read deadlines.
+func NewAsciiTransporter(port io.ReadWriter, slave uint8, timeout time.Duration) Transporter {
+	return &asciiTransporter{port: withReadDeadline(port), slave: slave, timeout: timeout}
+}
+
+func NewAsciiClient(port io.ReadWriter, slave uint8, mw ...Middleware) SerialClient {
+	return NewAsciiClientTimeout(port, slave, 0, mw...)
+}
+
+func NewAsciiClientTimeout(port io.ReadWriter, slave uint8, timeout time.Duration, mw ...Middleware) SerialClient {
+	return &mbClient{Chain(NewAsciiTransporter(port, slave, timeout), mw...)}
+}
@@ -0,0 +1,84 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Modbus ASCII server (slave) on a serial line
+ */
+
+package modbus
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// AsciiServer answers the requests to its units on a serial line.
+// Only the units set by SetUnitHandler are answered. Requests to other
+// units and corrupted frames are ignored, broadcasts are passed to every
+// unit handler without being answered.
+type AsciiServer struct {
+	units
+	background
+	port io.ReadWriter
+}
+
+func NewAsciiServer(port io.ReadWriter) *AsciiServer {
+	s := &AsciiServer{port: port}
+	s.unknown = UnknownUnitSilent
+	s.explicit = true
+	return s
+}
+
+// Serve reads requests from the port until reading fails.
+func (s *AsciiServer) Serve() error {
+	r := bufio.NewReaderSize(s.port, asciiMaxLength)
+	for {
+		frame, err := readAsciiFrame(r)
+		if _, ok := err.(FramingError); ok {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.handle(frame); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *AsciiServer) handle(frame []byte) error {
+	adu, err := unpackAsciiAdu(frame)
+	if err != nil {
+		return nil
+	}
+	r := &Request{Unit: adu.slave, Transport: FramingASCII, Pdu: adu.pdu}
+	if adu.slave == 0 {
+		s.broadcast(r)
+		return nil
+	}
+	res := s.serve(r)
+	if res == nil {
+		return nil
+	}
+	out, err := (&asciiAdu{adu.slave, res}).pack()
+	if err != nil {
+		return nil
+	}
+	_, err = s.port.Write(out)
+	return err
+}
+
+// Start serves the port in the background. The error that stops it is
+// returned by Err.
+func (s *AsciiServer) Start() error {
+	s.start(s.Serve)
+	return nil
+}
+
+// Stop closes the port.
+func (s *AsciiServer) Stop() error {
+	if c, ok := s.port.(io.Closer); ok {
+		return c.Close()
+	}
+	return errors.New("Port can not be closed")
+}
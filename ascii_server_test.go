@@ -0,0 +1,61 @@
+package modbus
+
+import (
+	"bufio"
+	. "github.com/smartystreets/goconvey/convey"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_AsciiServer(t *testing.T) {
+
+	Convey("Given an ascii server on a line", t, func() {
+		line, port := net.Pipe()
+		defer line.Close()
+		s := NewAsciiServer(port)
+		m := NewDataModel(0, 0, 0, 10)
+		m.WriteHoldingRegisters(0, []uint16{0x1234})
+		s.SetUnitHandler(17, m)
+		s.Start()
+		defer s.Stop()
+		r := bufio.NewReader(line)
+
+		readLine := func() string {
+			line.SetReadDeadline(time.Now().Add(time.Second))
+			l, err := r.ReadString('\n')
+			So(err, ShouldBeNil)
+			return l
+		}
+
+		Convey("requests to its units should be answered", func() {
+			line.Write([]byte(":110300000001EB\r\n"))
+			So(readLine(), ShouldEqual, ":1103021234A4\r\n")
+		})
+
+		Convey("lower case hex digits should be accepted", func() {
+			line.Write([]byte(":110300000001eb\r\n"))
+			So(readLine(), ShouldEqual, ":1103021234A4\r\n")
+		})
+
+		Convey("unknown function codes should be answered with an exception", func() {
+			line.Write([]byte(":114101AD\r\n"))
+			So(readLine(), ShouldEqual, ":11C1012D\r\n")
+		})
+
+		Convey("invalid frames and other units should be ignored", func() {
+			line.Write([]byte(":110300000001EC\r\n"))
+			line.Write([]byte(":120300000001EA\r\n"))
+			line.Write([]byte("garbage\r\n"))
+			line.Write([]byte(":11030000:110300000001EB\r\n"))
+			So(readLine(), ShouldEqual, ":1103021234A4\r\n")
+		})
+
+		Convey("broadcasts should be executed but not answered", func() {
+			bin, _ := (&asciiAdu{0, &Pdu{6, []byte{0, 1, 0, 7}}}).pack()
+			line.Write(bin)
+			line.Write([]byte(":110300010001EA\r\n"))
+			So(readLine(), ShouldEqual, ":1103020007E3\r\n")
+		})
+	})
+}
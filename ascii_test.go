@@ -0,0 +1,60 @@
+package modbus
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func Test_Ascii(t *testing.T) {
+
+	Convey("Given an ascii adu struct", t, func() {
+		adu := &asciiAdu{0x11, &Pdu{3, []byte{0x00, 0x6b, 0x00, 0x03}}}
+
+		Convey("When we pack it", func() {
+			bin, _ := adu.pack()
+
+			Convey("it should be hex encoded with LRC", func() {
+				So(string(bin), ShouldEqual, ":1103006B00037E\r\n")
+			})
+		})
+	})
+
+	Convey("Given an ascii frame", t, func() {
+		frame := []byte(":1103006B00037E\r\n")
+
+		Convey("When we unpack it", func() {
+			adu, err := unpackAsciiAdu(frame)
+
+			Convey("the slave and pdu should be decoded", func() {
+				So(err, ShouldBeNil)
+				So(adu.slave, ShouldEqual, 0x11)
+				So(adu.pdu, ShouldResemble, &Pdu{3, []byte{0x00, 0x6b, 0x00, 0x03}})
+			})
+		})
+
+		Convey("When the LRC is invalid", func() {
+			_, err := unpackAsciiAdu([]byte(":1103006B00037F\r\n"))
+			So(err, ShouldResemble, CRCError{0x7e, 0x7f})
+		})
+
+		Convey("When the frame is incomplete", func() {
+			_, err := unpackAsciiAdu([]byte("1103006B00037E\r\n"))
+			So(err, ShouldHaveSameTypeAs, FramingError{})
+		})
+	})
+
+	Convey("Given an ascii client", t, func() {
+		port := &dummyPort{}
+		c := NewAsciiClient(port, 0x11)
+
+		Convey("the response should be read", func() {
+			port.handle = func(frame []byte) []byte {
+				bin, _ := (&asciiAdu{0x11, &Pdu{3, []byte{0x02, 0x01, 0x02}}}).pack()
+				return append([]byte("\x00"), bin...)
+			}
+			v, err := c.ReadHoldingRegisters(0x6b, 1)
+			So(err, ShouldBeNil)
+			So(v, ShouldResemble, []uint16{0x0102})
+		})
+	})
+}
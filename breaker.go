@@ -0,0 +1,157 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Circuit breaker for failing devices
+ */
+
+package modbus
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without sending the request while the
+// circuit breaker of a device is open.
+var ErrCircuitOpen = errors.New("Circuit breaker open")
+
+type CircuitState uint8
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreaker stops sending requests to a device after consecutive
+// failures. After the cooldown a single probe request is sent; the
+// circuit is closed again if it succeeds. Timeouts, transport and
+// framing errors and gateway exceptions count as failures. Use one per
+// device.
+type CircuitBreaker struct {
+
+	// number of consecutive failures that open the circuit
+	Threshold int
+
+	// time the circuit stays open before it is probed
+	Cooldown time.Duration
+
+	// called after every state change
+	OnStateChange func(from, to CircuitState)
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{Threshold: threshold, Cooldown: cooldown}
+}
+
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// setState must be called with the lock held. It returns a function that
+// reports the change.
+func (b *CircuitBreaker) setState(s CircuitState) func() {
+	from := b.state
+	b.state = s
+	if s == CircuitOpen {
+		b.openedAt = time.Now()
+	}
+	if from == s || b.OnStateChange == nil {
+		return func() {}
+	}
+	fn := b.OnStateChange
+	return func() { fn(from, s) }
+}
+
+// allow reports whether a request may be sent.
+func (b *CircuitBreaker) allow() bool {
+	b.mu.Lock()
+	report := func() {}
+	defer func() {
+		b.mu.Unlock()
+		report()
+	}()
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.Cooldown {
+			return false
+		}
+		report = b.setState(CircuitHalfOpen)
+		b.probing = true
+		return true
+	case CircuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+func (b *CircuitBreaker) done(failed bool) {
+	b.mu.Lock()
+	report := func() {}
+	defer func() {
+		b.mu.Unlock()
+		report()
+	}()
+	b.probing = false
+	if !failed {
+		b.failures = 0
+		report = b.setState(CircuitClosed)
+		return
+	}
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.Threshold {
+		report = b.setState(CircuitOpen)
+	}
+}
+
+// deviceFailed reports whether the result shows that the device is not
+// reachable.
+func deviceFailed(req, res *Pdu, err error) bool {
+	switch err.(type) {
+	case nil:
+	case TimeoutError, TransportError, FramingError, CRCError:
+		return true
+	default:
+		return false
+	}
+	if res != nil && res.Function == req.Function|0x80 && len(res.Data) > 0 {
+		e := res.Data[0]
+		return e == ExceptionGatewayPathUnavailable || e == ExceptionGatewayTargetDeviceFailedToRespond
+	}
+	return false
+}
+
+func (b *CircuitBreaker) Middleware() Middleware {
+	return WrapSend(func(next Transporter, req *Pdu) (*Pdu, error) {
+		if !b.allow() {
+			return nil, ErrCircuitOpen
+		}
+		res, err := next.Send(req)
+		b.done(deviceFailed(req, res, err))
+		return res, err
+	})
+}
@@ -0,0 +1,113 @@
+package modbus
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+// flakyTransporter fails while offline
+type flakyTransporter struct {
+	offline bool
+	sent    int
+}
+
+func (t *flakyTransporter) Connect() error {
+	return nil
+}
+
+func (t *flakyTransporter) Close() error {
+	return nil
+}
+
+func (t *flakyTransporter) Send(pdu *Pdu) (*Pdu, error) {
+	t.sent++
+	if t.offline {
+		return nil, TimeoutError{"receive data", nil}
+	}
+	return &Pdu{pdu.Function, []byte{2, 0, 0}}, nil
+}
+
+func exception(code uint8) func(pdu *Pdu) (*Pdu, error) {
+	return func(pdu *Pdu) (*Pdu, error) {
+		return &Pdu{pdu.Function | 0x80, []byte{code}}, nil
+	}
+}
+
+func Test_CircuitBreaker(t *testing.T) {
+
+	Convey("Given a device behind a circuit breaker", t, func() {
+		dev := &flakyTransporter{}
+		b := NewCircuitBreaker(3, 20*time.Millisecond)
+		var changes []string
+		b.OnStateChange = func(from, to CircuitState) {
+			changes = append(changes, from.String()+"->"+to.String())
+		}
+		c := NewClient(dev, b.Middleware())
+
+		Convey("it should open after consecutive failures", func() {
+			dev.offline = true
+			for i := 0; i < 3; i++ {
+				_, err := c.ReadHoldingRegisters(0, 1)
+				So(err, ShouldHaveSameTypeAs, TimeoutError{})
+			}
+			So(b.State(), ShouldEqual, CircuitOpen)
+			_, err := c.ReadHoldingRegisters(0, 1)
+			So(err, ShouldEqual, ErrCircuitOpen)
+			So(dev.sent, ShouldEqual, 3)
+			So(changes, ShouldResemble, []string{"closed->open"})
+		})
+
+		Convey("successful requests should reset the failures", func() {
+			dev.offline = true
+			c.ReadHoldingRegisters(0, 1)
+			c.ReadHoldingRegisters(0, 1)
+			dev.offline = false
+			c.ReadHoldingRegisters(0, 1)
+			dev.offline = true
+			c.ReadHoldingRegisters(0, 1)
+			So(b.State(), ShouldEqual, CircuitClosed)
+		})
+
+		Convey("exceptions of the device should not count", func() {
+			b.Threshold = 1
+			c := NewClient(&dummyTransporter{send: exception(ExceptionIllegalDataAddress)}, b.Middleware())
+			c.ReadHoldingRegisters(0, 1)
+			So(b.State(), ShouldEqual, CircuitClosed)
+			c = NewClient(&dummyTransporter{send: exception(ExceptionGatewayTargetDeviceFailedToRespond)}, b.Middleware())
+			c.ReadHoldingRegisters(0, 1)
+			So(b.State(), ShouldEqual, CircuitOpen)
+		})
+
+		Convey("after the cooldown", func() {
+			dev.offline = true
+			for i := 0; i < 3; i++ {
+				c.ReadHoldingRegisters(0, 1)
+			}
+			time.Sleep(25 * time.Millisecond)
+
+			Convey("a successful probe should close it", func() {
+				dev.offline = false
+				_, err := c.ReadHoldingRegisters(0, 1)
+				So(err, ShouldBeNil)
+				So(b.State(), ShouldEqual, CircuitClosed)
+				So(changes, ShouldResemble, []string{"closed->open", "open->half-open", "half-open->closed"})
+			})
+
+			Convey("a failed probe should open it again", func() {
+				_, err := c.ReadHoldingRegisters(0, 1)
+				So(err, ShouldHaveSameTypeAs, TimeoutError{})
+				So(b.State(), ShouldEqual, CircuitOpen)
+				_, err = c.ReadHoldingRegisters(0, 1)
+				So(err, ShouldEqual, ErrCircuitOpen)
+				So(dev.sent, ShouldEqual, 4)
+			})
+
+			Convey("only one probe should be sent at a time", func() {
+				So(b.allow(), ShouldBeTrue)
+				So(b.State(), ShouldEqual, CircuitHalfOpen)
+				So(b.allow(), ShouldBeFalse)
+			})
+		})
+	})
+}
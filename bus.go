@@ -0,0 +1,192 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Shared serial bus for several slaves
+ */
+
+package modbus
+
+import (
+	"sync"
+	"time"
+)
+
+type Priority int
+
+const (
+	// e.g. background polling
+	PriorityLow Priority = iota
+	PriorityNormal
+	// used for all write requests
+	PriorityHigh
+)
+
+type busTicket struct {
+	priority Priority
+	seq      uint64
+	queued   time.Time
+	ready    chan struct{}
+}
+
+// Bus owns a serial line and hands out clients for its slaves. The
+// transactions are performed one after another, ordered by priority and
+// then by arrival. Waiting transactions gain priority over time, so that
+// low priorities are not starved.
+type Bus struct {
+
+	// silence between the end of a transaction and the next request
+	// (OpenRtuBus sets t3.5)
+	Gap time.Duration
+
+	// time after which a waiting transaction is raised by one priority
+	// (default 1s, 0 disables aging)
+	Aging time.Duration
+
+	t     unitTransporter
+	close func() error
+
+	mu    sync.Mutex
+	busy  bool
+	seq   uint64
+	queue []*busTicket
+	last  time.Time
+}
+
+// NewBus creates a bus on a transporter that can address several units,
+// e.g. one created by NewRtuTransporter or NewAsciiTransporter. Set Gap
+// to keep the silent interval of RTU lines.
+func NewBus(t Transporter) (*Bus, error) {
+	if !addressesUnits(t) {
+		return nil, errNoUnits
+	}
+	return &Bus{Aging: time.Second, t: t.(unitTransporter), close: t.Close}, nil
+}
+
+func OpenRtuBus(c *SerialConfig) (*Bus, error) {
+	t, err := OpenRtuTransporter(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	b, err := NewBus(t)
+	if err != nil {
+		return nil, err
+	}
+	b.Gap = c.Timing().T35
+	return b, nil
+}
+
+func OpenAsciiBus(c *SerialConfig) (*Bus, error) {
+	t, err := OpenAsciiTransporter(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	return NewBus(t)
+}
+
+// acquire waits until the line is free and silent for Gap.
+func (b *Bus) acquire(p Priority) {
+	b.mu.Lock()
+	if !b.busy && len(b.queue) == 0 {
+		b.busy = true
+	} else {
+		t := &busTicket{p, b.seq, time.Now(), make(chan struct{})}
+		b.seq++
+		b.queue = append(b.queue, t)
+		b.mu.Unlock()
+		<-t.ready
+		b.mu.Lock()
+	}
+	wait := time.Until(b.last.Add(b.Gap))
+	b.mu.Unlock()
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+func (b *Bus) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.last = time.Now()
+	if len(b.queue) == 0 {
+		b.busy = false
+		return
+	}
+	close(b.next().ready)
+}
+
+// priority returns the priority of a waiting transaction including the
+// levels it gained by aging.
+func (b *Bus) priority(t *busTicket, now time.Time) Priority {
+	if b.Aging <= 0 {
+		return t.priority
+	}
+	return t.priority + Priority(now.Sub(t.queued)/b.Aging)
+}
+
+// next removes the waiting transaction with the highest priority from the
+// queue, the oldest one of equal priorities.
+func (b *Bus) next() *busTicket {
+	now := time.Now()
+	best := 0
+	for i, t := range b.queue[1:] {
+		p, q := b.priority(t, now), b.priority(b.queue[best], now)
+		if p > q || p == q && t.seq < b.queue[best].seq {
+			best = i + 1
+		}
+	}
+	t := b.queue[best]
+	b.queue = append(b.queue[:best], b.queue[best+1:]...)
+	return t
+}
+
+// Transporter returns a transporter for the slave. Its read requests are
+// queued with the given priority, write requests with PriorityHigh.
+func (b *Bus) Transporter(slave uint8, p Priority) Transporter {
+	return &slaveTransporter{bus: b, slave: slave, priority: p}
+}
+
+// Client returns a client for the slave with PriorityNormal.
+func (b *Bus) Client(slave uint8, mw ...Middleware) IoClient {
+	return NewClient(b.Transporter(slave, PriorityNormal), mw...)
+}
+
+// Close closes the serial line.
+func (b *Bus) Close() error {
+	return b.close()
+}
+
+type slaveTransporter struct {
+	bus      *Bus
+	slave    uint8
+	priority Priority
+
+	// response timeout of the slave, 0 uses the one of the line
+	timeout time.Duration
+}
+
+// Connect does nothing since the line is owned by the bus.
+func (t *slaveTransporter) Connect() error {
+	return nil
+}
+
+// Close does nothing since the line is owned by the bus.
+func (t *slaveTransporter) Close() error {
+	return nil
+}
+
+func (t *slaveTransporter) Send(pdu *Pdu) (*Pdu, error) {
+	return t.sendTo(t.slave, pdu)
+}
+
+func (t *slaveTransporter) sendTo(unit uint8, pdu *Pdu) (*Pdu, error) {
+	p := t.priority
+	if !readFunctions[pdu.Function] && p < PriorityHigh {
+		p = PriorityHigh
+	}
+	t.bus.acquire(p)
+	defer t.bus.release()
+	if line, ok := t.bus.t.(timeoutTransporter); ok && t.timeout > 0 {
+		defer line.setTimeout(line.setTimeout(t.timeout))
+	}
+	return t.bus.t.sendTo(unit, pdu)
+}
@@ -0,0 +1,155 @@
+package modbus
+
+import (
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lineTransporter records the transactions and blocks until released
+type lineTransporter struct {
+	mu      sync.Mutex
+	log     []string
+	release chan struct{}
+}
+
+func (t *lineTransporter) Connect() error {
+	return nil
+}
+
+func (t *lineTransporter) Close() error {
+	return nil
+}
+
+func (t *lineTransporter) Send(pdu *Pdu) (*Pdu, error) {
+	return t.sendTo(0, pdu)
+}
+
+func (t *lineTransporter) sendTo(unit uint8, pdu *Pdu) (*Pdu, error) {
+	t.mu.Lock()
+	t.log = append(t.log, fmt.Sprintf("%d:%d", unit, pdu.Function))
+	t.mu.Unlock()
+	<-t.release
+	return &Pdu{pdu.Function, []byte{0}}, nil
+}
+
+func Test_Bus(t *testing.T) {
+
+	Convey("Given a bus with a busy line", t, func() {
+		line := &lineTransporter{release: make(chan struct{})}
+		bus, err := NewBus(line)
+		So(err, ShouldBeNil)
+		var wg sync.WaitGroup
+		send := func(t Transporter, pdu *Pdu) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				t.Send(pdu)
+			}()
+		}
+		queued := func(n int) {
+			for i := 0; i < 100; i++ {
+				bus.mu.Lock()
+				l := len(bus.queue)
+				bus.mu.Unlock()
+				if l == n {
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+		send(bus.Transporter(1, PriorityNormal), &Pdu{3, nil})
+		for {
+			line.mu.Lock()
+			l := len(line.log)
+			line.mu.Unlock()
+			if l == 1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		Convey("waiting transactions should be ordered by priority and arrival", func() {
+			send(bus.Transporter(2, PriorityLow), &Pdu{3, nil})
+			queued(1)
+			send(bus.Transporter(3, PriorityNormal), &Pdu{4, nil})
+			queued(2)
+			send(bus.Transporter(4, PriorityLow), &Pdu{6, nil})
+			queued(3)
+			send(bus.Transporter(5, PriorityNormal), &Pdu{1, nil})
+			queued(4)
+			for i := 0; i < 5; i++ {
+				line.release <- struct{}{}
+			}
+			wg.Wait()
+			So(line.log, ShouldResemble, []string{"1:3", "4:6", "3:4", "5:1", "2:3"})
+		})
+
+		Convey("low priorities should gain priority while they wait", func() {
+			bus.Aging = 10 * time.Millisecond
+			send(bus.Transporter(2, PriorityLow), &Pdu{3, nil})
+			queued(1)
+			time.Sleep(30 * time.Millisecond)
+			send(bus.Transporter(3, PriorityNormal), &Pdu{3, nil})
+			queued(2)
+			send(bus.Transporter(4, PriorityHigh), &Pdu{3, nil})
+			queued(3)
+			for i := 0; i < 4; i++ {
+				line.release <- struct{}{}
+			}
+			wg.Wait()
+			So(line.log, ShouldResemble, []string{"1:3", "2:3", "4:3", "3:3"})
+		})
+
+		Convey("the next transaction should wait for the gap", func() {
+			bus.mu.Lock()
+			bus.Gap = 30 * time.Millisecond
+			bus.mu.Unlock()
+			send(bus.Transporter(2, PriorityNormal), &Pdu{3, nil})
+			queued(1)
+			line.release <- struct{}{}
+			start := time.Now()
+			line.release <- struct{}{}
+			wg.Wait()
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 25*time.Millisecond)
+		})
+	})
+
+	Convey("Given a transporter that can not address units", t, func() {
+		_, err := NewBus(&dummyTransporter{})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Given a rtu bus with several slaves", t, func() {
+		conf := &SerialConfig{Address: "bus", BaudRate: 115200, Timeout: time.Second, Backend: NewLoopback()}
+		s, err := OpenRtuServer(conf)
+		So(err, ShouldBeNil)
+		for unit := uint8(1); unit <= 3; unit++ {
+			m := NewDataModel(0, 0, 0, 1)
+			m.WriteHoldingRegisters(0, []uint16{uint16(unit)})
+			s.SetUnitHandler(unit, m)
+		}
+		s.Start()
+		defer s.Stop()
+		bus, err := OpenRtuBus(conf)
+		So(err, ShouldBeNil)
+		defer bus.Close()
+		So(bus.Gap, ShouldEqual, conf.Timing().T35)
+
+		Convey("concurrent clients should get the responses of their slaves", func() {
+			var wg sync.WaitGroup
+			results := make([][]uint16, 3)
+			for i := range results {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					results[i], _ = bus.Client(uint8(i+1)).ReadHoldingRegisters(0, 1)
+				}(i)
+			}
+			wg.Wait()
+			So(results, ShouldResemble, [][]uint16{{1}, {2}, {3}})
+		})
+	})
+}
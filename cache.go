@@ -0,0 +1,225 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Read-through cache for clients
+ */
+
+package modbus
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// tables of the read function codes
+var readTables = map[uint8]Table{
+	1: TableCoils,
+	2: TableDiscreteInputs,
+	3: TableHoldingRegisters,
+	4: TableInputRegisters,
+}
+
+// readRange returns the table and the addresses of a read request.
+func readRange(pdu *Pdu) (Table, AddressRange, bool) {
+	t, ok := readTables[pdu.Function]
+	if !ok || len(pdu.Data) != 4 {
+		return 0, AddressRange{}, false
+	}
+	addr := binary.BigEndian.Uint16(pdu.Data)
+	count := binary.BigEndian.Uint16(pdu.Data[2:])
+	if count == 0 || int(addr)+int(count) > 0x10000 {
+		return 0, AddressRange{}, false
+	}
+	return t, AddressRange{addr, addr + count - 1}, true
+}
+
+func overlaps(a, b AddressRange) bool {
+	return a.Start <= b.End && b.Start <= a.End
+}
+
+type cacheTTL struct {
+	table     Table
+	addresses AddressRange
+	ttl       time.Duration
+}
+
+type cacheEntry struct {
+	table     Table
+	addresses AddressRange
+	res       *Pdu
+	expires   time.Time
+}
+
+// cacheCall is a read request in flight that identical requests wait for.
+type cacheCall struct {
+	table     Table
+	addresses AddressRange
+	done      chan struct{}
+	res       *Pdu
+	err       error
+}
+
+// Cache serves read requests from the responses of previous ones until
+// their TTL expires. Writes invalidate the cached ranges they touch and
+// identical reads that are sent at the same time share one transaction.
+// Responses are kept per unit id, so the middleware of a transporter that
+// is shared by a Bus serves all of its units.
+type Cache struct {
+
+	// default TTL of the tables without one (0 disables caching)
+	TTL time.Duration
+
+	mu       sync.Mutex
+	tables   map[Table]time.Duration
+	ranges   []cacheTTL
+	entries  map[string]*cacheEntry
+	inflight map[string]*cacheCall
+
+	// incremented by every write
+	gen uint64
+}
+
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{
+		TTL:      ttl,
+		tables:   map[Table]time.Duration{},
+		entries:  map[string]*cacheEntry{},
+		inflight: map[string]*cacheCall{},
+	}
+}
+
+// SetTableTTL sets the TTL of a table. A TTL of 0 disables caching.
+func (c *Cache) SetTableTTL(t Table, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tables[t] = ttl
+}
+
+// SetRangeTTL sets the TTL of an address range. It takes precedence over
+// the TTL of the table; if a request overlaps several ranges the shortest
+// TTL is used.
+func (c *Cache) SetRangeTTL(t Table, start, end uint16, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ranges = append(c.ranges, cacheTTL{t, AddressRange{start, end}, ttl})
+}
+
+func (c *Cache) ttl(t Table, r AddressRange) time.Duration {
+	ttl, found := time.Duration(0), false
+	for _, x := range c.ranges {
+		if x.table == t && overlaps(x.addresses, r) && (!found || x.ttl < ttl) {
+			ttl, found = x.ttl, true
+		}
+	}
+	if found {
+		return ttl
+	}
+	if ttl, ok := c.tables[t]; ok {
+		return ttl
+	}
+	return c.TTL
+}
+
+// Invalidate drops all cached responses.
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.entries = map[string]*cacheEntry{}
+	c.inflight = map[string]*cacheCall{}
+}
+
+// InvalidateRange drops the cached responses that overlap the range.
+func (c *Cache) InvalidateRange(t Table, start, end uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invalidate(t, AddressRange{start, end})
+}
+
+func (c *Cache) invalidate(t Table, r AddressRange) {
+	c.gen++
+	for k, e := range c.entries {
+		if e.table == t && overlaps(e.addresses, r) {
+			delete(c.entries, k)
+		}
+	}
+	// reads in flight may return the old values, later ones must not
+	// wait for them
+	for k, call := range c.inflight {
+		if call.table == t && overlaps(call.addresses, r) {
+			delete(c.inflight, k)
+		}
+	}
+}
+
+func copyPdu(p *Pdu) *Pdu {
+	return &Pdu{p.Function, append([]byte{}, p.Data...)}
+}
+
+func (c *Cache) read(next Transporter, req *Pdu, t Table, r AddressRange) (*Pdu, error) {
+	// requests of a bus to other units are passed on by a unitSender
+	key := string(append([]byte{unitOf(next), req.Function}, req.Data...))
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		if time.Now().Before(e.expires) {
+			c.mu.Unlock()
+			return copyPdu(e.res), nil
+		}
+		delete(c.entries, key)
+	}
+	if call, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		<-call.done
+		if call.res == nil {
+			return nil, call.err
+		}
+		return copyPdu(call.res), call.err
+	}
+	call := &cacheCall{table: t, addresses: r, done: make(chan struct{})}
+	c.inflight[key] = call
+	gen := c.gen
+	c.mu.Unlock()
+
+	call.res, call.err = next.Send(req)
+
+	c.mu.Lock()
+	if c.inflight[key] == call {
+		delete(c.inflight, key)
+	}
+	// responses are only cached if no write happened in the meantime
+	ok := call.err == nil && call.res != nil && call.res.Function == req.Function && gen == c.gen
+	if ttl := c.ttl(t, r); ok && ttl > 0 {
+		c.entries[key] = &cacheEntry{t, r, copyPdu(call.res), time.Now().Add(ttl)}
+	}
+	c.mu.Unlock()
+	close(call.done)
+	if call.res == nil {
+		return nil, call.err
+	}
+	return copyPdu(call.res), call.err
+}
+
+func (c *Cache) Middleware() Middleware {
+	return WrapSend(func(next Transporter, req *Pdu) (*Pdu, error) {
+		if t, r, ok := readRange(req); ok {
+			return c.read(next, req, t, r)
+		}
+		// writes beyond the address space invalidate up to its end
+		t, r, write, _ := writeRange(req)
+		if !write {
+			return next.Send(req)
+		}
+		// invalidate before the write for the reads in the meantime and
+		// after it for the reads that were sent before
+		c.InvalidateRange(t, r.Start, r.End)
+		defer c.InvalidateRange(t, r.Start, r.End)
+		return next.Send(req)
+	})
+}
+
+// Client returns a client that uses the transporter of the given one
+// with the cache.
+func (c *Cache) Client(client Client) IoClient {
+	return NewClient(client.Transporter(), c.Middleware())
+}
@@ -0,0 +1,160 @@
+package modbus
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingTransporter answers with a model and counts the requests
+type countingTransporter struct {
+	mu    sync.Mutex
+	model *DataModel
+	sent  int
+	delay time.Duration
+}
+
+func (t *countingTransporter) Connect() error {
+	return nil
+}
+
+func (t *countingTransporter) Close() error {
+	return nil
+}
+
+func (t *countingTransporter) Send(pdu *Pdu) (*Pdu, error) {
+	t.mu.Lock()
+	t.sent++
+	t.mu.Unlock()
+	// reads are delayed after the values were taken
+	res := t.model.Handle(pdu)
+	if readFunctions[pdu.Function] {
+		time.Sleep(t.delay)
+	}
+	return res, nil
+}
+
+func (t *countingTransporter) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.sent
+}
+
+func Test_Cache(t *testing.T) {
+
+	Convey("Given a client with a cache", t, func() {
+		dev := &countingTransporter{model: NewDataModel(10, 10, 10, 10)}
+		cache := NewCache(time.Minute)
+		client := cache.Client(NewClient(dev))
+
+		Convey("repeated reads should be served from the cache", func() {
+			dev.model.holdingRegisters[1] = 7
+			for i := 0; i < 3; i++ {
+				v, err := client.HoldingRegister(1).Read()
+				So(err, ShouldBeNil)
+				So(v, ShouldEqual, 7)
+			}
+			So(dev.count(), ShouldEqual, 1)
+			client.InputRegister(1).Read()
+			client.HoldingRegisters(1, 2).Read()
+			So(dev.count(), ShouldEqual, 3)
+		})
+
+		Convey("writes should invalidate overlapping ranges", func() {
+			client.HoldingRegisters(0, 4).Read()
+			client.HoldingRegisters(5, 2).Read()
+			client.Coil(2).Test()
+			So(client.HoldingRegister(3).Write(9), ShouldBeNil)
+			v, _ := client.HoldingRegisters(0, 4).Read()
+			So(v, ShouldResemble, []uint16{0, 0, 0, 9})
+			client.HoldingRegisters(5, 2).Read()
+			client.Coil(2).Test()
+			So(dev.count(), ShouldEqual, 5)
+			client.Coil(2).Set()
+			on, _ := client.Coil(2).Test()
+			So(on, ShouldBeTrue)
+		})
+
+		Convey("responses should expire after their TTL", func() {
+			cache.SetTableTTL(TableInputRegisters, 10*time.Millisecond)
+			cache.SetRangeTTL(TableHoldingRegisters, 5, 9, 0)
+			client.InputRegister(0).Read()
+			client.InputRegister(0).Read()
+			So(dev.count(), ShouldEqual, 1)
+			time.Sleep(15 * time.Millisecond)
+			client.InputRegister(0).Read()
+			So(dev.count(), ShouldEqual, 2)
+			client.HoldingRegisters(4, 2).Read()
+			client.HoldingRegisters(4, 2).Read()
+			So(dev.count(), ShouldEqual, 4)
+		})
+
+		Convey("exceptions should not be cached", func() {
+			_, err := client.HoldingRegister(20).Read()
+			So(err, ShouldResemble, Error{0x83, ExceptionIllegalDataAddress})
+			client.HoldingRegister(20).Read()
+			So(dev.count(), ShouldEqual, 2)
+		})
+
+		Convey("concurrent identical reads should share one request", func() {
+			dev.delay = 50 * time.Millisecond
+			cache.TTL = 0
+			var wg sync.WaitGroup
+			for i := 0; i < 5; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					client.HoldingRegisters(0, 2).Read()
+				}()
+			}
+			wg.Wait()
+			So(dev.count(), ShouldEqual, 1)
+		})
+
+		Convey("reads after a write should not wait for a read in flight", func() {
+			dev.delay = 50 * time.Millisecond
+			dev.model.holdingRegisters[1] = 1
+			done := make(chan uint16)
+			go func() {
+				v, _ := client.HoldingRegister(1).Read()
+				done <- v
+			}()
+			for dev.count() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			So(client.HoldingRegister(1).Write(2), ShouldBeNil)
+			v, err := client.HoldingRegister(1).Read()
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, 2)
+			So(<-done, ShouldEqual, 1)
+			v, _ = client.HoldingRegister(1).Read()
+			So(v, ShouldEqual, 2)
+			So(dev.count(), ShouldEqual, 3)
+		})
+	})
+
+	Convey("Given a bus with a cache below it", t, func() {
+		sent := 0
+		port := &dummyPort{handle: func(frame []byte) []byte {
+			sent++
+			// every unit answers with its id
+			return rtuFrame(frame[0], &Pdu{3, []byte{2, 0, frame[0]}})
+		}}
+		cache := NewCache(time.Minute)
+		bus, err := NewBus(Chain(NewRtuTransporter(port, 0, 0), cache.Middleware()))
+		So(err, ShouldBeNil)
+
+		Convey("the responses of the units should be kept apart", func() {
+			for i := 0; i < 2; i++ {
+				v, err := bus.Client(1).HoldingRegister(0).Read()
+				So(err, ShouldBeNil)
+				So(v, ShouldEqual, 1)
+				v, err = bus.Client(2).HoldingRegister(0).Read()
+				So(err, ShouldBeNil)
+				So(v, ShouldEqual, 2)
+			}
+			So(sent, ShouldEqual, 2)
+		})
+	})
+}
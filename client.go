@@ -7,70 +7,92 @@
 package modbus
 
 import (
-	"encoding/binary"
 	"fmt"
-	"math"
 )
 
 type mbClient struct {
 	transport Transporter
 }
 
-type io struct {
+type object struct {
 	master  Client
 	address uint16
 	count   uint16
 }
 
-type roBit io
-type rwBit io
+type roBit object
+type rwBit object
 
-type roRegister io
-type roRegisters io
-type rwRegister io
-type rwRegisters io
+type roRegister object
+type roRegisters object
+type rwRegister object
+type rwRegisters object
 
-func (c *mbClient) request(f uint8, addr uint16, data []byte) (pdu *Pdu, err error) {
-	pdu, err = c.transport.Send(&Pdu{f, append(wordsToByteArray(addr), data...)})
+// NewClient creates a client on top of the given transporter
+// wrapped with the given middlewares.
+func NewClient(t Transporter, mw ...Middleware) IoClient {
+	return &mbClient{Chain(t, mw...)}
+}
+
+func (c *mbClient) send(req *Pdu) (res *Pdu, err error) {
+	res, err = c.transport.Send(req)
 	if err != nil {
 		return
 	}
-	if errFn := f + 0x80; errFn == pdu.Function {
-		return nil, Error{errFn, pdu.Data[0]}
+	if res == nil {
+		return nil, ResponseError{req.Function, "missing response"}
+	}
+	if errFn := req.Function + 0x80; errFn == res.Function {
+		if len(res.Data) != 1 {
+			return nil, ResponseError{req.Function, fmt.Sprintf("invalid exception length (%d bytes)", len(res.Data))}
+		}
+		return nil, Error{errFn, res.Data[0]}
+	}
+	if res.Function != req.Function {
+		return nil, ResponseError{req.Function, fmt.Sprintf("function code %d instead of %d", res.Function, req.Function)}
 	}
 	return
 }
 
-func (c *mbClient) readRegisters(fn uint8, addr, count uint16) (values []uint16, err error) {
-	res, err := c.request(fn, addr, wordsToByteArray(count))
+// call sends the request and decodes the response into res.
+func (c *mbClient) call(req, res Message) error {
+	pdu, err := NewPdu(req)
 	if err != nil {
-		return
+		return err
 	}
-	values = bytesToWordArray(res.Data[1:]...)
-	return
+	pdu, err = c.send(pdu)
+	if err != nil {
+		return err
+	}
+	return pdu.Decode(res)
 }
 
-func (c *mbClient) ReadDiscreteInputs(addr, count uint16) (result []bool, err error) {
-	resp, err := c.request(2, addr, wordsToByteArray(count))
-	if err != nil {
-		return
+// checkByteCount verifies that the response contains the expected
+// number of bytes.
+func checkByteCount(fn uint8, count, expected int) error {
+	if count != expected {
+		return ResponseError{fn, fmt.Sprintf("byte count %d instead of %d", count, expected)}
 	}
-	result = make([]bool, count)
+	return nil
+}
 
-	inputs := resp.Data[1:]
-	byteNr := uint(0)
-	bitNr := uint(0)
+// checkEcho verifies that the response echoes the request.
+func checkEcho(fn uint8, res, req interface{}) error {
+	if res != req {
+		return ResponseError{fn, fmt.Sprintf("echo %+v instead of %+v", res, req)}
+	}
+	return nil
+}
 
-	for i := 0; i < int(count); i++ {
-		result[i] = inputs[byteNr]&(1<<bitNr) != 0
-		if (i+1)%8 == 0 {
-			bitNr = 0
-			byteNr++
-		} else {
-			bitNr++
-		}
+func (c *mbClient) ReadDiscreteInputs(addr, count uint16) (result []bool, err error) {
+	res := &ReadDiscreteInputsResponse{}
+	if err = c.call(&ReadDiscreteInputsRequest{addr, count}, res); err != nil {
+		return
 	}
-	return
+	if err = checkByteCount(2, len(res.Values)/8, (int(count)+7)/8); err != nil {
+		return
+	}
+	return res.Values[:count], nil
 }
 
 func (c *mbClient) Transporter() Transporter {
@@ -78,165 +100,162 @@ func (c *mbClient) Transporter() Transporter {
 }
 
 func (c *mbClient) ReadCoils(addr, count uint16) (coils []bool, err error) {
-	res, err := c.request(1, addr, wordsToByteArray(count))
-	if err != nil {
+	res := &ReadCoilsResponse{}
+	if err = c.call(&ReadCoilsRequest{addr, count}, res); err != nil {
 		return
 	}
-	byteCount := int(res.Data[0])
-	coilStates := res.Data[1:]
-	r := make([]bool, byteCount*8)
-	for i := 0; i < byteCount; i++ {
-		for j := 0; j < 8; j++ {
-			r[i*8+j] = bool((int(coilStates[i]) & (1 << uint(j))) > 0)
-		}
+	if err = checkByteCount(1, len(res.Values)/8, (int(count)+7)/8); err != nil {
+		return
 	}
-	return r[0:count], nil
+	return res.Values[:count], nil
 }
 
 func (c *mbClient) WriteSingleCoil(addr uint16, value bool) (err error) {
-	var set uint8
-	if value {
-		set = 0xff
+	res := &WriteSingleCoilResponse{}
+	if err = c.call(&WriteSingleCoilRequest{addr, value}, res); err != nil {
+		return
 	}
-	_, err = c.request(5, addr, []byte{set, uint8(0)})
-	return
+	return checkEcho(5, *res, WriteSingleCoilResponse{addr, value})
 }
 
 func (c *mbClient) WriteMultipleCoils(addr uint16, values []bool) (err error) {
-	count := len(values)
-	byteCount := uint(math.Ceil(float64(count) / 8))
-	data := make([]byte, 3+byteCount)
-
-	binary.BigEndian.PutUint16(data, uint16(count))
-	data[2] = uint8(byteCount)
-
-	byteNr := uint(0)
-	bitNr := uint8(0)
-	byteVal := uint8(0)
-
-	for v := 0; v < count; v++ {
-		if v == count-1 {
-			data[byteNr+3] = byteVal
-			break
-		}
-		if values[v] {
-			byteVal |= 1 << bitNr
-		}
-		if bitNr > 6 {
-			data[byteNr+3] = byteVal
-			byteVal = 0
-			bitNr = 0
-			byteNr++
-		} else {
-			bitNr++
-		}
-	}
-	res, err := c.request(15, addr, data)
-	if err != nil {
+	res := &WriteMultipleCoilsResponse{}
+	if err = c.call(&WriteMultipleCoilsRequest{addr, values}, res); err != nil {
 		return
 	}
-	if c := binary.BigEndian.Uint16(res.Data[2:]); c != uint16(count) {
-		return fmt.Errorf("%d coils were forced instead of %d", c, count)
-	}
-	return
+	return checkEcho(15, *res, WriteMultipleCoilsResponse{addr, uint16(len(values))})
 }
 
-func (c *mbClient) ReadInputRegisters(addr, count uint16) ([]uint16, error) {
-	return c.readRegisters(4, addr, count)
+func (c *mbClient) ReadInputRegisters(addr, count uint16) (values []uint16, err error) {
+	res := &ReadInputRegistersResponse{}
+	if err = c.call(&ReadInputRegistersRequest{addr, count}, res); err != nil {
+		return
+	}
+	if err = checkByteCount(4, len(res.Values)*2, int(count)*2); err != nil {
+		return
+	}
+	return res.Values, nil
 }
 
-func (c *mbClient) ReadHoldingRegisters(addr, count uint16) ([]uint16, error) {
-	return c.readRegisters(3, addr, count)
+func (c *mbClient) ReadHoldingRegisters(addr, count uint16) (values []uint16, err error) {
+	res := &ReadHoldingRegistersResponse{}
+	if err = c.call(&ReadHoldingRegistersRequest{addr, count}, res); err != nil {
+		return
+	}
+	if err = checkByteCount(3, len(res.Values)*2, int(count)*2); err != nil {
+		return
+	}
+	return res.Values, nil
 }
 
 func (c *mbClient) WriteMultipleRegisters(addr uint16, values []uint16) (err error) {
-
-	regCount := len(values)
-	byteCount := regCount * 2
-	data := make([]byte, byteCount+3)
-	data[0] = uint8(regCount >> 8)
-	data[1] = uint8(regCount & 0xff)
-	data[2] = uint8(byteCount)
-
-	for i := 0; i < regCount; i++ {
-		data[i*2+3] = uint8(values[i] >> 8)
-		data[i*2+4] = uint8(values[i] & 0xff)
+	res := &WriteMultipleRegistersResponse{}
+	if err = c.call(&WriteMultipleRegistersRequest{addr, values}, res); err != nil {
+		return
 	}
-	_, err = c.request(16, addr, data)
-	return
+	return checkEcho(16, *res, WriteMultipleRegistersResponse{addr, uint16(len(values))})
 }
 
 func (c *mbClient) WriteSingleRegister(addr uint16, value uint16) (err error) {
-	_, err = c.request(6, addr, []byte{uint8(value >> 8), uint8(value & 0xff)})
-	return
+	res := &WriteSingleRegisterResponse{}
+	if err = c.call(&WriteSingleRegisterRequest{addr, value}, res); err != nil {
+		return
+	}
+	return checkEcho(6, *res, WriteSingleRegisterResponse{addr, value})
 }
 
 func (c *mbClient) ReadWriteMultipleRegisters(readAddress, readQuantity, writeAddress uint16, vals []uint16) (values []uint16, err error) {
-	writeQuantity := len(vals)
-	data := wordsToByteArray(readQuantity, writeAddress, uint16(writeQuantity))
-	data = append(data, uint8(writeQuantity*2))
-	data = append(data, wordsToByteArray(vals...)...)
-	resp, err := c.request(23, readAddress, data)
-	if err != nil {
+	res := &ReadWriteMultipleRegistersResponse{}
+	if err = c.call(&ReadWriteMultipleRegistersRequest{readAddress, readQuantity, writeAddress, vals}, res); err != nil {
 		return
 	}
-	byteCount := resp.Data[0]
-	if byteCount > 0 {
-		values = bytesToWordArray(resp.Data[1:]...)
-	} else {
-		values = []uint16{}
+	if err = checkByteCount(23, len(res.Values)*2, int(readQuantity)*2); err != nil {
+		return
 	}
-	return
+	return res.Values, nil
 }
 
 func (c *mbClient) MaskWriteRegister(addr, and, or uint16) (err error) {
-	_, err = c.request(22, addr, wordsToByteArray(and, or))
-	return
+	res := &MaskWriteRegisterResponse{}
+	if err = c.call(&MaskWriteRegisterRequest{addr, and, or}, res); err != nil {
+		return
+	}
+	return checkEcho(22, *res, MaskWriteRegisterResponse{addr, and, or})
 }
 
 func (c *mbClient) ReadFifoQueue(addr uint16) (fifoValues []uint16, err error) {
-	resp, err := c.request(24, addr, nil)
-	fifoValues = bytesToWordArray(resp.Data[4:]...)
-	return
+	res := &ReadFifoQueueResponse{}
+	if err = c.call(&ReadFifoQueueRequest{addr}, res); err != nil {
+		return
+	}
+	return res.Values, nil
 }
 
 func (c *mbClient) ReadExceptionStatus() (states []bool, err error) {
-	pdu, err := c.transport.Send(&Pdu{7, nil})
-	if err != nil {
+	res := &ReadExceptionStatusResponse{}
+	if err = c.call(&ReadExceptionStatusRequest{}, res); err != nil {
 		return
 	}
-
-	states = make([]bool, 8)
-
-	for bit := 0; bit < 8; bit++ {
-		states[bit] = bool((pdu.Data[0] & (1 << uint(bit))) > 0)
-	}
-	return
+	return unpackBits([]byte{res.Status}, 8), nil
 }
 
 func (c *mbClient) Diagnostics(subfunction uint16, data []uint16) (result []uint16, err error) {
-	resp, err := c.request(8, subfunction, wordsToByteArray(data...))
-	if err != nil {
+	res := &DiagnosticsResponse{}
+	if err = c.call(&DiagnosticsRequest{subfunction, data}, res); err != nil {
 		return
 	}
-	return bytesToWordArray(resp.Data[2:]...), nil
+	if res.SubFunction != subfunction {
+		return nil, ResponseError{8, fmt.Sprintf("sub-function %d instead of %d", res.SubFunction, subfunction)}
+	}
+	return res.Data, nil
 }
 
 func (c *mbClient) GetCommEventCounter() (status bool, count uint16, err error) {
-	pdu, err := c.transport.Send(&Pdu{11, nil})
-	if err != nil {
+	res := &GetCommEventCounterResponse{}
+	if err = c.call(&GetCommEventCounterRequest{}, res); err != nil {
+		return
+	}
+	return res.Status > 0, res.EventCount, nil
+}
+
+func (c *mbClient) GetCommEventLog() (status bool, eventCount, messageCount uint16, events []byte, err error) {
+	res := &GetCommEventLogResponse{}
+	if err = c.call(&GetCommEventLogRequest{}, res); err != nil {
 		return
 	}
-	res := bytesToWordArray(pdu.Data...)
-	return (res[0] > 0), res[1], err
+	return res.Status > 0, res.EventCount, res.MessageCount, res.Events, nil
 }
 
+// ReportServerId returns the response data including the leading byte count.
 func (c *mbClient) ReportServerId() (response []byte, err error) {
-	pdu, err := c.transport.Send(&Pdu{17, nil})
-	if err != nil {
+	res := &ReportServerIdResponse{}
+	if err = c.call(&ReportServerIdRequest{}, res); err != nil {
 		return
 	}
-	return pdu.Data, nil
+	return append([]byte{uint8(len(res.Data))}, res.Data...), nil
+}
+
+func (c *mbClient) ReadDeviceIdentification(readCode, objectId uint8) (objects map[uint8]string, err error) {
+	objects = map[uint8]string{}
+	for {
+		res := &ReadDeviceIdentificationResponse{}
+		if err := c.call(&ReadDeviceIdentificationRequest{readCode, objectId}, res); err != nil {
+			return nil, err
+		}
+		if res.ReadCode != readCode {
+			return nil, ResponseError{43, fmt.Sprintf("read code %d instead of %d", res.ReadCode, readCode)}
+		}
+		for id, v := range res.Objects {
+			objects[id] = v
+		}
+		if !res.MoreFollows || readCode == DeviceIdIndividual {
+			return objects, nil
+		}
+		if res.NextObjectId <= objectId {
+			return nil, ResponseError{43, fmt.Sprintf("next object id %d does not follow %d", res.NextObjectId, objectId)}
+		}
+		objectId = res.NextObjectId
+	}
 }
 
 func (c *mbClient) DiscreteInput(addr uint16) DiscreteInput {
@@ -299,17 +318,19 @@ func (io *rwBit) Toggle() (err error) {
 }
 
 func (io *roRegister) Read() (value uint16, err error) {
-	if res, err := io.master.ReadInputRegisters(io.address, 1); err == nil {
-		value = res[0]
+	res, err := io.master.ReadInputRegisters(io.address, 1)
+	if err != nil {
+		return
 	}
-	return
+	return res[0], nil
 }
 
 func (io *rwRegister) Read() (value uint16, err error) {
-	if res, err := io.master.ReadHoldingRegisters(io.address, 1); err == nil {
-		value = res[0]
+	res, err := io.master.ReadHoldingRegisters(io.address, 1)
+	if err != nil {
+		return
 	}
-	return
+	return res[0], nil
 }
 
 func (io *rwRegister) Write(value uint16) (err error) {
@@ -31,7 +31,9 @@ func (t *dummyTransporter) Send(pdu *Pdu) (resp *Pdu, err error) {
 
 	if t.send != nil {
 		resp, err = t.send(pdu)
-		t.resData = resp.Data
+		if resp != nil {
+			t.resData = resp.Data
+		}
 		return
 	}
 
@@ -186,6 +188,19 @@ func Test_Client(t *testing.T) {
 		})
 	})
 
+	Convey("Given a client writing multiple coils", t, func() {
+		c, d := getClient([]byte{0x00, 0x13, 0x00, 0x0a}, nil)
+
+		Convey("all bits including the last one should be sent", func() {
+			err := c.WriteMultipleCoils(19, []bool{true, false, true, true, false, false, true, true, true, false})
+			So(err, ShouldBeNil)
+			So(d.req.Function, ShouldEqual, 15)
+			So(d.req.Data, ShouldResemble, []byte{0x00, 0x13, 0x00, 0x0a, 0x02, 0xcd, 0x01})
+			c.WriteMultipleCoils(19, []bool{false, true})
+			So(d.req.Data[5], ShouldEqual, 0x02)
+		})
+	})
+
 	Convey("Given a serial client", t, func() {
 
 		// FUNCTION NR 7 (serial line only)
@@ -258,6 +273,27 @@ func Test_Client(t *testing.T) {
 			})
 
 		})
+
+		// FUNCTION NR 12 (serial line only)
+		Convey("when receiving the comm event log", func() {
+
+			c, d := getSerialClient([]byte{0x08, 0x00, 0x00, 0x01, 0x08, 0x01, 0x21, 0x20, 0x00}, nil)
+
+			state, events, messages, log, err := c.GetCommEventLog()
+
+			Convey("the function nr should be 12", func() {
+				So(err, ShouldBeNil)
+				So(d.req.Function, ShouldEqual, 12)
+			})
+
+			Convey("the counters and events should be decoded", func() {
+				So(state, ShouldEqual, false)
+				So(events, ShouldEqual, 264)
+				So(messages, ShouldEqual, 289)
+				So(log, ShouldResemble, []byte{0x20, 0x00})
+			})
+
+		})
 	})
 
 	Convey("Given an io client", t, func() {
@@ -309,7 +345,7 @@ func Test_Client(t *testing.T) {
 		})
 
 		Convey("when creating a discrete input", func() {
-			io, d := getIoClient([]byte{0x02, 0xdf}, nil)
+			io, d := getIoClient([]byte{0x01, 0xdf}, nil)
 			di := io.DiscreteInput(3)
 
 			Convey("the test method should use function nr 2", func() {
@@ -326,7 +362,12 @@ func Test_Client(t *testing.T) {
 		})
 
 		Convey("when creating a holding register", func() {
-			io, d := getIoClient([]byte{0x02, 0xda, 0x45}, nil)
+			io, d := getIoClient(nil, func(pdu *Pdu) (*Pdu, error) {
+				if pdu.Function == 6 {
+					return pdu, nil
+				}
+				return &Pdu{pdu.Function, []byte{0x02, 0xda, 0x45}}, nil
+			})
 			reg := io.HoldingRegister(0)
 
 			Convey("the read method should use function nr 3", func() {
@@ -367,7 +408,7 @@ func Test_Client(t *testing.T) {
 		})
 
 		Convey("when creating a multi input registers", func() {
-			io, d := getIoClient([]byte{0x02, 0x66, 0x6f, 0x6f}, nil)
+			io, d := getIoClient([]byte{0x04, 0x66, 0x6f, 0x00, 0x6f}, nil)
 			reg := io.InputRegisters(0x1000, 2)
 
 			Convey("the read method should use function nr 4", func() {
@@ -385,7 +426,7 @@ func Test_Client(t *testing.T) {
 		})
 
 		Convey("when creating a multi holting registers", func() {
-			io, d := getIoClient([]byte{0x02, 0x00, 0x05, 0x00, 0x03}, nil)
+			io, d := getIoClient([]byte{0x10, 0x00, 0x00, 0x02}, nil)
 			reg := io.HoldingRegisters(0x1000, 2)
 
 			Convey("the write method should use function nr x", func() {
@@ -400,4 +441,98 @@ func Test_Client(t *testing.T) {
 			})
 		})
 	})
+
+	Convey("Given a client receiving malformed responses", t, func() {
+
+		Convey("an empty response should not panic", func() {
+			c, _ := getSerialClient([]byte{}, nil)
+			_, err := c.ReadCoils(0, 8)
+			So(err, ShouldHaveSameTypeAs, ResponseError{})
+			_, err = c.ReadHoldingRegisters(0, 1)
+			So(err, ShouldHaveSameTypeAs, ResponseError{})
+			_, err = c.ReadFifoQueue(0)
+			So(err, ShouldHaveSameTypeAs, ResponseError{})
+			_, err = c.ReadExceptionStatus()
+			So(err, ShouldHaveSameTypeAs, ResponseError{})
+			_, _, err = c.GetCommEventCounter()
+			So(err, ShouldHaveSameTypeAs, ResponseError{})
+			_, err = c.ReportServerId()
+			So(err, ShouldHaveSameTypeAs, ResponseError{})
+			_, err = c.Diagnostics(0, nil)
+			So(err, ShouldHaveSameTypeAs, ResponseError{})
+		})
+
+		Convey("the byte count of read responses should be checked", func() {
+			c, _ := getClient([]byte{0x01, 0xff}, nil)
+			_, err := c.ReadCoils(0, 9)
+			So(err, ShouldNotBeNil)
+			c, _ = getClient([]byte{0x04, 0x00, 0x01}, nil)
+			_, err = c.ReadHoldingRegisters(0, 2)
+			So(err, ShouldNotBeNil)
+			c, _ = getClient([]byte{0x02, 0x00, 0x01, 0x00}, nil)
+			_, err = c.ReadInputRegisters(0, 1)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("the echo of write responses should be checked", func() {
+			c, _ := getClient([]byte{0x00, 0x01, 0x00, 0x04}, nil)
+			So(c.WriteSingleRegister(1, 3), ShouldNotBeNil)
+			So(c.WriteSingleCoil(1, true), ShouldNotBeNil)
+			So(c.WriteMultipleRegisters(1, []uint16{1, 2}), ShouldNotBeNil)
+			So(c.WriteMultipleCoils(1, []bool{true}), ShouldNotBeNil)
+			So(c.MaskWriteRegister(1, 2, 3), ShouldNotBeNil)
+		})
+
+		Convey("the function code should be checked", func() {
+			c, _ := getClient(nil, func(pdu *Pdu) (*Pdu, error) {
+				return &Pdu{4, []byte{0x02, 0x00, 0x01}}, nil
+			})
+			_, err := c.ReadHoldingRegisters(0, 1)
+			So(err, ShouldHaveSameTypeAs, ResponseError{})
+		})
+
+		Convey("exception responses should be decoded", func() {
+			c, _ := getClient(nil, func(pdu *Pdu) (*Pdu, error) {
+				return &Pdu{0x83, []byte{0x02}}, nil
+			})
+			_, err := c.ReadHoldingRegisters(0, 1)
+			So(err, ShouldResemble, Error{0x83, 0x02})
+		})
+
+		Convey("the FIFO count should be checked", func() {
+			c, _ := getClient([]byte{0x00, 0x06, 0x00, 0x02, 0x01, 0xb8}, nil)
+			_, err := c.ReadFifoQueue(0x04de)
+			So(err, ShouldNotBeNil)
+			c, _ = getClient([]byte{0x00, 0x06, 0x00, 0x02, 0x01, 0xb8, 0x12, 0x84}, nil)
+			values, err := c.ReadFifoQueue(0x04de)
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []uint16{0x01b8, 0x1284})
+		})
+	})
+
+	Convey("Given a serial client reading the device identification", t, func() {
+		c, d := getSerialClient(nil, func(pdu *Pdu) (*Pdu, error) {
+			if pdu.Data[2] == 0 {
+				return &Pdu{43, []byte{0x0e, 0x01, 0x01, 0xff, 0x02, 0x02,
+					0x00, 0x03, 'f', 'o', 'o',
+					0x01, 0x02, 'b', 'a'}}, nil
+			}
+			return &Pdu{43, []byte{0x0e, 0x01, 0x01, 0x00, 0x00, 0x01,
+				0x02, 0x03, '1', '.', '0'}}, nil
+		})
+
+		Convey("all objects should be read", func() {
+			objects, err := c.ReadDeviceIdentification(DeviceIdBasic, 0)
+			So(err, ShouldBeNil)
+			So(d.req.Function, ShouldEqual, 43)
+			So(d.req.Data, ShouldResemble, []byte{0x0e, 0x01, 0x02})
+			So(objects, ShouldResemble, map[uint8]string{0: "foo", 1: "ba", 2: "1.0"})
+		})
+
+		Convey("truncated objects should be rejected", func() {
+			c, _ := getSerialClient([]byte{0x0e, 0x01, 0x01, 0x00, 0x00, 0x01, 0x00, 0x05, 'f'}, nil)
+			_, err := c.ReadDeviceIdentification(DeviceIdBasic, 0)
+			So(err, ShouldHaveSameTypeAs, ResponseError{})
+		})
+	})
 }
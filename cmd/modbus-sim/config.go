@@ -0,0 +1,143 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Configuration of the slave simulator
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type config struct {
+	Address string       `yaml:"address"`
+	Pty     *ptyConfig   `yaml:"pty"`
+	Units   []unitConfig `yaml:"units"`
+}
+
+// ptyConfig serves the units additionally as RTU slaves on a pseudo
+// terminal (Linux only).
+type ptyConfig struct {
+
+	// default 9600
+	BaudRate int `yaml:"baud_rate"`
+
+	// symbolic link to the terminal device, e.g. /tmp/ttySIM
+	Link string `yaml:"link"`
+}
+
+type unitConfig struct {
+	Id               uint8          `yaml:"id"`
+	Coils            *int           `yaml:"coils"`
+	DiscreteInputs   *int           `yaml:"discrete_inputs"`
+	InputRegisters   *int           `yaml:"input_registers"`
+	HoldingRegisters *int           `yaml:"holding_registers"`
+	Initial          initialValues  `yaml:"initial"`
+	Signals          []signalConfig `yaml:"signals"`
+}
+
+type initialValues struct {
+	Coils            map[uint16][]bool   `yaml:"coils"`
+	DiscreteInputs   map[uint16][]bool   `yaml:"discrete_inputs"`
+	InputRegisters   map[uint16][]uint16 `yaml:"input_registers"`
+	HoldingRegisters map[uint16][]uint16 `yaml:"holding_registers"`
+}
+
+type signalConfig struct {
+
+	// coils, discrete_inputs, input_registers or holding_registers
+	Table string `yaml:"table"`
+
+	Address uint16 `yaml:"address"`
+
+	// ramp, sine, random or counter
+	Type string `yaml:"type"`
+
+	// uint16 (default), int16 or float32 (two registers, high word first)
+	Format string `yaml:"format"`
+
+	Min      float64       `yaml:"min"`
+	Max      float64       `yaml:"max"`
+	Step     float64       `yaml:"step"`
+	Noise    float64       `yaml:"noise"`
+	Period   time.Duration `yaml:"period"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+const defaultTableSize = 65536
+
+func size(n *int) int {
+	if n == nil {
+		return defaultTableSize
+	}
+	return *n
+}
+
+// parseConfig reads a YAML or JSON configuration.
+func parseConfig(data []byte) (*config, error) {
+	c := &config{Address: ":502"}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	if len(c.Units) == 0 {
+		return nil, fmt.Errorf("no units configured")
+	}
+	if c.Pty != nil {
+		if c.Pty.BaudRate == 0 {
+			c.Pty.BaudRate = 9600
+		}
+		if c.Pty.BaudRate < 0 {
+			return nil, fmt.Errorf("invalid pty baud rate %d", c.Pty.BaudRate)
+		}
+	}
+	for _, u := range c.Units {
+		for i := range u.Signals {
+			if err := u.Signals[i].validate(); err != nil {
+				return nil, fmt.Errorf("unit %d: %s", u.Id, err)
+			}
+		}
+	}
+	return c, nil
+}
+
+func loadConfig(path string) (*config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(data)
+}
+
+func (s *signalConfig) validate() error {
+	switch s.Table {
+	case "coils", "discrete_inputs", "input_registers", "holding_registers":
+	default:
+		return fmt.Errorf("unknown table '%s'", s.Table)
+	}
+	switch s.Type {
+	case "ramp", "sine":
+		if s.Period <= 0 {
+			return fmt.Errorf("%s signal at %d needs a period", s.Type, s.Address)
+		}
+	case "random", "counter":
+	default:
+		return fmt.Errorf("unknown signal type '%s'", s.Type)
+	}
+	if s.Max <= s.Min {
+		return fmt.Errorf("%s signal at %d needs a max greater than min", s.Type, s.Address)
+	}
+	switch s.Format {
+	case "", "uint16", "int16", "float32":
+	default:
+		return fmt.Errorf("unknown format '%s'", s.Format)
+	}
+	if s.Interval <= 0 {
+		s.Interval = time.Second
+	}
+	return nil
+}
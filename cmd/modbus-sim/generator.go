@@ -0,0 +1,109 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Signal generators of the slave simulator
+ */
+
+package main
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	modbus "github.com/flosse/go-modbus"
+)
+
+type generator struct {
+	cfg     signalConfig
+	model   *modbus.DataModel
+	start   time.Time
+	counter float64
+	rand    *rand.Rand
+}
+
+func newGenerator(cfg signalConfig, model *modbus.DataModel, start time.Time) *generator {
+	return &generator{
+		cfg:     cfg,
+		model:   model,
+		start:   start,
+		counter: cfg.Min,
+		rand:    rand.New(rand.NewSource(start.UnixNano())),
+	}
+}
+
+// value calculates the signal value at the given time.
+func (s *generator) value(t time.Time) float64 {
+	c := s.cfg
+	var v float64
+	switch c.Type {
+	case "ramp":
+		frac := math.Mod(float64(t.Sub(s.start)), float64(c.Period)) / float64(c.Period)
+		v = c.Min + (c.Max-c.Min)*frac
+	case "sine":
+		mid, amp := (c.Max+c.Min)/2, (c.Max-c.Min)/2
+		v = mid + amp*math.Sin(2*math.Pi*float64(t.Sub(s.start))/float64(c.Period))
+	case "random":
+		v = c.Min + (c.Max-c.Min)*s.rand.Float64()
+	case "counter":
+		v = s.counter
+		step := c.Step
+		if step == 0 {
+			step = 1
+		}
+		if s.counter += step; s.counter > c.Max {
+			s.counter = c.Min
+		}
+	}
+	if c.Noise > 0 {
+		v += (s.rand.Float64()*2 - 1) * c.Noise
+	}
+	return v
+}
+
+func toWords(format string, v float64) []uint16 {
+	switch format {
+	case "int16":
+		return []uint16{uint16(int16(clamp(math.Round(v), math.MinInt16, math.MaxInt16)))}
+	case "float32":
+		bits := math.Float32bits(float32(v))
+		return []uint16{uint16(bits >> 16), uint16(bits)}
+	}
+	return []uint16{uint16(clamp(math.Round(v), 0, math.MaxUint16))}
+}
+
+func clamp(v, min, max float64) float64 {
+	return math.Max(min, math.Min(max, v))
+}
+
+// update writes the signal value at the given time into the data model.
+func (s *generator) update(t time.Time) error {
+	c := s.cfg
+	v := s.value(t)
+	switch c.Table {
+	case "coils":
+		return s.model.WriteCoils(c.Address, []bool{v >= (c.Min+c.Max)/2})
+	case "discrete_inputs":
+		return s.model.WriteDiscreteInputs(c.Address, []bool{v >= (c.Min+c.Max)/2})
+	case "input_registers":
+		return s.model.WriteInputRegisters(c.Address, toWords(c.Format, v))
+	case "holding_registers":
+		return s.model.WriteHoldingRegisters(c.Address, toWords(c.Format, v))
+	}
+	return nil
+}
+
+func (s *generator) run(stop <-chan struct{}, errs chan<- error) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case t := <-ticker.C:
+			if err := s.update(t); err != nil {
+				errs <- err
+			}
+		case <-stop:
+			return
+		}
+	}
+}
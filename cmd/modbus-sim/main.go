@@ -0,0 +1,106 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Modbus slave simulator
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	modbus "github.com/flosse/go-modbus"
+)
+
+// newModel creates the data model of a unit with its initial values.
+func newModel(u unitConfig) (*modbus.DataModel, error) {
+	m := modbus.NewDataModel(size(u.Coils), size(u.DiscreteInputs), size(u.InputRegisters), size(u.HoldingRegisters))
+	for addr, v := range u.Initial.Coils {
+		if err := m.WriteCoils(addr, v); err != nil {
+			return nil, fmt.Errorf("unit %d: coils at %d: %s", u.Id, addr, err)
+		}
+	}
+	for addr, v := range u.Initial.DiscreteInputs {
+		if err := m.WriteDiscreteInputs(addr, v); err != nil {
+			return nil, fmt.Errorf("unit %d: discrete inputs at %d: %s", u.Id, addr, err)
+		}
+	}
+	for addr, v := range u.Initial.InputRegisters {
+		if err := m.WriteInputRegisters(addr, v); err != nil {
+			return nil, fmt.Errorf("unit %d: input registers at %d: %s", u.Id, addr, err)
+		}
+	}
+	for addr, v := range u.Initial.HoldingRegisters {
+		if err := m.WriteHoldingRegisters(addr, v); err != nil {
+			return nil, fmt.Errorf("unit %d: holding registers at %d: %s", u.Id, addr, err)
+		}
+	}
+	return m, nil
+}
+
+func main() {
+	path := flag.String("config", "sim.yaml", "YAML or JSON configuration file")
+	address := flag.String("address", "", "listen address (overrides the configuration)")
+	flag.Parse()
+
+	cfg, err := loadConfig(*path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *address != "" {
+		cfg.Address = *address
+	}
+
+	server := modbus.NewTcpServer(cfg.Address)
+	stop := make(chan struct{})
+	errs := make(chan error)
+	start := time.Now()
+	handlers := map[uint8]modbus.Handler{}
+
+	for _, u := range cfg.Units {
+		m, err := newModel(u)
+		if err != nil {
+			log.Fatal(err)
+		}
+		server.SetUnitHandler(u.Id, m)
+		handlers[u.Id] = m
+		for _, s := range u.Signals {
+			go newGenerator(s, m, start).run(stop, errs)
+		}
+	}
+
+	if err := server.Start(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("simulating %d unit(s) on %s", len(cfg.Units), server.Addr())
+
+	stopPty := func() {}
+	if cfg.Pty != nil {
+		device, stop, err := startPty(cfg.Pty, handlers)
+		if err != nil {
+			log.Fatal(err)
+		}
+		stopPty = stop
+		log.Printf("simulating %d unit(s) as RTU slaves on %s", len(cfg.Units), device)
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	for {
+		select {
+		case err := <-errs:
+			log.Print(err)
+		case <-interrupt:
+			close(stop)
+			server.Stop()
+			stopPty()
+			return
+		}
+	}
+}
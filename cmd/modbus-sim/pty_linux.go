@@ -0,0 +1,47 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * RTU slaves of the simulator on a pseudo terminal
+ */
+
+package main
+
+import (
+	"os"
+
+	modbus "github.com/flosse/go-modbus"
+)
+
+// startPty serves the units as RTU slaves on a pseudo terminal. It returns
+// the device that clients open and a function that stops the server.
+func startPty(c *ptyConfig, handlers map[uint8]modbus.Handler) (string, func(), error) {
+	master, slave, err := modbus.OpenPty(&modbus.SerialConfig{BaudRate: c.BaudRate})
+	if err != nil {
+		return "", nil, err
+	}
+	device := slave.Name()
+	if c.Link != "" {
+		os.Remove(c.Link)
+		if err := os.Symlink(device, c.Link); err != nil {
+			master.Close()
+			slave.Close()
+			return "", nil, err
+		}
+		device = c.Link
+	}
+	s := modbus.NewRtuServer(master, c.BaudRate)
+	for id, h := range handlers {
+		s.SetUnitHandler(id, h)
+	}
+	s.Start()
+
+	// the slave side is kept open, otherwise reading the master fails
+	// while no client is connected
+	return device, func() {
+		s.Stop()
+		slave.Close()
+		if c.Link != "" {
+			os.Remove(c.Link)
+		}
+	}, nil
+}
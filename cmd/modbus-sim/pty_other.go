@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Pseudo terminals are only available on Linux
+ */
+
+package main
+
+import (
+	"errors"
+
+	modbus "github.com/flosse/go-modbus"
+)
+
+func startPty(c *ptyConfig, handlers map[uint8]modbus.Handler) (string, func(), error) {
+	return "", nil, errors.New("pseudo terminals are only supported on Linux")
+}
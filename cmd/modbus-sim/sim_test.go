@@ -0,0 +1,117 @@
+package main
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	modbus "github.com/flosse/go-modbus"
+)
+
+func Test_Sim(t *testing.T) {
+
+	Convey("Given the example configuration", t, func() {
+		data, err := ioutil.ReadFile("sim.example.yaml")
+		So(err, ShouldBeNil)
+		cfg, err := parseConfig(data)
+		So(err, ShouldBeNil)
+
+		Convey("the units should be parsed", func() {
+			So(cfg.Address, ShouldEqual, ":5020")
+			So(len(cfg.Units), ShouldEqual, 2)
+			So(cfg.Units[0].Signals[0].Period, ShouldEqual, time.Minute)
+			So(cfg.Units[0].Signals[2].Interval, ShouldEqual, time.Second)
+		})
+
+		Convey("the initial values should be loaded", func() {
+			m, err := newModel(cfg.Units[0])
+			So(err, ShouldBeNil)
+			v, _ := m.ReadHoldingRegisters(0, 2)
+			So(v, ShouldResemble, []uint16{230, 50})
+			c, _ := m.ReadCoils(0, 3)
+			So(c, ShouldResemble, []bool{true, false, true})
+		})
+
+		Convey("missing tables should be empty", func() {
+			m, _ := newModel(cfg.Units[1])
+			_, err := m.ReadCoils(0, 1)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a JSON configuration", t, func() {
+		cfg, err := parseConfig([]byte(`{"units": [{"id": 3, "signals": [{"table": "coils", "type": "random", "max": 1}]}]}`))
+
+		Convey("it should be parsed", func() {
+			So(err, ShouldBeNil)
+			So(cfg.Address, ShouldEqual, ":502")
+			So(cfg.Units[0].Id, ShouldEqual, 3)
+		})
+	})
+
+	Convey("Given an invalid configuration", t, func() {
+		_, err := parseConfig([]byte(`{"units": [{"id": 3, "signals": [{"table": "coils", "type": "sine"}]}]}`))
+		So(err, ShouldNotBeNil)
+		_, err = parseConfig([]byte(`{"units": [{"id": 3, "signals": [{"table": "foo", "type": "random"}]}]}`))
+		So(err, ShouldNotBeNil)
+		_, err = parseConfig([]byte(`{"units": [{"id": 3, "signals": [{"table": "coils", "type": "random"}]}]}`))
+		So(err, ShouldNotBeNil)
+		_, err = parseConfig([]byte(`{"units": [{"id": 3, "signals": [{"table": "coils", "type": "counter", "min": 5, "max": 2}]}]}`))
+		So(err, ShouldNotBeNil)
+		_, err = parseConfig([]byte(`{"pty": {"baud_rate": -1}, "units": [{"id": 3}]}`))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Given a configuration with a pty", t, func() {
+		cfg, err := parseConfig([]byte(`{"pty": {"link": "/tmp/ttySIM"}, "units": [{"id": 3}]}`))
+
+		Convey("the baud rate should default to 9600", func() {
+			So(err, ShouldBeNil)
+			So(cfg.Pty.BaudRate, ShouldEqual, 9600)
+			So(cfg.Pty.Link, ShouldEqual, "/tmp/ttySIM")
+		})
+	})
+
+	Convey("Given some generators", t, func() {
+		start := time.Unix(0, 0)
+		m := modbus.NewDataModel(1, 0, 4, 0)
+
+		Convey("a ramp should rise within its period", func() {
+			g := newGenerator(signalConfig{Type: "ramp", Min: 0, Max: 100, Period: 10 * time.Second}, m, start)
+			So(g.value(start.Add(5*time.Second)), ShouldAlmostEqual, 50)
+			So(g.value(start.Add(12*time.Second)), ShouldAlmostEqual, 20)
+		})
+
+		Convey("a sine should oscillate around the middle", func() {
+			g := newGenerator(signalConfig{Type: "sine", Min: 0, Max: 100, Period: 4 * time.Second}, m, start)
+			So(g.value(start), ShouldAlmostEqual, 50)
+			So(g.value(start.Add(time.Second)), ShouldAlmostEqual, 100)
+		})
+
+		Convey("a counter should wrap", func() {
+			g := newGenerator(signalConfig{Type: "counter", Min: 1, Max: 3, Step: 1}, m, start)
+			values := []float64{g.value(start), g.value(start), g.value(start), g.value(start)}
+			So(values, ShouldResemble, []float64{1, 2, 3, 1})
+		})
+
+		Convey("random values should stay within the bounds", func() {
+			g := newGenerator(signalConfig{Type: "random", Min: 10, Max: 20}, m, start)
+			for i := 0; i < 100; i++ {
+				v := g.value(start)
+				So(v >= 10 && v <= 20, ShouldBeTrue)
+			}
+		})
+
+		Convey("values should be written into the data model", func() {
+			g := newGenerator(signalConfig{Table: "input_registers", Address: 1, Type: "ramp", Format: "float32", Min: 0, Max: 10, Period: 10 * time.Second}, m, start)
+			So(g.update(start.Add(5*time.Second)), ShouldBeNil)
+			v, _ := m.ReadInputRegisters(1, 2)
+			So(v, ShouldResemble, []uint16{0x40a0, 0x0000})
+			g = newGenerator(signalConfig{Table: "coils", Type: "ramp", Min: 0, Max: 10, Period: 10 * time.Second}, m, start)
+			g.update(start.Add(6 * time.Second))
+			c, _ := m.ReadCoils(0, 1)
+			So(c[0], ShouldBeTrue)
+		})
+	})
+}
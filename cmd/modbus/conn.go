@@ -0,0 +1,71 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Connections of the command line tool
+ */
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	modbus "github.com/flosse/go-modbus"
+)
+
+type client interface {
+	modbus.IoClient
+	modbus.SerialClient
+}
+
+func (o *options) transporter() (modbus.Transporter, error) {
+	unit := uint8(o.unit)
+	switch o.transport {
+	case "tcp", "udp":
+		host, p, err := net.SplitHostPort(o.address)
+		if err != nil {
+			return nil, err
+		}
+		port, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port '%s'", p)
+		}
+		if o.transport == "udp" {
+			return modbus.NewUdpTransporter(host, uint(port), unit, o.timeout), nil
+		}
+		return modbus.NewTcpTransporter(host, uint(port), unit, o.timeout), nil
+	case "rtu", "ascii":
+		if o.device == "" {
+			return nil, fmt.Errorf("no serial device given")
+		}
+		if len(o.parity) != 1 {
+			return nil, fmt.Errorf("invalid parity '%s'", o.parity)
+		}
+		conf := &modbus.SerialConfig{
+			Address:  o.device,
+			BaudRate: o.baudRate,
+			DataBits: o.dataBits,
+			Parity:   modbus.Parity(strings.ToUpper(o.parity)[0]),
+			StopBits: o.stopBits,
+			Timeout:  o.timeout,
+		}
+		if o.transport == "ascii" {
+			if conf.DataBits == 0 {
+				conf.DataBits = 7
+			}
+			return modbus.OpenAsciiTransporter(conf, unit)
+		}
+		return modbus.OpenRtuTransporter(conf, unit)
+	}
+	return nil, fmt.Errorf("unknown transport '%s'", o.transport)
+}
+
+func (o *options) connect() (client, error) {
+	t, err := o.transporter()
+	if err != nil {
+		return nil, err
+	}
+	return modbus.NewClient(t).(client), nil
+}
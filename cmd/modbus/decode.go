@@ -0,0 +1,97 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Decoder for raw frames
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	modbus "github.com/flosse/go-modbus"
+)
+
+var framings = map[string]modbus.Framing{
+	"tcp":   modbus.FramingTCP,
+	"rtu":   modbus.FramingRTU,
+	"ascii": modbus.FramingASCII,
+}
+
+// parseFrame accepts hex dumps like "01 03 00 00", "0x01,0x03" or
+// "01:03:00:00" and ASCII frames starting with ':'.
+func parseFrame(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, ":") {
+		return []byte(s), nil
+	}
+	s = strings.ToLower(s)
+	s = strings.Replace(s, "0x", "", -1)
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', ',', ':', '-':
+			return -1
+		}
+		return r
+	}, s)
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex dump: %s", err)
+	}
+	return b, nil
+}
+
+func decodeInto(t *table, n int, data []byte, framing modbus.Framing) error {
+	f, err := modbus.Decode(data, framing)
+	if err != nil {
+		return err
+	}
+	t.add(n, "framing", f.Framing)
+	if f.Framing == modbus.FramingTCP {
+		t.add(n, "transaction", f.Transaction)
+	}
+	t.add(n, "unit", f.Unit)
+	t.add(n, "direction", f.Direction)
+	t.add(n, "function", fmt.Sprintf("%d (%s)", f.Pdu.Function, modbus.FunctionName(f.Pdu.Function)))
+	for _, field := range f.Fields {
+		t.add(n, field.Name, field.Value)
+	}
+	return nil
+}
+
+func runDecode(o *options, args []string) (*table, error) {
+	framing := modbus.FramingAuto
+	if len(args) > 0 {
+		if f, ok := framings[strings.ToLower(args[0])]; ok {
+			framing = f
+			args = args[1:]
+		}
+	}
+	t := &table{header: []string{"frame", "field", "value"}}
+	if len(args) > 0 {
+		data, err := parseFrame(strings.Join(args, " "))
+		if err != nil {
+			return nil, err
+		}
+		return t, decodeInto(t, 1, data, framing)
+	}
+	s := bufio.NewScanner(os.Stdin)
+	for n := 1; s.Scan(); {
+		if strings.TrimSpace(s.Text()) == "" {
+			continue
+		}
+		data, err := parseFrame(s.Text())
+		if err == nil {
+			err = decodeInto(t, n, data, framing)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("frame %d: %s", n, err)
+		}
+		n++
+	}
+	return t, s.Err()
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+
+	modbus "github.com/flosse/go-modbus"
+)
+
+func Test_Decode(t *testing.T) {
+
+	Convey("Given hex dumps in different notations", t, func() {
+		for _, s := range []string{"01 03 00 00", "0x01,0x03,0x00,0x00", "01:03:00:00", "01030000"} {
+			b, err := parseFrame(s)
+			So(err, ShouldBeNil)
+			So(b, ShouldResemble, []byte{1, 3, 0, 0})
+		}
+		_, err := parseFrame("01 0g")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Given an ASCII frame", t, func() {
+		b, _ := parseFrame(" :0183027A ")
+		So(string(b), ShouldEqual, ":0183027A")
+	})
+
+	Convey("Given a decoded frame", t, func() {
+		b, _ := parseFrame("00 01 00 00 00 06 01 06 00 01 00 03")
+		tbl := &table{}
+		So(decodeInto(tbl, 1, b, modbus.FramingAuto), ShouldBeNil)
+
+		Convey("the header and the fields should be listed", func() {
+			So(len(tbl.rows), ShouldEqual, 7)
+			So(tbl.rows[0][2], ShouldEqual, modbus.FramingTCP)
+			So(tbl.rows[4][2], ShouldEqual, "6 (Write Single Register)")
+			So(tbl.rows[6][1:], ShouldResemble, []interface{}{"value", "3"})
+		})
+	})
+}
@@ -0,0 +1,435 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Command line tool for ad-hoc Modbus requests
+ */
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	modbus "github.com/flosse/go-modbus"
+)
+
+type options struct {
+	transport string
+	address   string
+	device    string
+	baudRate  int
+	dataBits  int
+	parity    string
+	stopBits  int
+	unit      uint
+	timeout   time.Duration
+	dataType  dataType
+	order     wordOrder
+	format    string
+}
+
+type command struct {
+	name  string
+	usage string
+	run   func(o *options, args []string) (*table, error)
+}
+
+var commands = []*command{
+	{"read", "read coils|discrete|input|holding ADDRESS [COUNT]", runRead},
+	{"write", "write coil ADDRESS on|off | coils ADDRESS STATE... | register ADDRESS VALUE | registers ADDRESS VALUE...", runWrite},
+	{"mask", "mask ADDRESS AND OR", runMask},
+	{"rw", "rw READ_ADDRESS READ_COUNT WRITE_ADDRESS VALUE...", runReadWrite},
+	{"fifo", "fifo ADDRESS", runFifo},
+	{"diag", "diag SUBFUNCTION [DATA...]", runDiag},
+	{"devid", "devid [basic|regular|extended|OBJECT_ID]", runDevId},
+	{"scan", "scan [FIRST_UNIT [LAST_UNIT]]", runScan},
+	{"decode", "decode [tcp|rtu|ascii] [FRAME...] (reads frames from stdin without arguments)", runDecode},
+}
+
+var errUsage = errors.New("invalid arguments")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS] COMMAND [ARGS]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %s\n", c.usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nOptions:")
+	flag.PrintDefaults()
+}
+
+func main() {
+	o := &options{}
+	var typ, order string
+	flag.StringVar(&o.transport, "transport", "tcp", "transport: tcp, udp, rtu or ascii")
+	flag.StringVar(&o.address, "address", "127.0.0.1:502", "address of the TCP/UDP device")
+	flag.StringVar(&o.device, "device", "", "serial device for rtu and ascii")
+	flag.IntVar(&o.baudRate, "baud", 9600, "baud rate of the serial device")
+	flag.IntVar(&o.dataBits, "databits", 0, "data bits of the serial device (default 8 for rtu, 7 for ascii)")
+	flag.StringVar(&o.parity, "parity", "E", "parity of the serial device: E, O or N")
+	flag.IntVar(&o.stopBits, "stopbits", 0, "stop bits of the serial device (default 1, or 2 without parity)")
+	flag.UintVar(&o.unit, "unit", 1, "unit (slave) id")
+	flag.DurationVar(&o.timeout, "timeout", time.Second, "response timeout")
+	flag.StringVar(&typ, "type", "uint16", "register data type: uint16, int16, hex, uint32, int32, float32 or string")
+	flag.StringVar(&order, "order", "big", "word order of 32 bit values: big (ABCD) or little (CDAB)")
+	flag.StringVar(&o.format, "format", "table", "output format: table, csv or json")
+	flag.Usage = usage
+	flag.Parse()
+
+	if err := run(o, typ, order, flag.Args()); err != nil {
+		if err == errUsage {
+			usage()
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(o *options, typ, order string, args []string) (err error) {
+	if o.dataType, err = parseDataType(typ); err != nil {
+		return
+	}
+	if o.order, err = parseWordOrder(order); err != nil {
+		return
+	}
+	if o.format, err = parseFormat(o.format); err != nil {
+		return
+	}
+	if o.unit > 255 {
+		return fmt.Errorf("invalid unit id %d", o.unit)
+	}
+	if len(args) < 1 {
+		return errUsage
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			t, err := c.run(o, args[1:])
+			if err != nil || t == nil {
+				return err
+			}
+			return t.write(os.Stdout, o.format)
+		}
+	}
+	return errUsage
+}
+
+func parseAddresses(args []string) ([]uint16, error) {
+	values := make([]uint16, len(args))
+	for i, a := range args {
+		v, err := parseUint16(a)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s'", a)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+func bitTable(addr uint16, bits []bool) *table {
+	t := &table{header: []string{"address", "value"}}
+	for i, b := range bits {
+		t.add(int(addr)+i, b)
+	}
+	return t
+}
+
+func (o *options) registerTable(addr uint16, words []uint16) (*table, error) {
+	values, err := o.dataType.decode(words, o.order)
+	if err != nil {
+		return nil, err
+	}
+	t := &table{header: []string{"address", "value"}}
+	step := o.dataType.words
+	for i, v := range values {
+		t.add(int(addr)+i*step, v)
+	}
+	return t, nil
+}
+
+func runRead(o *options, args []string) (*table, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, errUsage
+	}
+	n, err := parseAddresses(args[1:])
+	if err != nil {
+		return nil, err
+	}
+	addr, count := n[0], uint16(1)
+	if len(n) > 1 {
+		count = n[1]
+	}
+	c, err := o.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Transporter().Close()
+
+	switch kind := strings.ToLower(args[0]); kind {
+	case "coils":
+		bits, err := c.ReadCoils(addr, count)
+		if err != nil {
+			return nil, err
+		}
+		return bitTable(addr, bits), nil
+	case "discrete":
+		bits, err := c.ReadDiscreteInputs(addr, count)
+		if err != nil {
+			return nil, err
+		}
+		return bitTable(addr, bits), nil
+	case "input", "holding":
+		read := c.ReadHoldingRegisters
+		if kind == "input" {
+			read = c.ReadInputRegisters
+		}
+		words, err := read(addr, uint16(o.dataType.registerCount(int(count))))
+		if err != nil {
+			return nil, err
+		}
+		return o.registerTable(addr, words)
+	}
+	return nil, errUsage
+}
+
+func runWrite(o *options, args []string) (*table, error) {
+	if len(args) < 3 {
+		return nil, errUsage
+	}
+	addr, err := parseUint16(args[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid address '%s'", args[1])
+	}
+	c, err := o.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Transporter().Close()
+
+	switch strings.ToLower(args[0]) {
+	case "coil":
+		if len(args) != 3 {
+			return nil, errUsage
+		}
+		b, err := parseBool(args[2])
+		if err != nil {
+			return nil, err
+		}
+		return nil, c.WriteSingleCoil(addr, b)
+	case "coils":
+		bits := make([]bool, len(args)-2)
+		for i, a := range args[2:] {
+			if bits[i], err = parseBool(a); err != nil {
+				return nil, err
+			}
+		}
+		return nil, c.WriteMultipleCoils(addr, bits)
+	case "register":
+		words, err := o.dataType.encode(args[2:], o.order)
+		if err != nil {
+			return nil, err
+		}
+		if len(words) != 1 {
+			return nil, fmt.Errorf("%d registers can not be written with a single register write", len(words))
+		}
+		return nil, c.WriteSingleRegister(addr, words[0])
+	case "registers":
+		words, err := o.dataType.encode(args[2:], o.order)
+		if err != nil {
+			return nil, err
+		}
+		return nil, c.WriteMultipleRegisters(addr, words)
+	}
+	return nil, errUsage
+}
+
+func runMask(o *options, args []string) (*table, error) {
+	if len(args) != 3 {
+		return nil, errUsage
+	}
+	n, err := parseAddresses(args)
+	if err != nil {
+		return nil, err
+	}
+	c, err := o.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Transporter().Close()
+	return nil, c.MaskWriteRegister(n[0], n[1], n[2])
+}
+
+func runReadWrite(o *options, args []string) (*table, error) {
+	if len(args) < 4 {
+		return nil, errUsage
+	}
+	n, err := parseAddresses(args[:3])
+	if err != nil {
+		return nil, err
+	}
+	words, err := o.dataType.encode(args[3:], o.order)
+	if err != nil {
+		return nil, err
+	}
+	c, err := o.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Transporter().Close()
+	res, err := c.ReadWriteMultipleRegisters(n[0], uint16(o.dataType.registerCount(int(n[1]))), n[2], words)
+	if err != nil {
+		return nil, err
+	}
+	return o.registerTable(n[0], res)
+}
+
+func runFifo(o *options, args []string) (*table, error) {
+	if len(args) != 1 {
+		return nil, errUsage
+	}
+	addr, err := parseUint16(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid address '%s'", args[0])
+	}
+	c, err := o.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Transporter().Close()
+	values, err := c.ReadFifoQueue(addr)
+	if err != nil {
+		return nil, err
+	}
+	t := &table{header: []string{"index", "value"}}
+	for i, v := range values {
+		t.add(i, v)
+	}
+	return t, nil
+}
+
+func runDiag(o *options, args []string) (*table, error) {
+	if len(args) < 1 {
+		return nil, errUsage
+	}
+	n, err := parseAddresses(args)
+	if err != nil {
+		return nil, err
+	}
+	c, err := o.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Transporter().Close()
+	res, err := c.Diagnostics(n[0], n[1:])
+	if err != nil {
+		return nil, err
+	}
+	t := &table{header: []string{"index", "value"}}
+	for i, v := range res {
+		t.add(i, fmt.Sprintf("0x%04x", v))
+	}
+	return t, nil
+}
+
+var deviceIdObjects = []string{
+	"VendorName", "ProductCode", "MajorMinorRevision",
+	"VendorUrl", "ProductName", "ModelName", "UserApplicationName",
+}
+
+func runDevId(o *options, args []string) (*table, error) {
+	if len(args) > 1 {
+		return nil, errUsage
+	}
+	code, id := modbus.DeviceIdBasic, uint8(0)
+	if len(args) == 1 {
+		switch strings.ToLower(args[0]) {
+		case "basic":
+		case "regular":
+			code = modbus.DeviceIdRegular
+		case "extended":
+			code = modbus.DeviceIdExtended
+		default:
+			v, err := parseUint16(args[0])
+			if err != nil || v > 255 {
+				return nil, fmt.Errorf("invalid object id '%s'", args[0])
+			}
+			code, id = modbus.DeviceIdIndividual, uint8(v)
+		}
+	}
+	c, err := o.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Transporter().Close()
+	objects, err := c.ReadDeviceIdentification(code, id)
+	if err != nil {
+		return nil, err
+	}
+	t := &table{header: []string{"id", "name", "value"}}
+	for i := 0; i < 256; i++ {
+		v, ok := objects[uint8(i)]
+		if !ok {
+			continue
+		}
+		name := ""
+		if i < len(deviceIdObjects) {
+			name = deviceIdObjects[i]
+		}
+		t.add(i, name, v)
+	}
+	return t, nil
+}
+
+func runScan(o *options, args []string) (*table, error) {
+	if len(args) > 2 {
+		return nil, errUsage
+	}
+	n, err := parseAddresses(args)
+	if err != nil {
+		return nil, err
+	}
+	first, last := uint16(1), uint16(247)
+	if len(n) > 0 {
+		first, last = n[0], n[0]
+	}
+	if len(n) > 1 {
+		last = n[1]
+	}
+	if first > last || last > 255 {
+		return nil, fmt.Errorf("invalid unit range %d-%d", first, last)
+	}
+	t, err := o.transporter()
+	if err != nil {
+		return nil, err
+	}
+	defer t.Close()
+	s := modbus.NewScanner(t)
+	for u := first; u <= last; u++ {
+		s.Units = append(s.Units, uint8(u))
+	}
+	report, err := s.Scan()
+	if err != nil {
+		return nil, err
+	}
+	res := &table{header: []string{"unit", "table", "addresses"}}
+	for _, u := range report.Units {
+		if len(u.Tables) == 0 {
+			res.add(u.Unit, "", "")
+		}
+		for _, tbl := range []modbus.Table{modbus.TableCoils, modbus.TableDiscreteInputs, modbus.TableInputRegisters, modbus.TableHoldingRegisters} {
+			ranges, ok := u.Tables[tbl]
+			if !ok {
+				continue
+			}
+			r := make([]string, len(ranges))
+			for i, a := range ranges {
+				r[i] = a.String()
+			}
+			res.add(u.Unit, tbl.String(), strings.Join(r, " "))
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,81 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Output formats of the command line tool
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+type table struct {
+	header []string
+	rows   [][]interface{}
+}
+
+func (t *table) add(values ...interface{}) {
+	t.rows = append(t.rows, values)
+}
+
+func parseFormat(s string) (string, error) {
+	switch s {
+	case "table", "csv", "json":
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown output format '%s'", s)
+}
+
+func (t *table) write(w io.Writer, format string) error {
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		for i, h := range t.header {
+			if i > 0 {
+				fmt.Fprint(tw, "\t")
+			}
+			fmt.Fprint(tw, h)
+		}
+		fmt.Fprintln(tw)
+		for _, r := range t.rows {
+			for i, v := range r {
+				if i > 0 {
+					fmt.Fprint(tw, "\t")
+				}
+				fmt.Fprint(tw, v)
+			}
+			fmt.Fprintln(tw)
+		}
+		return tw.Flush()
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(t.header)
+		for _, r := range t.rows {
+			rec := make([]string, len(r))
+			for i, v := range r {
+				rec[i] = fmt.Sprint(v)
+			}
+			cw.Write(rec)
+		}
+		cw.Flush()
+		return cw.Error()
+	case "json":
+		out := make([]map[string]interface{}, 0, len(t.rows))
+		for _, r := range t.rows {
+			m := map[string]interface{}{}
+			for i, v := range r {
+				m[t.header[i]] = v
+			}
+			out = append(out, m)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+	return fmt.Errorf("unknown output format '%s'", format)
+}
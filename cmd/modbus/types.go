@@ -0,0 +1,180 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Value types of the command line tool
+ */
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// dataType describes how register values are decoded and encoded.
+type dataType struct {
+	name string
+
+	// number of registers per value (0 for strings)
+	words int
+}
+
+var dataTypes = map[string]dataType{
+	"uint16":  {"uint16", 1},
+	"int16":   {"int16", 1},
+	"hex":     {"hex", 1},
+	"uint32":  {"uint32", 2},
+	"int32":   {"int32", 2},
+	"float32": {"float32", 2},
+	"string":  {"string", 0},
+}
+
+func parseDataType(s string) (dataType, error) {
+	t, ok := dataTypes[strings.ToLower(s)]
+	if !ok {
+		return t, fmt.Errorf("unknown data type '%s'", s)
+	}
+	return t, nil
+}
+
+// wordOrder defines the order of the registers of 32 bit values.
+type wordOrder bool
+
+const (
+	bigEndian    wordOrder = false // high word first (ABCD)
+	littleEndian wordOrder = true  // low word first (CDAB)
+)
+
+func parseWordOrder(s string) (wordOrder, error) {
+	switch strings.ToLower(s) {
+	case "big", "abcd":
+		return bigEndian, nil
+	case "little", "cdab":
+		return littleEndian, nil
+	}
+	return bigEndian, fmt.Errorf("unknown word order '%s'", s)
+}
+
+func (o wordOrder) join(w []uint16) uint32 {
+	if o == littleEndian {
+		return uint32(w[1])<<16 | uint32(w[0])
+	}
+	return uint32(w[0])<<16 | uint32(w[1])
+}
+
+func (o wordOrder) split(v uint32) []uint16 {
+	hi, lo := uint16(v>>16), uint16(v)
+	if o == littleEndian {
+		return []uint16{lo, hi}
+	}
+	return []uint16{hi, lo}
+}
+
+// registerCount returns the number of registers needed for count values.
+func (t dataType) registerCount(count int) int {
+	if t.words == 0 {
+		return count
+	}
+	return count * t.words
+}
+
+// decode converts register values into typed values.
+func (t dataType) decode(words []uint16, order wordOrder) ([]interface{}, error) {
+	if t.words == 0 {
+		b := make([]byte, 0, len(words)*2)
+		for _, w := range words {
+			b = append(b, byte(w>>8), byte(w))
+		}
+		return []interface{}{strings.TrimRight(string(b), "\x00")}, nil
+	}
+	if len(words)%t.words != 0 {
+		return nil, fmt.Errorf("%d registers can not be decoded as %s", len(words), t.name)
+	}
+	values := make([]interface{}, 0, len(words)/t.words)
+	for i := 0; i < len(words); i += t.words {
+		w := words[i : i+t.words]
+		switch t.name {
+		case "uint16":
+			values = append(values, w[0])
+		case "int16":
+			values = append(values, int16(w[0]))
+		case "hex":
+			values = append(values, fmt.Sprintf("0x%04x", w[0]))
+		case "uint32":
+			values = append(values, order.join(w))
+		case "int32":
+			values = append(values, int32(order.join(w)))
+		case "float32":
+			values = append(values, math.Float32frombits(order.join(w)))
+		}
+	}
+	return values, nil
+}
+
+// encode converts textual values into register values.
+func (t dataType) encode(args []string, order wordOrder) ([]uint16, error) {
+	if t.words == 0 {
+		s := strings.Join(args, " ")
+		if len(s)%2 != 0 {
+			s += "\x00"
+		}
+		words := make([]uint16, 0, len(s)/2)
+		for i := 0; i < len(s); i += 2 {
+			words = append(words, uint16(s[i])<<8|uint16(s[i+1]))
+		}
+		return words, nil
+	}
+	var words []uint16
+	for _, a := range args {
+		switch t.name {
+		case "uint16", "hex":
+			v, err := strconv.ParseUint(a, 0, 16)
+			if err != nil {
+				return nil, err
+			}
+			words = append(words, uint16(v))
+		case "int16":
+			v, err := strconv.ParseInt(a, 0, 16)
+			if err != nil {
+				return nil, err
+			}
+			words = append(words, uint16(v))
+		case "uint32":
+			v, err := strconv.ParseUint(a, 0, 32)
+			if err != nil {
+				return nil, err
+			}
+			words = append(words, order.split(uint32(v))...)
+		case "int32":
+			v, err := strconv.ParseInt(a, 0, 32)
+			if err != nil {
+				return nil, err
+			}
+			words = append(words, order.split(uint32(v))...)
+		case "float32":
+			v, err := strconv.ParseFloat(a, 32)
+			if err != nil {
+				return nil, err
+			}
+			words = append(words, order.split(math.Float32bits(float32(v)))...)
+		}
+	}
+	return words, nil
+}
+
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "1", "on", "true":
+		return true, nil
+	case "0", "off", "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid coil state '%s'", s)
+}
+
+func parseUint16(s string) (uint16, error) {
+	v, err := strconv.ParseUint(s, 0, 16)
+	return uint16(v), err
+}
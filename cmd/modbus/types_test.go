@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func Test_Types(t *testing.T) {
+
+	Convey("Given register values", t, func() {
+		words := []uint16{0x4049, 0x0fdb, 0xffff, 0xfffe}
+
+		Convey("they should be decoded as float32", func() {
+			typ, _ := parseDataType("float32")
+			v, err := typ.decode(words[:2], bigEndian)
+			So(err, ShouldBeNil)
+			So(v[0], ShouldAlmostEqual, float32(3.1415927))
+		})
+
+		Convey("they should be decoded as int32 with word order", func() {
+			typ, _ := parseDataType("int32")
+			v, _ := typ.decode(words[2:], bigEndian)
+			So(v[0], ShouldEqual, int32(-2))
+			v, _ = typ.decode(words[2:], littleEndian)
+			So(v[0], ShouldEqual, int32(-65537))
+		})
+
+		Convey("an odd number of registers can not be decoded as 32 bit values", func() {
+			typ, _ := parseDataType("uint32")
+			_, err := typ.decode(words[:3], bigEndian)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("they should be decoded as string", func() {
+			typ, _ := parseDataType("string")
+			v, _ := typ.decode([]uint16{0x666f, 0x6f00}, bigEndian)
+			So(v[0], ShouldEqual, "foo")
+		})
+	})
+
+	Convey("Given textual values", t, func() {
+
+		Convey("they should be encoded as float32 with word order", func() {
+			typ, _ := parseDataType("float32")
+			w, err := typ.encode([]string{"3.1415927"}, littleEndian)
+			So(err, ShouldBeNil)
+			So(w, ShouldResemble, []uint16{0x0fdb, 0x4049})
+		})
+
+		Convey("they should be encoded as int16", func() {
+			typ, _ := parseDataType("int16")
+			w, _ := typ.encode([]string{"-1", "0x10"}, bigEndian)
+			So(w, ShouldResemble, []uint16{0xffff, 0x10})
+		})
+
+		Convey("strings should be padded", func() {
+			typ, _ := parseDataType("string")
+			w, _ := typ.encode([]string{"foo"}, bigEndian)
+			So(w, ShouldResemble, []uint16{0x666f, 0x6f00})
+		})
+
+		Convey("invalid values should be rejected", func() {
+			typ, _ := parseDataType("uint16")
+			_, err := typ.encode([]string{"70000"}, bigEndian)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a table", t, func() {
+		tbl := &table{header: []string{"address", "value"}}
+		tbl.add(1, true)
+		tbl.add(2, false)
+		var buf bytes.Buffer
+
+		Convey("it should be written as CSV", func() {
+			tbl.write(&buf, "csv")
+			So(buf.String(), ShouldEqual, "address,value\n1,true\n2,false\n")
+		})
+
+		Convey("it should be written as JSON", func() {
+			tbl.write(&buf, "json")
+			So(buf.String(), ShouldContainSubstring, `"address": 2`)
+		})
+
+		Convey("unknown formats should be rejected", func() {
+			So(tbl.write(&buf, "xml"), ShouldNotBeNil)
+		})
+
+		Convey("unknown formats should be rejected before the command runs", func() {
+			o := &options{transport: "serial", format: "xml"}
+			err := run(o, "uint16", "big", []string{"write", "register", "0", "1"})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "unknown output format 'xml'")
+		})
+	})
+}
@@ -0,0 +1,363 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * In-memory data model of a Modbus server (slave)
+ */
+
+package modbus
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Table identifies one of the four data tables by its read function code.
+type Table uint8
+
+const (
+	TableCoils            Table = 1
+	TableDiscreteInputs   Table = 2
+	TableHoldingRegisters Table = 3
+	TableInputRegisters   Table = 4
+)
+
+var allTables = []Table{TableCoils, TableDiscreteInputs, TableHoldingRegisters, TableInputRegisters}
+
+func (t Table) String() string {
+	switch t {
+	case TableCoils:
+		return "coils"
+	case TableDiscreteInputs:
+		return "discrete inputs"
+	case TableHoldingRegisters:
+		return "holding registers"
+	case TableInputRegisters:
+		return "input registers"
+	}
+	return fmt.Sprintf("table %d", uint8(t))
+}
+
+// AddressRange is an inclusive range of addresses.
+type AddressRange struct {
+	Start, End uint16
+}
+
+func (r AddressRange) String() string {
+	if r.Start == r.End {
+		return fmt.Sprintf("%d", r.Start)
+	}
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+type DataModel struct {
+	mu               sync.RWMutex
+	coils            []bool
+	discreteInputs   []bool
+	inputRegisters   []uint16
+	holdingRegisters []uint16
+	store            Store
+	watchers         []*watcher
+}
+
+// NewDataModel creates a data model with the given number of entries
+// per table. Requests beyond the tables are answered with
+// ILLEGAL DATA ADDRESS exceptions.
+func NewDataModel(coils, discreteInputs, inputRegisters, holdingRegisters int) *DataModel {
+	return &DataModel{
+		coils:            make([]bool, coils),
+		discreteInputs:   make([]bool, discreteInputs),
+		inputRegisters:   make([]uint16, inputRegisters),
+		holdingRegisters: make([]uint16, holdingRegisters),
+	}
+}
+
+func inRange(size int, addr uint16, count int) bool {
+	return count > 0 && int(addr)+count <= size
+}
+
+func readBits(mu *sync.RWMutex, table []bool, addr, count uint16) ([]bool, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if !inRange(len(table), addr, int(count)) {
+		return nil, ErrIllegalDataAddress
+	}
+	return append([]bool{}, table[addr:int(addr)+int(count)]...), nil
+}
+
+func writeBits(mu *sync.RWMutex, table []bool, addr uint16, values []bool) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if !inRange(len(table), addr, len(values)) {
+		return ErrIllegalDataAddress
+	}
+	copy(table[addr:], values)
+	return nil
+}
+
+func readWords(mu *sync.RWMutex, table []uint16, addr, count uint16) ([]uint16, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if !inRange(len(table), addr, int(count)) {
+		return nil, ErrIllegalDataAddress
+	}
+	return append([]uint16{}, table[addr:int(addr)+int(count)]...), nil
+}
+
+func writeWords(mu *sync.RWMutex, table []uint16, addr uint16, values []uint16) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if !inRange(len(table), addr, len(values)) {
+		return ErrIllegalDataAddress
+	}
+	copy(table[addr:], values)
+	return nil
+}
+
+func (m *DataModel) ReadCoils(addr, count uint16) ([]bool, error) {
+	return readBits(&m.mu, m.coils, addr, count)
+}
+
+func (m *DataModel) WriteCoils(addr uint16, values []bool) error {
+	return m.write(Change{Table: TableCoils, Address: addr, Bits: values}, nil)
+}
+
+func (m *DataModel) ReadDiscreteInputs(addr, count uint16) ([]bool, error) {
+	return readBits(&m.mu, m.discreteInputs, addr, count)
+}
+
+func (m *DataModel) WriteDiscreteInputs(addr uint16, values []bool) error {
+	return writeBits(&m.mu, m.discreteInputs, addr, values)
+}
+
+func (m *DataModel) ReadInputRegisters(addr, count uint16) ([]uint16, error) {
+	return readWords(&m.mu, m.inputRegisters, addr, count)
+}
+
+func (m *DataModel) WriteInputRegisters(addr uint16, values []uint16) error {
+	return writeWords(&m.mu, m.inputRegisters, addr, values)
+}
+
+func (m *DataModel) ReadHoldingRegisters(addr, count uint16) ([]uint16, error) {
+	return readWords(&m.mu, m.holdingRegisters, addr, count)
+}
+
+func (m *DataModel) WriteHoldingRegisters(addr uint16, values []uint16) error {
+	return m.write(Change{Table: TableHoldingRegisters, Address: addr, Registers: values}, nil)
+}
+
+func exceptionResponse(f uint8, err error) *Pdu {
+	switch e := err.(type) {
+	case Error:
+		return exceptionPdu(f, e.Exception)
+	case RequestError:
+		return exceptionPdu(f, ExceptionIllegalDataValue)
+	}
+	return exceptionPdu(f, ExceptionServerDeviceFailure)
+}
+
+// respond encodes the result of a request as response PDU.
+func respond(f uint8, res Message, err error) *Pdu {
+	if err != nil {
+		return exceptionResponse(f, err)
+	}
+	pdu, err := NewPdu(res)
+	if err != nil {
+		return exceptionResponse(f, err)
+	}
+	return pdu
+}
+
+// write applies a change of the coils or holding registers after it was
+// accepted by the watchers and persisted. r is nil for writes of the
+// application.
+func (m *DataModel) write(c Change, r *Request) error {
+	m.mu.Lock()
+	events, err := m.writeLocked(c, r)
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	m.notify(events)
+	return nil
+}
+
+func (m *DataModel) writeLocked(c Change, r *Request) ([]watcherEvent, error) {
+	var size, count int
+	if c.Table == TableCoils {
+		size, count = len(m.coils), len(c.Bits)
+	} else {
+		size, count = len(m.holdingRegisters), len(c.Registers)
+	}
+	if !inRange(size, c.Address, count) {
+		return nil, ErrIllegalDataAddress
+	}
+	events, err := m.check(c, r)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.persist(c); err != nil {
+		return nil, err
+	}
+	if c.Table == TableCoils {
+		copy(m.coils[c.Address:], c.Bits)
+	} else {
+		copy(m.holdingRegisters[c.Address:], c.Registers)
+	}
+	return events, nil
+}
+
+func (m *DataModel) maskWriteHoldingRegister(addr, and, or uint16) error {
+	return m.maskWrite(addr, and, or, nil)
+}
+
+func (m *DataModel) maskWrite(addr, and, or uint16, r *Request) error {
+	m.mu.Lock()
+	if !inRange(len(m.holdingRegisters), addr, 1) {
+		m.mu.Unlock()
+		return ErrIllegalDataAddress
+	}
+	v := (m.holdingRegisters[addr] & and) | (or &^ and)
+	events, err := m.writeLocked(Change{Table: TableHoldingRegisters, Address: addr, Registers: []uint16{v}}, r)
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	m.notify(events)
+	return nil
+}
+
+// Handle answers the request from the data model.
+func (m *DataModel) Handle(req *Pdu) *Pdu {
+	return m.ServeModbus(&Request{Pdu: req})
+}
+
+// ServeModbus answers the request from the data model and passes its
+// metadata to the watchers of written addresses.
+func (m *DataModel) ServeModbus(r *Request) *Pdu {
+	res, err := handleTables(&modelRequest{m, r}, r.Pdu)
+	return respond(r.Pdu.Function, res, err)
+}
+
+// modelRequest writes to a data model on behalf of a request.
+type modelRequest struct {
+	*DataModel
+	r *Request
+}
+
+func (m *modelRequest) WriteCoils(addr uint16, values []bool) error {
+	return m.write(Change{Table: TableCoils, Address: addr, Bits: values}, m.r)
+}
+
+func (m *modelRequest) WriteHoldingRegisters(addr uint16, values []uint16) error {
+	return m.write(Change{Table: TableHoldingRegisters, Address: addr, Registers: values}, m.r)
+}
+
+func (m *modelRequest) maskWriteHoldingRegister(addr, and, or uint16) error {
+	return m.maskWrite(addr, and, or, m.r)
+}
+
+// tables provides access to the four data tables.
+type tables interface {
+	ReadCoils(addr, count uint16) ([]bool, error)
+	WriteCoils(addr uint16, values []bool) error
+	ReadDiscreteInputs(addr, count uint16) ([]bool, error)
+	ReadInputRegisters(addr, count uint16) ([]uint16, error)
+	ReadHoldingRegisters(addr, count uint16) ([]uint16, error)
+	WriteHoldingRegisters(addr uint16, values []uint16) error
+	maskWriteHoldingRegister(addr, and, or uint16) error
+}
+
+// handleTables answers the requests of the function codes that access
+// the data tables.
+func handleTables(t tables, pdu *Pdu) (Message, error) {
+	switch pdu.Function {
+
+	case 1:
+		req := &ReadCoilsRequest{}
+		if err := pdu.Decode(req); err != nil {
+			return nil, err
+		}
+		bits, err := t.ReadCoils(req.Address, req.Quantity)
+		return &ReadCoilsResponse{bits}, err
+
+	case 2:
+		req := &ReadDiscreteInputsRequest{}
+		if err := pdu.Decode(req); err != nil {
+			return nil, err
+		}
+		bits, err := t.ReadDiscreteInputs(req.Address, req.Quantity)
+		return &ReadDiscreteInputsResponse{bits}, err
+
+	case 3:
+		req := &ReadHoldingRegistersRequest{}
+		if err := pdu.Decode(req); err != nil {
+			return nil, err
+		}
+		values, err := t.ReadHoldingRegisters(req.Address, req.Quantity)
+		return &ReadHoldingRegistersResponse{values}, err
+
+	case 4:
+		req := &ReadInputRegistersRequest{}
+		if err := pdu.Decode(req); err != nil {
+			return nil, err
+		}
+		values, err := t.ReadInputRegisters(req.Address, req.Quantity)
+		return &ReadInputRegistersResponse{values}, err
+
+	case 5:
+		req := &WriteSingleCoilRequest{}
+		if err := pdu.Decode(req); err != nil {
+			return nil, err
+		}
+		err := t.WriteCoils(req.Address, []bool{req.Value})
+		return &WriteSingleCoilResponse{req.Address, req.Value}, err
+
+	case 6:
+		req := &WriteSingleRegisterRequest{}
+		if err := pdu.Decode(req); err != nil {
+			return nil, err
+		}
+		err := t.WriteHoldingRegisters(req.Address, []uint16{req.Value})
+		return &WriteSingleRegisterResponse{req.Address, req.Value}, err
+
+	case 15:
+		req := &WriteMultipleCoilsRequest{}
+		if err := pdu.Decode(req); err != nil {
+			return nil, err
+		}
+		err := t.WriteCoils(req.Address, req.Values)
+		return &WriteMultipleCoilsResponse{req.Address, uint16(len(req.Values))}, err
+
+	case 16:
+		req := &WriteMultipleRegistersRequest{}
+		if err := pdu.Decode(req); err != nil {
+			return nil, err
+		}
+		err := t.WriteHoldingRegisters(req.Address, req.Values)
+		return &WriteMultipleRegistersResponse{req.Address, uint16(len(req.Values))}, err
+
+	case 22:
+		req := &MaskWriteRegisterRequest{}
+		if err := pdu.Decode(req); err != nil {
+			return nil, err
+		}
+		err := t.maskWriteHoldingRegister(req.Address, req.AndMask, req.OrMask)
+		return &MaskWriteRegisterResponse{req.Address, req.AndMask, req.OrMask}, err
+
+	case 23:
+		req := &ReadWriteMultipleRegistersRequest{}
+		if err := pdu.Decode(req); err != nil {
+			return nil, err
+		}
+		if _, err := t.ReadHoldingRegisters(req.ReadAddress, req.ReadQuantity); err != nil {
+			return nil, err
+		}
+		// the write operation is performed before the read
+		if err := t.WriteHoldingRegisters(req.WriteAddress, req.Values); err != nil {
+			return nil, err
+		}
+		values, err := t.ReadHoldingRegisters(req.ReadAddress, req.ReadQuantity)
+		return &ReadWriteMultipleRegistersResponse{values}, err
+	}
+	return nil, ErrIllegalFunction
+}
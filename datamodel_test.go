@@ -0,0 +1,91 @@
+package modbus
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+// handlerTransporter passes requests directly to a handler
+type handlerTransporter struct {
+	h Handler
+}
+
+func (t *handlerTransporter) Connect() error {
+	return nil
+}
+
+func (t *handlerTransporter) Close() error {
+	return nil
+}
+
+func (t *handlerTransporter) Send(pdu *Pdu) (*Pdu, error) {
+	return t.h.Handle(pdu), nil
+}
+
+func Test_DataModel(t *testing.T) {
+
+	Convey("Given a data model", t, func() {
+		m := NewDataModel(20, 20, 10, 10)
+		c := NewClient(&handlerTransporter{m}).(SerialClient)
+
+		Convey("coils should be written and read", func() {
+			So(c.WriteMultipleCoils(3, []bool{true, false, true, true, false, false, false, false, true}), ShouldBeNil)
+			So(c.WriteSingleCoil(19, true), ShouldBeNil)
+			coils, err := c.ReadCoils(2, 18)
+			So(err, ShouldBeNil)
+			So(coils[1:4], ShouldResemble, []bool{true, false, true})
+			So(coils[17], ShouldBeTrue)
+		})
+
+		Convey("discrete inputs should be read", func() {
+			m.WriteDiscreteInputs(9, []bool{true})
+			inputs, err := c.ReadDiscreteInputs(8, 2)
+			So(err, ShouldBeNil)
+			So(inputs, ShouldResemble, []bool{false, true})
+		})
+
+		Convey("holding registers should be written and read", func() {
+			So(c.WriteMultipleRegisters(1, []uint16{7, 8, 9}), ShouldBeNil)
+			So(c.WriteSingleRegister(0, 6), ShouldBeNil)
+			values, err := c.ReadHoldingRegisters(0, 4)
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []uint16{6, 7, 8, 9})
+		})
+
+		Convey("input registers should be read", func() {
+			m.WriteInputRegisters(8, []uint16{42, 43})
+			values, err := c.ReadInputRegisters(8, 2)
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []uint16{42, 43})
+		})
+
+		Convey("a register should be masked", func() {
+			m.WriteHoldingRegisters(4, []uint16{0x12})
+			So(c.MaskWriteRegister(4, 0xf2, 0x25), ShouldBeNil)
+			values, _ := m.ReadHoldingRegisters(4, 1)
+			So(values[0], ShouldEqual, 0x17)
+		})
+
+		Convey("registers should be written before they are read", func() {
+			values, err := c.ReadWriteMultipleRegisters(2, 2, 3, []uint16{5})
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []uint16{0, 5})
+		})
+
+		Convey("requests beyond the tables should be rejected", func() {
+			_, err := c.ReadHoldingRegisters(9, 2)
+			So(err, ShouldResemble, Error{0x83, ExceptionIllegalDataAddress})
+			So(c.WriteSingleCoil(20, true), ShouldResemble, Error{0x85, ExceptionIllegalDataAddress})
+		})
+
+		Convey("invalid quantities should be rejected", func() {
+			res := m.Handle(&Pdu{3, []byte{0, 0, 0, 0}})
+			So(res, ShouldResemble, &Pdu{0x83, []byte{ExceptionIllegalDataValue}})
+		})
+
+		Convey("unsupported functions should be rejected", func() {
+			_, err := c.ReadFifoQueue(0)
+			So(err, ShouldResemble, Error{0x98, ExceptionIllegalFunction})
+		})
+	})
+}
@@ -0,0 +1,323 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Decoder for raw Modbus frames
+ */
+
+package modbus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+type Framing uint8
+
+const (
+
+	// detect the framing from the frame itself
+	FramingAuto Framing = iota
+
+	FramingTCP
+	FramingRTU
+	FramingASCII
+)
+
+func (f Framing) String() string {
+	switch f {
+	case FramingTCP:
+		return "TCP"
+	case FramingRTU:
+		return "RTU"
+	case FramingASCII:
+		return "ASCII"
+	}
+	return "auto"
+}
+
+type Direction uint8
+
+const (
+
+	// the frame is a valid request as well as a valid response
+	// (e.g. the echo of a write request)
+	DirectionUnknown Direction = iota
+
+	DirectionRequest
+	DirectionResponse
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionRequest:
+		return "request"
+	case DirectionResponse:
+		return "response"
+	}
+	return "request or response"
+}
+
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Frame is the decoded form of a raw frame.
+type Frame struct {
+	Framing     Framing
+	Transaction uint16
+	Unit        uint8
+	Pdu         *Pdu
+	Direction   Direction
+	Fields      []Field
+}
+
+func (f *Frame) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s", f.Framing)
+	if f.Framing == FramingTCP {
+		fmt.Fprintf(&b, " transaction %d", f.Transaction)
+	}
+	fmt.Fprintf(&b, " unit %d %s: %s (%d)\n", f.Unit, f.Direction, FunctionName(f.Pdu.Function), f.Pdu.Function)
+	for _, field := range f.Fields {
+		fmt.Fprintf(&b, "  %s: %s\n", field.Name, field.Value)
+	}
+	return b.String()
+}
+
+func detectFraming(data []byte) Framing {
+	if bytes.HasPrefix(data, []byte(asciiStart)) {
+		return FramingASCII
+	}
+	if l := len(data); l >= headerLength+1 && binary.BigEndian.Uint16(data[2:]) == tcpProtocolId &&
+		int(binary.BigEndian.Uint16(data[4:])) == l-headerLength+1 {
+		return FramingTCP
+	}
+	return FramingRTU
+}
+
+// Decode decodes a TCP (MBAP), RTU or ASCII frame. Frames that fit the
+// request as well as the response format are decoded as requests.
+func Decode(data []byte, framing Framing) (*Frame, error) {
+	if framing == FramingAuto {
+		framing = detectFraming(data)
+	}
+	f := &Frame{Framing: framing}
+	switch framing {
+	case FramingTCP:
+		adu, err := unpackAdu(data)
+		if err != nil {
+			return nil, FramingError{err.Error()}
+		}
+		if l := int(adu.header.length); l != len(data)-headerLength+1 {
+			return nil, FramingError{fmt.Sprintf("length field %d does not match the frame", l)}
+		}
+		f.Transaction, f.Unit, f.Pdu = adu.header.transaction, adu.header.unit, adu.pdu
+	case FramingRTU:
+		adu, err := unpackRtuAdu(data)
+		if err != nil {
+			return nil, err
+		}
+		f.Unit, f.Pdu = adu.slave, adu.pdu
+	case FramingASCII:
+		if !bytes.HasSuffix(data, []byte(asciiEnd)) {
+			data = append(bytes.TrimRight(data, "\r\n"), asciiEnd...)
+		}
+		adu, err := unpackAsciiAdu(data)
+		if err != nil {
+			return nil, err
+		}
+		f.Unit, f.Pdu = adu.slave, adu.pdu
+	default:
+		return nil, fmt.Errorf("Unknown framing %d", framing)
+	}
+	f.Direction, f.Fields = DecodePdu(f.Pdu)
+	return f, nil
+}
+
+// DecodePdu describes the fields of a request or response PDU.
+func DecodePdu(pdu *Pdu) (Direction, []Field) {
+	fn, d := pdu.Function, pdu.Data
+	if fn&0x80 != 0 {
+		if len(d) == 1 {
+			return DirectionResponse, []Field{{"exception", fmt.Sprintf("%d (%s)", d[0], ExceptionMessage(d[0]))}}
+		}
+		return DirectionResponse, []Field{{"data", hexString(d)}}
+	}
+	req := decodeRequest(fn, d)
+	res := decodeResponse(fn, d)
+	switch {
+	case req != nil && res != nil:
+		return DirectionUnknown, req
+	case req != nil:
+		return DirectionRequest, req
+	case res != nil:
+		return DirectionResponse, res
+	}
+	return DirectionUnknown, []Field{{"data", hexString(d)}}
+}
+
+func hexString(b []byte) string {
+	return fmt.Sprintf("% x", b)
+}
+
+func word(d []byte, i int) uint16 {
+	return binary.BigEndian.Uint16(d[i:])
+}
+
+func uintField(name string, v interface{}) Field {
+	return Field{name, fmt.Sprintf("%d", v)}
+}
+
+func bitsField(d []byte, count int) Field {
+	s := make([]string, count)
+	for i, b := range unpackBits(d, count) {
+		s[i] = "0"
+		if b {
+			s[i] = "1"
+		}
+	}
+	return Field{"values", strings.Join(s, " ")}
+}
+
+func wordsField(d []byte) Field {
+	s := []string{}
+	for _, w := range bytesToWordArray(d...) {
+		s = append(s, fmt.Sprintf("%d", w))
+	}
+	return Field{"values", strings.Join(s, " ")}
+}
+
+// byteCounted reports whether the byte at index i holds the length of
+// the rest of d.
+func byteCounted(d []byte, i int) bool {
+	return len(d) > i && int(d[i]) == len(d)-i-1
+}
+
+// decodeRequest returns nil if d is not a valid request of function fn.
+func decodeRequest(fn uint8, d []byte) []Field {
+	switch fn {
+	case 1, 2, 3, 4:
+		if len(d) == 4 {
+			return []Field{uintField("address", word(d, 0)), uintField("quantity", word(d, 2))}
+		}
+	case 5:
+		if len(d) == 4 {
+			v := fmt.Sprintf("0x%04x", word(d, 2))
+			switch word(d, 2) {
+			case 0xff00:
+				v = "on"
+			case 0x0000:
+				v = "off"
+			}
+			return []Field{uintField("address", word(d, 0)), {"value", v}}
+		}
+	case 6:
+		if len(d) == 4 {
+			return []Field{uintField("address", word(d, 0)), uintField("value", word(d, 2))}
+		}
+	case 7, 11, 12, 17:
+		if len(d) == 0 {
+			return []Field{}
+		}
+	case 8:
+		if len(d) >= 2 && len(d)%2 == 0 {
+			return []Field{uintField("sub-function", word(d, 0)), {"data", hexString(d[2:])}}
+		}
+	case 15:
+		if byteCounted(d, 4) && int(d[4]) == (int(word(d, 2))+7)/8 {
+			return []Field{uintField("address", word(d, 0)), uintField("quantity", word(d, 2)),
+				uintField("byte count", d[4]), bitsField(d[5:], int(word(d, 2)))}
+		}
+	case 16:
+		if byteCounted(d, 4) && int(d[4]) == int(word(d, 2))*2 {
+			return []Field{uintField("address", word(d, 0)), uintField("quantity", word(d, 2)),
+				uintField("byte count", d[4]), wordsField(d[5:])}
+		}
+	case 20, 21:
+		if byteCounted(d, 0) {
+			return []Field{uintField("byte count", d[0]), {"data", hexString(d[1:])}}
+		}
+	case 22:
+		if len(d) == 6 {
+			return []Field{uintField("address", word(d, 0)),
+				{"and mask", fmt.Sprintf("0x%04x", word(d, 2))}, {"or mask", fmt.Sprintf("0x%04x", word(d, 4))}}
+		}
+	case 23:
+		if byteCounted(d, 8) && int(d[8]) == int(word(d, 6))*2 {
+			return []Field{uintField("read address", word(d, 0)), uintField("read quantity", word(d, 2)),
+				uintField("write address", word(d, 4)), uintField("write quantity", word(d, 6)),
+				uintField("byte count", d[8]), wordsField(d[9:])}
+		}
+	case 24:
+		if len(d) == 2 {
+			return []Field{uintField("address", word(d, 0))}
+		}
+	case 43:
+		if len(d) == 3 && d[0] == meiReadDeviceId {
+			return []Field{uintField("MEI type", d[0]), uintField("read device id code", d[1]), uintField("object id", d[2])}
+		}
+	}
+	return nil
+}
+
+// decodeResponse returns nil if d is not a valid response of function fn.
+func decodeResponse(fn uint8, d []byte) []Field {
+	switch fn {
+	case 1, 2:
+		if byteCounted(d, 0) && d[0] > 0 {
+			return []Field{uintField("byte count", d[0]), bitsField(d[1:], len(d[1:])*8)}
+		}
+	case 3, 4, 23:
+		if byteCounted(d, 0) && d[0] > 0 && d[0]%2 == 0 {
+			return []Field{uintField("byte count", d[0]), wordsField(d[1:])}
+		}
+	case 5, 6, 8, 22:
+		return decodeRequest(fn, d)
+	case 7:
+		if len(d) == 1 {
+			return []Field{{"status", fmt.Sprintf("%08b", d[0])}}
+		}
+	case 11:
+		if len(d) == 4 {
+			return []Field{{"status", fmt.Sprintf("0x%04x", word(d, 0))}, uintField("event count", word(d, 2))}
+		}
+	case 12:
+		if byteCounted(d, 0) && len(d) >= 7 {
+			return []Field{uintField("byte count", d[0]), {"status", fmt.Sprintf("0x%04x", word(d, 1))},
+				uintField("event count", word(d, 3)), uintField("message count", word(d, 5)), {"events", hexString(d[7:])}}
+		}
+	case 15, 16:
+		if len(d) == 4 {
+			return []Field{uintField("address", word(d, 0)), uintField("quantity", word(d, 2))}
+		}
+	case 17, 20, 21:
+		if byteCounted(d, 0) {
+			return []Field{uintField("byte count", d[0]), {"data", hexString(d[1:])}}
+		}
+	case 24:
+		if len(d) >= 4 && int(word(d, 0)) == len(d)-2 && int(word(d, 2))*2 == len(d)-4 {
+			return []Field{uintField("byte count", word(d, 0)), uintField("fifo count", word(d, 2)), wordsField(d[4:])}
+		}
+	case 43:
+		if len(d) >= 6 && d[0] == meiReadDeviceId {
+			fields := []Field{uintField("MEI type", d[0]), uintField("read device id code", d[1]),
+				uintField("conformity level", d[2]), uintField("more follows", d[3]),
+				uintField("next object id", d[4]), uintField("number of objects", d[5])}
+			rest := d[6:]
+			for i := 0; i < int(d[5]); i++ {
+				if len(rest) < 2 || len(rest) < 2+int(rest[1]) {
+					return nil
+				}
+				fields = append(fields, Field{fmt.Sprintf("object %d", rest[0]), fmt.Sprintf("%q", rest[2:2+int(rest[1])])})
+				rest = rest[2+int(rest[1]):]
+			}
+			if len(rest) == 0 {
+				return fields
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package modbus
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func Test_Decoder(t *testing.T) {
+
+	Convey("Given a MBAP frame", t, func() {
+		data := []byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x06, 0x11, 0x03, 0x00, 0x6b, 0x00, 0x03}
+
+		Convey("the framing should be detected", func() {
+			f, err := Decode(data, FramingAuto)
+			So(err, ShouldBeNil)
+			So(f.Framing, ShouldEqual, FramingTCP)
+			So(f.Transaction, ShouldEqual, 7)
+			So(f.Unit, ShouldEqual, 0x11)
+			So(f.Direction, ShouldEqual, DirectionRequest)
+			So(f.Fields, ShouldResemble, []Field{{"address", "107"}, {"quantity", "3"}})
+		})
+
+		Convey("it should be printed readably", func() {
+			f, _ := Decode(data, FramingTCP)
+			So(f.String(), ShouldEqual, "TCP transaction 7 unit 17 request: Read Holding Registers (3)\n  address: 107\n  quantity: 3\n")
+		})
+
+		Convey("an invalid length field should be rejected", func() {
+			_, err := Decode(data[:11], FramingTCP)
+			So(err, ShouldHaveSameTypeAs, FramingError{})
+		})
+	})
+
+	Convey("Given a RTU frame", t, func() {
+		data := rtuFrame(0x11, &Pdu{3, []byte{0x06, 0x02, 0x2b, 0x00, 0x00, 0x00, 0x64}})
+
+		Convey("the response should be decoded", func() {
+			f, err := Decode(data, FramingAuto)
+			So(err, ShouldBeNil)
+			So(f.Framing, ShouldEqual, FramingRTU)
+			So(f.Direction, ShouldEqual, DirectionResponse)
+			So(f.Fields, ShouldResemble, []Field{{"byte count", "6"}, {"values", "555 0 100"}})
+		})
+
+		Convey("a wrong CRC should be reported", func() {
+			data[3] ^= 0xff
+			_, err := Decode(data, FramingRTU)
+			So(err, ShouldHaveSameTypeAs, CRCError{})
+		})
+	})
+
+	Convey("Given an ASCII frame", t, func() {
+
+		Convey("an exception should be decoded", func() {
+			f, err := Decode([]byte(":0183027A\r\n"), FramingAuto)
+			So(err, ShouldBeNil)
+			So(f.Framing, ShouldEqual, FramingASCII)
+			So(f.Direction, ShouldEqual, DirectionResponse)
+			So(f.Fields, ShouldResemble, []Field{{"exception", "2 (ILLEGAL DATA ADDRESS)"}})
+		})
+
+		Convey("missing line endings should be tolerated", func() {
+			f, err := Decode([]byte(":0183027A"), FramingASCII)
+			So(err, ShouldBeNil)
+			So(f.Unit, ShouldEqual, 1)
+		})
+	})
+
+	Convey("Given some PDUs", t, func() {
+
+		Convey("echoed writes should be ambiguous", func() {
+			d, fields := DecodePdu(&Pdu{5, []byte{0x00, 0xac, 0xff, 0x00}})
+			So(d, ShouldEqual, DirectionUnknown)
+			So(fields, ShouldResemble, []Field{{"address", "172"}, {"value", "on"}})
+		})
+
+		Convey("write multiple coils requests should list the bits", func() {
+			d, fields := DecodePdu(&Pdu{15, []byte{0x00, 0x13, 0x00, 0x0a, 0x02, 0xcd, 0x01}})
+			So(d, ShouldEqual, DirectionRequest)
+			So(fields[3], ShouldResemble, Field{"values", "1 0 1 1 0 0 1 1 1 0"})
+			d, _ = DecodePdu(&Pdu{15, []byte{0x00, 0x13, 0x00, 0x0a}})
+			So(d, ShouldEqual, DirectionResponse)
+		})
+
+		Convey("device identification should be decoded", func() {
+			d, fields := DecodePdu(&Pdu{43, []byte{0x0e, 0x01, 0x01, 0x00, 0x00, 0x01, 0x00, 0x03, 'F', 'o', 'o'}})
+			So(d, ShouldEqual, DirectionResponse)
+			So(fields[6], ShouldResemble, Field{"object 0", `"Foo"`})
+		})
+
+		Convey("unknown data should be dumped", func() {
+			d, fields := DecodePdu(&Pdu{3, []byte{0x01, 0x02}})
+			So(d, ShouldEqual, DirectionUnknown)
+			So(fields, ShouldResemble, []Field{{"data", "01 02"}})
+		})
+	})
+}
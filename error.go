@@ -8,6 +8,34 @@ import (
 	"fmt"
 )
 
+/* Exception Codes */
+
+const (
+	ExceptionIllegalFunction                    uint8 = 0x01
+	ExceptionIllegalDataAddress                 uint8 = 0x02
+	ExceptionIllegalDataValue                   uint8 = 0x03
+	ExceptionServerDeviceFailure                uint8 = 0x04
+	ExceptionAcknowledge                        uint8 = 0x05
+	ExceptionServerDeviceBusy                   uint8 = 0x06
+	ExceptionMemoryParityError                  uint8 = 0x08
+	ExceptionGatewayPathUnavailable             uint8 = 0x0A
+	ExceptionGatewayTargetDeviceFailedToRespond uint8 = 0x0B
+)
+
+/* Exception Sentinels (to be used with errors.Is) */
+
+var (
+	ErrIllegalFunction                    = Error{Exception: ExceptionIllegalFunction}
+	ErrIllegalDataAddress                 = Error{Exception: ExceptionIllegalDataAddress}
+	ErrIllegalDataValue                   = Error{Exception: ExceptionIllegalDataValue}
+	ErrServerDeviceFailure                = Error{Exception: ExceptionServerDeviceFailure}
+	ErrAcknowledge                        = Error{Exception: ExceptionAcknowledge}
+	ErrServerDeviceBusy                   = Error{Exception: ExceptionServerDeviceBusy}
+	ErrMemoryParityError                  = Error{Exception: ExceptionMemoryParityError}
+	ErrGatewayPathUnavailable             = Error{Exception: ExceptionGatewayPathUnavailable}
+	ErrGatewayTargetDeviceFailedToRespond = Error{Exception: ExceptionGatewayTargetDeviceFailedToRespond}
+)
+
 /* Modbus Error */
 
 type Error struct {
@@ -19,25 +47,25 @@ type Error struct {
 	Exception uint8
 }
 
-func getExceptionMessage(nr uint8) string {
+func ExceptionMessage(nr uint8) string {
 	switch nr {
-	case 0x01:
+	case ExceptionIllegalFunction:
 		return "ILLEGAL FUNCTION"
-	case 0x02:
+	case ExceptionIllegalDataAddress:
 		return "ILLEGAL DATA ADDRESS"
-	case 0x03:
+	case ExceptionIllegalDataValue:
 		return "ILLEGAL DATA VALUE"
-	case 0x04:
+	case ExceptionServerDeviceFailure:
 		return "SERVER DEVICE FAILURE"
-	case 0x05:
+	case ExceptionAcknowledge:
 		return "ACKNOWLEDGE"
-	case 0x06:
+	case ExceptionServerDeviceBusy:
 		return "SERVER DEVICE BUSY"
-	case 0x08:
+	case ExceptionMemoryParityError:
 		return "MEMORY PARITY ERROR"
-	case 0x0A:
+	case ExceptionGatewayPathUnavailable:
 		return "GATEWAY PATH UNAVAILABLE"
-	case 0x0B:
+	case ExceptionGatewayTargetDeviceFailedToRespond:
 		return "GATEWAY TARGET DEVICE FAILED TO RESPOND"
 
 	default:
@@ -46,5 +74,136 @@ func getExceptionMessage(nr uint8) string {
 }
 
 func (e Error) Error() string {
-	return fmt.Sprintf("Error %d (Function %d); Exception %d ('%s')", e.Code, (e.Code - 128), e.Exception, getExceptionMessage(e.Exception))
+	if e.Code == 0 {
+		return fmt.Sprintf("Exception %d ('%s')", e.Exception, ExceptionMessage(e.Exception))
+	}
+	return fmt.Sprintf("Error %d (Function %d); Exception %d ('%s')", e.Code, (e.Code - 128), e.Exception, ExceptionMessage(e.Exception))
+}
+
+// Is reports whether the target is an Error with the same exception code.
+// A target without error code (e.g. ErrIllegalDataAddress) matches any function.
+func (e Error) Is(target error) bool {
+	var t Error
+	switch v := target.(type) {
+	case Error:
+		t = v
+	case *Error:
+		if v == nil {
+			return false
+		}
+		t = *v
+	default:
+		return false
+	}
+	return t.Exception == e.Exception && (t.Code == 0 || t.Code == e.Code)
+}
+
+/* Transport Error */
+
+type TransportError struct {
+
+	// Failed operation (e.g. "connect", "write" or "read")
+	Op string
+
+	// Underlying error
+	Err error
+}
+
+func (e TransportError) Error() string {
+	return fmt.Sprintf("Could not %s: %s", e.Op, e.Err)
+}
+
+func (e TransportError) Unwrap() error {
+	return e.Err
+}
+
+/* Timeout Error */
+
+type TimeoutError struct {
+
+	// Operation that timed out (e.g. "connect", "write" or "read")
+	Op string
+
+	// Underlying error
+	Err error
+}
+
+func (e TimeoutError) Error() string {
+	return fmt.Sprintf("Timeout during %s: %s", e.Op, e.Err)
+}
+
+func (e TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+func (e TimeoutError) Timeout() bool {
+	return true
+}
+
+// transportError wraps err into a TimeoutError if it reports a timeout
+// and into a TransportError otherwise.
+func transportError(op string, err error) error {
+	if t, ok := err.(interface {
+		Timeout() bool
+	}); ok && t.Timeout() {
+		return TimeoutError{op, err}
+	}
+	return TransportError{op, err}
+}
+
+/* Framing Error */
+
+type FramingError struct {
+
+	// Reason of the rejection
+	Reason string
+}
+
+func (e FramingError) Error() string {
+	return fmt.Sprintf("Invalid frame: %s", e.Reason)
+}
+
+/* CRC Error (CRC of RTU and LRC of ASCII frames) */
+
+type CRCError struct {
+
+	// Checksum calculated from the received frame
+	Expected uint16
+
+	// Checksum contained in the received frame
+	Actual uint16
+}
+
+func (e CRCError) Error() string {
+	return fmt.Sprintf("Invalid checksum: 0x%04x instead of 0x%04x", e.Actual, e.Expected)
+}
+
+/* Invalid Response Error */
+
+type ResponseError struct {
+
+	// Function Code of the request
+	Function uint8
+
+	// Reason of the rejection
+	Reason string
+}
+
+func (e ResponseError) Error() string {
+	return fmt.Sprintf("Invalid response (Function %d): %s", e.Function, e.Reason)
+}
+
+/* Invalid Request Error */
+
+type RequestError struct {
+
+	// Function Code of the request
+	Function uint8
+
+	// Reason of the rejection
+	Reason string
+}
+
+func (e RequestError) Error() string {
+	return fmt.Sprintf("Invalid request (Function %d): %s", e.Function, e.Reason)
 }
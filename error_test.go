@@ -1,7 +1,11 @@
 package modbus
 
 import (
+	"errors"
+	"fmt"
 	. "github.com/smartystreets/goconvey/convey"
+	"net"
+	"os"
 	"testing"
 )
 
@@ -17,15 +21,53 @@ func Test_Error(t *testing.T) {
 
 	Convey("Given an exception nr", t, func() {
 		Convey("it should print the exception message", func() {
-			So(getExceptionMessage(1), ShouldEqual, "ILLEGAL FUNCTION")
-			So(getExceptionMessage(2), ShouldEqual, "ILLEGAL DATA ADDRESS")
-			So(getExceptionMessage(3), ShouldEqual, "ILLEGAL DATA VALUE")
-			So(getExceptionMessage(4), ShouldEqual, "SERVER DEVICE FAILURE")
-			So(getExceptionMessage(5), ShouldEqual, "ACKNOWLEDGE")
-			So(getExceptionMessage(6), ShouldEqual, "SERVER DEVICE BUSY")
-			So(getExceptionMessage(8), ShouldEqual, "MEMORY PARITY ERROR")
-			So(getExceptionMessage(10), ShouldEqual, "GATEWAY PATH UNAVAILABLE")
-			So(getExceptionMessage(11), ShouldEqual, "GATEWAY TARGET DEVICE FAILED TO RESPOND")
+			So(ExceptionMessage(1), ShouldEqual, "ILLEGAL FUNCTION")
+			So(ExceptionMessage(2), ShouldEqual, "ILLEGAL DATA ADDRESS")
+			So(ExceptionMessage(3), ShouldEqual, "ILLEGAL DATA VALUE")
+			So(ExceptionMessage(4), ShouldEqual, "SERVER DEVICE FAILURE")
+			So(ExceptionMessage(5), ShouldEqual, "ACKNOWLEDGE")
+			So(ExceptionMessage(6), ShouldEqual, "SERVER DEVICE BUSY")
+			So(ExceptionMessage(8), ShouldEqual, "MEMORY PARITY ERROR")
+			So(ExceptionMessage(10), ShouldEqual, "GATEWAY PATH UNAVAILABLE")
+			So(ExceptionMessage(11), ShouldEqual, "GATEWAY TARGET DEVICE FAILED TO RESPOND")
+		})
+	})
+
+	Convey("Given an exception response error", t, func() {
+		var err error = Error{0x83, ExceptionIllegalDataAddress}
+
+		Convey("it should match the exception sentinel", func() {
+			So(errors.Is(err, ErrIllegalDataAddress), ShouldBeTrue)
+			So(errors.Is(err, Error{0x83, 0x02}), ShouldBeTrue)
+			So(errors.Is(err, &Error{0x83, 0x02}), ShouldBeTrue)
+		})
+
+		Convey("it should not match other exceptions", func() {
+			So(errors.Is(err, ErrIllegalFunction), ShouldBeFalse)
+			So(errors.Is(err, Error{0x84, 0x02}), ShouldBeFalse)
+		})
+
+		Convey("it should be found within wrapped errors", func() {
+			So(errors.Is(fmt.Errorf("read: %w", err), ErrIllegalDataAddress), ShouldBeTrue)
+		})
+
+		Convey("a sentinel should print the exception message", func() {
+			So(ErrGatewayPathUnavailable.Error(), ShouldEqual, "Exception 10 ('GATEWAY PATH UNAVAILABLE')")
+		})
+	})
+
+	Convey("Given a transport error", t, func() {
+
+		Convey("timeouts should be wrapped into a TimeoutError", func() {
+			err := transportError("read", &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded})
+			So(err, ShouldHaveSameTypeAs, TimeoutError{})
+			So(errors.Is(err, os.ErrDeadlineExceeded), ShouldBeTrue)
+		})
+
+		Convey("other errors should be wrapped into a TransportError", func() {
+			err := transportError("write", net.ErrClosed)
+			So(err, ShouldHaveSameTypeAs, TransportError{})
+			So(errors.Is(err, net.ErrClosed), ShouldBeTrue)
 		})
 	})
 }
@@ -0,0 +1,151 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Modbus TCP to RTU gateway
+ */
+
+package modbus
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type gatewayBus struct {
+	sync.Mutex
+	rtu *rtuTransporter
+}
+
+type Gateway struct {
+	timeout  time.Duration
+	mu       sync.RWMutex
+	routes   map[uint8]*gatewayBus
+	listener net.Listener
+}
+
+// NewGateway creates a gateway that answers requests with a GATEWAY TARGET
+// DEVICE FAILED TO RESPOND exception if the bus is not free within the
+// timeout or the slave does not respond within the timeout. Requests that
+// timed out while waiting for the bus are not sent.
+func NewGateway(timeout time.Duration) *Gateway {
+	return &Gateway{timeout: timeout, routes: map[uint8]*gatewayBus{}}
+}
+
+// AddBus routes the given unit ids to the slaves on a serial bus.
+// Requests to the same bus are serialized. Reads of ports without
+// SetReadDeadline are bounded by the timeout as well. Since the baud rate
+// of the port is unknown, requests are not preceded by the silent
+// interval t3.5; use OpenBus for that.
+func (g *Gateway) AddBus(port io.ReadWriter, units ...uint8) {
+	g.addBus(port, 0, units)
+}
+
+// OpenBus opens the port of the configuration and routes the given unit
+// ids to it like AddBus. Requests are preceded by the silent interval t3.5.
+func (g *Gateway) OpenBus(c *SerialConfig, units ...uint8) error {
+	port, err := OpenPort(c)
+	if err != nil {
+		return err
+	}
+	g.addBus(port, c.Timing().T35, units)
+	return nil
+}
+
+func (g *Gateway) addBus(port io.ReadWriter, gap time.Duration, units []uint8) {
+	bus := &gatewayBus{rtu: &rtuTransporter{port: withReadDeadline(port), timeout: g.timeout, gap: gap}}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, u := range units {
+		g.routes[u] = bus
+	}
+}
+
+// Forward sends the request to the slave with the given unit id and
+// returns its response or a gateway exception.
+func (g *Gateway) Forward(unit uint8, req *Pdu) *Pdu {
+	g.mu.RLock()
+	bus, ok := g.routes[unit]
+	g.mu.RUnlock()
+	if !ok {
+		return exceptionPdu(req.Function, ExceptionGatewayPathUnavailable)
+	}
+
+	type result struct {
+		res *Pdu
+		err error
+	}
+	const (
+		queued int32 = iota
+		started
+		abandoned
+	)
+	var state int32
+	done := make(chan result, 1)
+	go func() {
+		bus.Lock()
+		defer bus.Unlock()
+		if !atomic.CompareAndSwapInt32(&state, queued, started) {
+			// the request timed out while waiting for the bus
+			return
+		}
+		res, err := bus.rtu.sendTo(unit, req)
+		done <- result{res, err}
+	}()
+
+	var timeout <-chan time.Time
+	if g.timeout > 0 {
+		timer := time.NewTimer(g.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var r result
+	select {
+	case r = <-done:
+	case <-timeout:
+		if atomic.CompareAndSwapInt32(&state, queued, abandoned) {
+			return exceptionPdu(req.Function, ExceptionGatewayTargetDeviceFailedToRespond)
+		}
+		// the request is on the line; its response is bounded by the
+		// read deadline
+		r = <-done
+	}
+	if r.err != nil {
+		return exceptionPdu(req.Function, ExceptionGatewayTargetDeviceFailedToRespond)
+	}
+	// nil for broadcast requests
+	return r.res
+}
+
+// Serve accepts MBAP requests on the listener until Close is called.
+func (g *Gateway) Serve(l net.Listener) error {
+	g.mu.Lock()
+	g.listener = l
+	g.mu.Unlock()
+	return serveTcp(l, func(conn net.Conn, h *header, req *Pdu) *Pdu {
+		return g.Forward(h.unit, req)
+	})
+}
+
+func (g *Gateway) ListenAndServe(address string) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return g.Serve(l)
+}
+
+func (g *Gateway) Close() error {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if g.listener == nil {
+		return nil
+	}
+	return g.listener.Close()
+}
+
+func exceptionPdu(f uint8, exception uint8) *Pdu {
+	return &Pdu{f | 0x80, []byte{exception}}
+}
@@ -0,0 +1,118 @@
+package modbus
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"net"
+	"testing"
+	"time"
+)
+
+type blockingPort struct {
+	block chan struct{}
+}
+
+func (p *blockingPort) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (p *blockingPort) Read(b []byte) (int, error) {
+	<-p.block
+	return 0, net.ErrClosed
+}
+
+func Test_Gateway(t *testing.T) {
+
+	Convey("Given a gateway with a configured serial bus", t, func() {
+		conf := &SerialConfig{Address: "gw", BaudRate: 115200, Backend: NewLoopback()}
+		s, err := OpenRtuServer(conf)
+		So(err, ShouldBeNil)
+		s.SetUnitHandler(4, NewDataModel(0, 0, 0, 10))
+		s.Start()
+		defer s.Stop()
+		g := NewGateway(time.Second)
+		So(g.OpenBus(conf, 4), ShouldBeNil)
+
+		Convey("requests should be preceded by the silent interval", func() {
+			So(g.routes[4].rtu.gap, ShouldEqual, conf.Timing().T35)
+			So(g.Forward(4, &Pdu{6, []byte{0, 1, 0, 7}}), ShouldResemble, &Pdu{6, []byte{0, 1, 0, 7}})
+			So(g.Forward(4, &Pdu{3, []byte{0, 1, 0, 1}}), ShouldResemble, &Pdu{3, []byte{2, 0, 7}})
+		})
+	})
+
+	Convey("Given a gateway with a serial bus", t, func() {
+		g := NewGateway(50 * time.Millisecond)
+		port := &dummyPort{handle: func(frame []byte) []byte {
+			return rtuFrame(frame[0], &Pdu{3, []byte{0x02, 0x00, frame[0]}})
+		}}
+		g.AddBus(port, 1, 2)
+
+		Convey("requests should be routed by unit id", func() {
+			res := g.Forward(2, &Pdu{3, []byte{0, 0, 0, 1}})
+			So(res, ShouldResemble, &Pdu{3, []byte{0x02, 0x00, 0x02}})
+		})
+
+		Convey("unknown units should be answered with a gateway exception", func() {
+			res := g.Forward(3, &Pdu{3, []byte{0, 0, 0, 1}})
+			So(res, ShouldResemble, &Pdu{0x83, []byte{ExceptionGatewayPathUnavailable}})
+		})
+
+		Convey("slaves that do not respond should be reported", func() {
+			p := &blockingPort{make(chan struct{})}
+			defer close(p.block)
+			g.AddBus(p, 5)
+			start := time.Now()
+			res := g.Forward(5, &Pdu{3, []byte{0, 0, 0, 1}})
+			So(res, ShouldResemble, &Pdu{0x83, []byte{ExceptionGatewayTargetDeviceFailedToRespond}})
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+
+			// the blocked read must not hold the bus
+			locked := make(chan struct{})
+			go func() {
+				g.routes[5].Lock()
+				close(locked)
+				g.routes[5].Unlock()
+			}()
+			released := false
+			select {
+			case <-locked:
+				released = true
+			case <-time.After(time.Second):
+			}
+			So(released, ShouldBeTrue)
+		})
+
+		Convey("requests that time out while waiting for the bus should not be sent", func() {
+			writes := 0
+			port.handle = func(frame []byte) []byte {
+				writes++
+				return rtuFrame(frame[0], &Pdu{6, frame[2:6]})
+			}
+			bus := g.routes[1]
+			bus.Lock()
+			res := g.Forward(1, &Pdu{6, []byte{0, 1, 0, 7}})
+			So(res, ShouldResemble, &Pdu{0x86, []byte{ExceptionGatewayTargetDeviceFailedToRespond}})
+			bus.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			bus.Lock()
+			So(writes, ShouldEqual, 0)
+			bus.Unlock()
+		})
+
+		Convey("when serving MBAP requests", func() {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			So(err, ShouldBeNil)
+			go g.Serve(l)
+			defer g.Close()
+
+			addr := l.Addr().(*net.TCPAddr)
+			c := &mbClient{&tcpTransporter{host: "127.0.0.1", port: uint(addr.Port), id: 1, timeout: time.Second}}
+			defer c.Transporter().Close()
+
+			Convey("the response of the slave should be returned", func() {
+				v, err := c.ReadHoldingRegisters(0, 1)
+				So(err, ShouldBeNil)
+				So(v, ShouldResemble, []uint16{1})
+			})
+		})
+	})
+}
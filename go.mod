@@ -0,0 +1,8 @@
+module github.com/flosse/go-modbus
+
+go 1.16
+
+require (
+	github.com/smartystreets/goconvey v1.6.4
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,1303 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Typed requests and responses of the function codes
+ */
+
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Message is a request or response of a particular function code.
+// The binary form is the complete PDU including the function code.
+type Message interface {
+	FunctionCode() uint8
+	MarshalBinary() ([]byte, error)
+	UnmarshalBinary(pdu []byte) error
+}
+
+const (
+	maxReadBits       = 2000
+	maxWriteBits      = 1968
+	maxReadRegisters  = 125
+	maxWriteRegisters = 123
+	maxRwRegisters    = 121
+	maxFifoCount      = 31
+	maxCommEvents     = 64
+
+	// reference type of the file record sub-requests
+	fileReference = 6
+	maxFileRecord = 9999
+)
+
+func (pdu *Pdu) MarshalBinary() ([]byte, error) {
+	return pdu.pack()
+}
+
+func (pdu *Pdu) UnmarshalBinary(data []byte) error {
+	p, err := unpackPdu(data)
+	if err != nil {
+		return err
+	}
+	*pdu = *p
+	return nil
+}
+
+// NewPdu encodes a message as PDU.
+func NewPdu(m Message) (*Pdu, error) {
+	bin, err := m.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	pdu, err := unpackPdu(bin)
+	if err != nil {
+		return nil, err
+	}
+	if len(pdu.Data) == 0 {
+		pdu.Data = nil
+	}
+	return pdu, nil
+}
+
+// Decode decodes the PDU into the given message.
+func (pdu *Pdu) Decode(m Message) error {
+	bin, err := pdu.pack()
+	if err != nil {
+		return err
+	}
+	return m.UnmarshalBinary(bin)
+}
+
+/* Helpers */
+
+func requestData(fn uint8, pdu []byte) ([]byte, error) {
+	if len(pdu) < 1 || pdu[0] != fn {
+		return nil, RequestError{fn, "wrong function code"}
+	}
+	return pdu[1:], nil
+}
+
+// responseData strips the function code of a response. Exception
+// responses are returned as Error.
+func responseData(fn uint8, pdu []byte) ([]byte, error) {
+	if len(pdu) == 2 && pdu[0] == fn|0x80 {
+		return nil, Error{pdu[0], pdu[1]}
+	}
+	if len(pdu) < 1 || pdu[0] != fn {
+		return nil, ResponseError{fn, "wrong function code"}
+	}
+	return pdu[1:], nil
+}
+
+func requestLength(fn uint8, data []byte, n int) error {
+	if l := len(data); l != n {
+		return RequestError{fn, fmt.Sprintf("invalid data length (%d instead of %d bytes)", l, n)}
+	}
+	return nil
+}
+
+func responseLength(fn uint8, data []byte, n int) error {
+	if l := len(data); l != n {
+		return ResponseError{fn, fmt.Sprintf("invalid data length (%d instead of %d bytes)", l, n)}
+	}
+	return nil
+}
+
+func checkQuantity(fn uint8, q uint16, max uint16) error {
+	if q < 1 || q > max {
+		return RequestError{fn, fmt.Sprintf("quantity %d out of range 1-%d", q, max)}
+	}
+	return nil
+}
+
+// checkRead checks the quantity and the addressed range of a read request
+// to be marshaled.
+func checkRead(fn uint8, addr, qty uint16, max int) error {
+	if err := checkCount(fn, int(qty), max); err != nil {
+		return err
+	}
+	if int(addr)+int(qty) > 0x10000 {
+		return RequestError{fn, fmt.Sprintf("quantity %d at address %d exceeds the address space", qty, addr)}
+	}
+	return nil
+}
+
+// checkCount checks the number of values of a request to be marshaled.
+func checkCount(fn uint8, n int, max int) error {
+	if n < 1 || n > max {
+		return RequestError{fn, fmt.Sprintf("quantity %d out of range 1-%d", n, max)}
+	}
+	return nil
+}
+
+func marshalWords(fn uint8, words ...uint16) []byte {
+	return append([]byte{fn}, wordsToByteArray(words...)...)
+}
+
+func unmarshalAddrQty(fn uint8, pdu []byte, max uint16) (addr, qty uint16, err error) {
+	data, err := requestData(fn, pdu)
+	if err != nil {
+		return
+	}
+	if err = requestLength(fn, data, 4); err != nil {
+		return
+	}
+	addr, qty = binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+	return addr, qty, checkQuantity(fn, qty, max)
+}
+
+// marshalCounted appends a byte count and the data to the function code.
+func marshalCounted(fn uint8, data []byte) ([]byte, error) {
+	if len(data) > pduLength-2 {
+		return nil, fmt.Errorf("Invalid length of data (%d instead of max. %d bytes)", len(data), pduLength-2)
+	}
+	return append([]byte{fn, uint8(len(data))}, data...), nil
+}
+
+func unmarshalCounted(fn uint8, pdu []byte) ([]byte, error) {
+	data, err := responseData(fn, pdu)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 1 {
+		return nil, ResponseError{fn, "missing byte count"}
+	}
+	if bc := int(data[0]); bc != len(data)-1 {
+		return nil, ResponseError{fn, fmt.Sprintf("byte count %d does not match the data length %d", bc, len(data)-1)}
+	}
+	return data[1:], nil
+}
+
+// unmarshalRequestCounted is like unmarshalCounted for requests.
+func unmarshalRequestCounted(fn uint8, pdu []byte) ([]byte, error) {
+	data, err := requestData(fn, pdu)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 1 {
+		return nil, RequestError{fn, "missing byte count"}
+	}
+	if bc := int(data[0]); bc != len(data)-1 {
+		return nil, RequestError{fn, fmt.Sprintf("byte count %d does not match the data length %d", bc, len(data)-1)}
+	}
+	return data[1:], nil
+}
+
+func unmarshalEcho(fn uint8, pdu []byte, n int) ([]uint16, error) {
+	data, err := responseData(fn, pdu)
+	if err != nil {
+		return nil, err
+	}
+	if err := responseLength(fn, data, n); err != nil {
+		return nil, err
+	}
+	return bytesToWordArray(data...), nil
+}
+
+func unmarshalRequestWords(fn uint8, pdu []byte, n int) ([]uint16, error) {
+	data, err := requestData(fn, pdu)
+	if err != nil {
+		return nil, err
+	}
+	if err := requestLength(fn, data, n); err != nil {
+		return nil, err
+	}
+	return bytesToWordArray(data...), nil
+}
+
+/* Exception */
+
+type ExceptionResponse struct {
+
+	// Function code of the request
+	Function  uint8
+	Exception uint8
+}
+
+func (m *ExceptionResponse) FunctionCode() uint8 {
+	return m.Function | 0x80
+}
+
+func (m *ExceptionResponse) MarshalBinary() ([]byte, error) {
+	return []byte{m.Function | 0x80, m.Exception}, nil
+}
+
+func (m *ExceptionResponse) UnmarshalBinary(pdu []byte) error {
+	if len(pdu) != 2 || pdu[0]&0x80 == 0 {
+		return ResponseError{0, "no exception response"}
+	}
+	m.Function, m.Exception = pdu[0]&^0x80, pdu[1]
+	return nil
+}
+
+/* Function Code 1 */
+
+type ReadCoilsRequest struct {
+	Address, Quantity uint16
+}
+
+func (m *ReadCoilsRequest) FunctionCode() uint8 {
+	return 1
+}
+
+func (m *ReadCoilsRequest) MarshalBinary() ([]byte, error) {
+	if err := checkRead(1, m.Address, m.Quantity, maxReadBits); err != nil {
+		return nil, err
+	}
+	return marshalWords(1, m.Address, m.Quantity), nil
+}
+
+func (m *ReadCoilsRequest) UnmarshalBinary(pdu []byte) (err error) {
+	m.Address, m.Quantity, err = unmarshalAddrQty(1, pdu, maxReadBits)
+	return
+}
+
+// ReadCoilsResponse contains all bits of the response bytes, i.e.
+// the padding up to a multiple of 8 as well.
+type ReadCoilsResponse struct {
+	Values []bool
+}
+
+func (m *ReadCoilsResponse) FunctionCode() uint8 {
+	return 1
+}
+
+func (m *ReadCoilsResponse) MarshalBinary() ([]byte, error) {
+	return marshalCounted(1, packBits(m.Values))
+}
+
+func (m *ReadCoilsResponse) UnmarshalBinary(pdu []byte) error {
+	data, err := unmarshalCounted(1, pdu)
+	if err != nil {
+		return err
+	}
+	m.Values = unpackBits(data, len(data)*8)
+	return nil
+}
+
+/* Function Code 2 */
+
+type ReadDiscreteInputsRequest struct {
+	Address, Quantity uint16
+}
+
+func (m *ReadDiscreteInputsRequest) FunctionCode() uint8 {
+	return 2
+}
+
+func (m *ReadDiscreteInputsRequest) MarshalBinary() ([]byte, error) {
+	if err := checkRead(2, m.Address, m.Quantity, maxReadBits); err != nil {
+		return nil, err
+	}
+	return marshalWords(2, m.Address, m.Quantity), nil
+}
+
+func (m *ReadDiscreteInputsRequest) UnmarshalBinary(pdu []byte) (err error) {
+	m.Address, m.Quantity, err = unmarshalAddrQty(2, pdu, maxReadBits)
+	return
+}
+
+// ReadDiscreteInputsResponse contains all bits of the response bytes,
+// i.e. the padding up to a multiple of 8 as well.
+type ReadDiscreteInputsResponse struct {
+	Values []bool
+}
+
+func (m *ReadDiscreteInputsResponse) FunctionCode() uint8 {
+	return 2
+}
+
+func (m *ReadDiscreteInputsResponse) MarshalBinary() ([]byte, error) {
+	return marshalCounted(2, packBits(m.Values))
+}
+
+func (m *ReadDiscreteInputsResponse) UnmarshalBinary(pdu []byte) error {
+	data, err := unmarshalCounted(2, pdu)
+	if err != nil {
+		return err
+	}
+	m.Values = unpackBits(data, len(data)*8)
+	return nil
+}
+
+/* Function Code 3 */
+
+type ReadHoldingRegistersRequest struct {
+	Address, Quantity uint16
+}
+
+func (m *ReadHoldingRegistersRequest) FunctionCode() uint8 {
+	return 3
+}
+
+func (m *ReadHoldingRegistersRequest) MarshalBinary() ([]byte, error) {
+	if err := checkRead(3, m.Address, m.Quantity, maxReadRegisters); err != nil {
+		return nil, err
+	}
+	return marshalWords(3, m.Address, m.Quantity), nil
+}
+
+func (m *ReadHoldingRegistersRequest) UnmarshalBinary(pdu []byte) (err error) {
+	m.Address, m.Quantity, err = unmarshalAddrQty(3, pdu, maxReadRegisters)
+	return
+}
+
+type ReadHoldingRegistersResponse struct {
+	Values []uint16
+}
+
+func (m *ReadHoldingRegistersResponse) FunctionCode() uint8 {
+	return 3
+}
+
+func (m *ReadHoldingRegistersResponse) MarshalBinary() ([]byte, error) {
+	return marshalCounted(3, wordsToByteArray(m.Values...))
+}
+
+func (m *ReadHoldingRegistersResponse) UnmarshalBinary(pdu []byte) (err error) {
+	m.Values, err = unmarshalRegisters(3, pdu)
+	return
+}
+
+func unmarshalRegisters(fn uint8, pdu []byte) ([]uint16, error) {
+	data, err := unmarshalCounted(fn, pdu)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%2 != 0 {
+		return nil, ResponseError{fn, fmt.Sprintf("odd byte count %d", len(data))}
+	}
+	return bytesToWordArray(data...), nil
+}
+
+/* Function Code 4 */
+
+type ReadInputRegistersRequest struct {
+	Address, Quantity uint16
+}
+
+func (m *ReadInputRegistersRequest) FunctionCode() uint8 {
+	return 4
+}
+
+func (m *ReadInputRegistersRequest) MarshalBinary() ([]byte, error) {
+	if err := checkRead(4, m.Address, m.Quantity, maxReadRegisters); err != nil {
+		return nil, err
+	}
+	return marshalWords(4, m.Address, m.Quantity), nil
+}
+
+func (m *ReadInputRegistersRequest) UnmarshalBinary(pdu []byte) (err error) {
+	m.Address, m.Quantity, err = unmarshalAddrQty(4, pdu, maxReadRegisters)
+	return
+}
+
+type ReadInputRegistersResponse struct {
+	Values []uint16
+}
+
+func (m *ReadInputRegistersResponse) FunctionCode() uint8 {
+	return 4
+}
+
+func (m *ReadInputRegistersResponse) MarshalBinary() ([]byte, error) {
+	return marshalCounted(4, wordsToByteArray(m.Values...))
+}
+
+func (m *ReadInputRegistersResponse) UnmarshalBinary(pdu []byte) (err error) {
+	m.Values, err = unmarshalRegisters(4, pdu)
+	return
+}
+
+/* Function Code 5 */
+
+type WriteSingleCoilRequest struct {
+	Address uint16
+	Value   bool
+}
+
+func coilValue(v bool) uint16 {
+	if v {
+		return 0xff00
+	}
+	return 0x0000
+}
+
+func (m *WriteSingleCoilRequest) FunctionCode() uint8 {
+	return 5
+}
+
+func (m *WriteSingleCoilRequest) MarshalBinary() ([]byte, error) {
+	return marshalWords(5, m.Address, coilValue(m.Value)), nil
+}
+
+func (m *WriteSingleCoilRequest) UnmarshalBinary(pdu []byte) error {
+	w, err := unmarshalRequestWords(5, pdu, 4)
+	if err != nil {
+		return err
+	}
+	if w[1] != 0xff00 && w[1] != 0x0000 {
+		return RequestError{5, fmt.Sprintf("invalid coil value 0x%04x", w[1])}
+	}
+	m.Address, m.Value = w[0], w[1] == 0xff00
+	return nil
+}
+
+type WriteSingleCoilResponse struct {
+	Address uint16
+	Value   bool
+}
+
+func (m *WriteSingleCoilResponse) FunctionCode() uint8 {
+	return 5
+}
+
+func (m *WriteSingleCoilResponse) MarshalBinary() ([]byte, error) {
+	return marshalWords(5, m.Address, coilValue(m.Value)), nil
+}
+
+func (m *WriteSingleCoilResponse) UnmarshalBinary(pdu []byte) error {
+	w, err := unmarshalEcho(5, pdu, 4)
+	if err != nil {
+		return err
+	}
+	if w[1] != 0xff00 && w[1] != 0x0000 {
+		return ResponseError{5, fmt.Sprintf("invalid coil value 0x%04x", w[1])}
+	}
+	m.Address, m.Value = w[0], w[1] == 0xff00
+	return nil
+}
+
+/* Function Code 6 */
+
+type WriteSingleRegisterRequest struct {
+	Address, Value uint16
+}
+
+func (m *WriteSingleRegisterRequest) FunctionCode() uint8 {
+	return 6
+}
+
+func (m *WriteSingleRegisterRequest) MarshalBinary() ([]byte, error) {
+	return marshalWords(6, m.Address, m.Value), nil
+}
+
+func (m *WriteSingleRegisterRequest) UnmarshalBinary(pdu []byte) error {
+	w, err := unmarshalRequestWords(6, pdu, 4)
+	if err != nil {
+		return err
+	}
+	m.Address, m.Value = w[0], w[1]
+	return nil
+}
+
+type WriteSingleRegisterResponse struct {
+	Address, Value uint16
+}
+
+func (m *WriteSingleRegisterResponse) FunctionCode() uint8 {
+	return 6
+}
+
+func (m *WriteSingleRegisterResponse) MarshalBinary() ([]byte, error) {
+	return marshalWords(6, m.Address, m.Value), nil
+}
+
+func (m *WriteSingleRegisterResponse) UnmarshalBinary(pdu []byte) error {
+	w, err := unmarshalEcho(6, pdu, 4)
+	if err != nil {
+		return err
+	}
+	m.Address, m.Value = w[0], w[1]
+	return nil
+}
+
+/* Function Code 7 */
+
+type ReadExceptionStatusRequest struct{}
+
+func (m *ReadExceptionStatusRequest) FunctionCode() uint8 {
+	return 7
+}
+
+func (m *ReadExceptionStatusRequest) MarshalBinary() ([]byte, error) {
+	return []byte{7}, nil
+}
+
+func (m *ReadExceptionStatusRequest) UnmarshalBinary(pdu []byte) error {
+	data, err := requestData(7, pdu)
+	if err != nil {
+		return err
+	}
+	return requestLength(7, data, 0)
+}
+
+type ReadExceptionStatusResponse struct {
+	Status uint8
+}
+
+func (m *ReadExceptionStatusResponse) FunctionCode() uint8 {
+	return 7
+}
+
+func (m *ReadExceptionStatusResponse) MarshalBinary() ([]byte, error) {
+	return []byte{7, m.Status}, nil
+}
+
+func (m *ReadExceptionStatusResponse) UnmarshalBinary(pdu []byte) error {
+	data, err := responseData(7, pdu)
+	if err != nil {
+		return err
+	}
+	if err := responseLength(7, data, 1); err != nil {
+		return err
+	}
+	m.Status = data[0]
+	return nil
+}
+
+/* Function Code 8 */
+
+type DiagnosticsRequest struct {
+	SubFunction uint16
+	Data        []uint16
+}
+
+func (m *DiagnosticsRequest) FunctionCode() uint8 {
+	return 8
+}
+
+func (m *DiagnosticsRequest) MarshalBinary() ([]byte, error) {
+	return marshalWords(8, append([]uint16{m.SubFunction}, m.Data...)...), nil
+}
+
+func (m *DiagnosticsRequest) UnmarshalBinary(pdu []byte) error {
+	data, err := requestData(8, pdu)
+	if err != nil {
+		return err
+	}
+	if len(data) < 2 {
+		return RequestError{8, "missing sub-function"}
+	}
+	m.SubFunction, m.Data = binary.BigEndian.Uint16(data), bytesToWordArray(data[2:]...)
+	return nil
+}
+
+type DiagnosticsResponse struct {
+	SubFunction uint16
+	Data        []uint16
+}
+
+func (m *DiagnosticsResponse) FunctionCode() uint8 {
+	return 8
+}
+
+func (m *DiagnosticsResponse) MarshalBinary() ([]byte, error) {
+	return marshalWords(8, append([]uint16{m.SubFunction}, m.Data...)...), nil
+}
+
+func (m *DiagnosticsResponse) UnmarshalBinary(pdu []byte) error {
+	data, err := responseData(8, pdu)
+	if err != nil {
+		return err
+	}
+	if l := len(data); l < 2 {
+		return ResponseError{8, fmt.Sprintf("invalid data length (%d bytes)", l)}
+	}
+	m.SubFunction, m.Data = binary.BigEndian.Uint16(data), bytesToWordArray(data[2:]...)
+	return nil
+}
+
+/* Function Code 11 */
+
+type GetCommEventCounterRequest struct{}
+
+func (m *GetCommEventCounterRequest) FunctionCode() uint8 {
+	return 11
+}
+
+func (m *GetCommEventCounterRequest) MarshalBinary() ([]byte, error) {
+	return []byte{11}, nil
+}
+
+func (m *GetCommEventCounterRequest) UnmarshalBinary(pdu []byte) error {
+	data, err := requestData(11, pdu)
+	if err != nil {
+		return err
+	}
+	return requestLength(11, data, 0)
+}
+
+type GetCommEventCounterResponse struct {
+	Status, EventCount uint16
+}
+
+func (m *GetCommEventCounterResponse) FunctionCode() uint8 {
+	return 11
+}
+
+func (m *GetCommEventCounterResponse) MarshalBinary() ([]byte, error) {
+	return marshalWords(11, m.Status, m.EventCount), nil
+}
+
+func (m *GetCommEventCounterResponse) UnmarshalBinary(pdu []byte) error {
+	w, err := unmarshalEcho(11, pdu, 4)
+	if err != nil {
+		return err
+	}
+	m.Status, m.EventCount = w[0], w[1]
+	return nil
+}
+
+/* Function Code 12 */
+
+type GetCommEventLogRequest struct{}
+
+func (m *GetCommEventLogRequest) FunctionCode() uint8 {
+	return 12
+}
+
+func (m *GetCommEventLogRequest) MarshalBinary() ([]byte, error) {
+	return []byte{12}, nil
+}
+
+func (m *GetCommEventLogRequest) UnmarshalBinary(pdu []byte) error {
+	data, err := requestData(12, pdu)
+	if err != nil {
+		return err
+	}
+	return requestLength(12, data, 0)
+}
+
+// GetCommEventLogResponse contains the event bytes of the device,
+// the most recent one first.
+type GetCommEventLogResponse struct {
+	Status, EventCount, MessageCount uint16
+	Events                           []byte
+}
+
+func (m *GetCommEventLogResponse) FunctionCode() uint8 {
+	return 12
+}
+
+func (m *GetCommEventLogResponse) MarshalBinary() ([]byte, error) {
+	if len(m.Events) > maxCommEvents {
+		return nil, fmt.Errorf("Too many events (%d)", len(m.Events))
+	}
+	return marshalCounted(12, append(wordsToByteArray(m.Status, m.EventCount, m.MessageCount), m.Events...))
+}
+
+func (m *GetCommEventLogResponse) UnmarshalBinary(pdu []byte) error {
+	data, err := unmarshalCounted(12, pdu)
+	if err != nil {
+		return err
+	}
+	if l := len(data); l < 6 || l > 6+maxCommEvents {
+		return ResponseError{12, fmt.Sprintf("invalid byte count %d", l)}
+	}
+	w := bytesToWordArray(data[:6]...)
+	m.Status, m.EventCount, m.MessageCount = w[0], w[1], w[2]
+	m.Events = append([]byte{}, data[6:]...)
+	return nil
+}
+
+/* Function Code 15 */
+
+type WriteMultipleCoilsRequest struct {
+	Address uint16
+	Values  []bool
+}
+
+func (m *WriteMultipleCoilsRequest) FunctionCode() uint8 {
+	return 15
+}
+
+func (m *WriteMultipleCoilsRequest) MarshalBinary() ([]byte, error) {
+	if err := checkCount(15, len(m.Values), maxWriteBits); err != nil {
+		return nil, err
+	}
+	b := packBits(m.Values)
+	bin := marshalWords(15, m.Address, uint16(len(m.Values)))
+	return append(append(bin, uint8(len(b))), b...), nil
+}
+
+func (m *WriteMultipleCoilsRequest) UnmarshalBinary(pdu []byte) error {
+	data, err := requestData(15, pdu)
+	if err != nil {
+		return err
+	}
+	if len(data) < 5 {
+		return RequestError{15, fmt.Sprintf("invalid data length (%d bytes)", len(data))}
+	}
+	addr, qty, bc := binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:]), int(data[4])
+	if err := checkQuantity(15, qty, maxWriteBits); err != nil {
+		return err
+	}
+	if bc != (int(qty)+7)/8 || len(data) != 5+bc {
+		return RequestError{15, fmt.Sprintf("byte count %d does not match quantity %d", bc, qty)}
+	}
+	m.Address, m.Values = addr, unpackBits(data[5:], int(qty))
+	return nil
+}
+
+type WriteMultipleCoilsResponse struct {
+	Address, Quantity uint16
+}
+
+func (m *WriteMultipleCoilsResponse) FunctionCode() uint8 {
+	return 15
+}
+
+func (m *WriteMultipleCoilsResponse) MarshalBinary() ([]byte, error) {
+	return marshalWords(15, m.Address, m.Quantity), nil
+}
+
+func (m *WriteMultipleCoilsResponse) UnmarshalBinary(pdu []byte) error {
+	w, err := unmarshalEcho(15, pdu, 4)
+	if err != nil {
+		return err
+	}
+	m.Address, m.Quantity = w[0], w[1]
+	return nil
+}
+
+/* Function Code 16 */
+
+type WriteMultipleRegistersRequest struct {
+	Address uint16
+	Values  []uint16
+}
+
+func (m *WriteMultipleRegistersRequest) FunctionCode() uint8 {
+	return 16
+}
+
+func (m *WriteMultipleRegistersRequest) MarshalBinary() ([]byte, error) {
+	if err := checkCount(16, len(m.Values), maxWriteRegisters); err != nil {
+		return nil, err
+	}
+	bin := marshalWords(16, m.Address, uint16(len(m.Values)))
+	return append(append(bin, uint8(len(m.Values)*2)), wordsToByteArray(m.Values...)...), nil
+}
+
+func (m *WriteMultipleRegistersRequest) UnmarshalBinary(pdu []byte) error {
+	data, err := requestData(16, pdu)
+	if err != nil {
+		return err
+	}
+	if len(data) < 5 {
+		return RequestError{16, fmt.Sprintf("invalid data length (%d bytes)", len(data))}
+	}
+	addr, qty, bc := binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:]), int(data[4])
+	if err := checkQuantity(16, qty, maxWriteRegisters); err != nil {
+		return err
+	}
+	if bc != int(qty)*2 || len(data) != 5+bc {
+		return RequestError{16, fmt.Sprintf("byte count %d does not match quantity %d", bc, qty)}
+	}
+	m.Address, m.Values = addr, bytesToWordArray(data[5:]...)
+	return nil
+}
+
+type WriteMultipleRegistersResponse struct {
+	Address, Quantity uint16
+}
+
+func (m *WriteMultipleRegistersResponse) FunctionCode() uint8 {
+	return 16
+}
+
+func (m *WriteMultipleRegistersResponse) MarshalBinary() ([]byte, error) {
+	return marshalWords(16, m.Address, m.Quantity), nil
+}
+
+func (m *WriteMultipleRegistersResponse) UnmarshalBinary(pdu []byte) error {
+	w, err := unmarshalEcho(16, pdu, 4)
+	if err != nil {
+		return err
+	}
+	m.Address, m.Quantity = w[0], w[1]
+	return nil
+}
+
+/* Function Code 17 */
+
+type ReportServerIdRequest struct{}
+
+func (m *ReportServerIdRequest) FunctionCode() uint8 {
+	return 17
+}
+
+func (m *ReportServerIdRequest) MarshalBinary() ([]byte, error) {
+	return []byte{17}, nil
+}
+
+func (m *ReportServerIdRequest) UnmarshalBinary(pdu []byte) error {
+	data, err := requestData(17, pdu)
+	if err != nil {
+		return err
+	}
+	return requestLength(17, data, 0)
+}
+
+// ReportServerIdResponse contains the device specific data
+// (server id, run indicator status and additional data).
+type ReportServerIdResponse struct {
+	Data []byte
+}
+
+func (m *ReportServerIdResponse) FunctionCode() uint8 {
+	return 17
+}
+
+func (m *ReportServerIdResponse) MarshalBinary() ([]byte, error) {
+	return marshalCounted(17, m.Data)
+}
+
+func (m *ReportServerIdResponse) UnmarshalBinary(pdu []byte) (err error) {
+	m.Data, err = unmarshalCounted(17, pdu)
+	return
+}
+
+/* Function Code 20 */
+
+// FileRecordRef addresses Length registers of a file starting at a record.
+type FileRecordRef struct {
+	File, Record, Length uint16
+}
+
+type ReadFileRecordRequest struct {
+	Records []FileRecordRef
+}
+
+func (m *ReadFileRecordRequest) FunctionCode() uint8 {
+	return 20
+}
+
+func (m *ReadFileRecordRequest) MarshalBinary() ([]byte, error) {
+	if len(m.Records) == 0 {
+		return nil, RequestError{20, "no records"}
+	}
+	var data []byte
+	for _, r := range m.Records {
+		data = append(append(data, fileReference), wordsToByteArray(r.File, r.Record, r.Length)...)
+	}
+	return marshalCounted(20, data)
+}
+
+func (m *ReadFileRecordRequest) UnmarshalBinary(pdu []byte) error {
+	data, err := unmarshalRequestCounted(20, pdu)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 || len(data)%7 != 0 {
+		return RequestError{20, fmt.Sprintf("invalid byte count %d", len(data))}
+	}
+	m.Records = nil
+	for ; len(data) > 0; data = data[7:] {
+		if data[0] != fileReference {
+			return RequestError{20, fmt.Sprintf("reference type %d instead of %d", data[0], fileReference)}
+		}
+		w := bytesToWordArray(data[1:7]...)
+		if w[1] > maxFileRecord {
+			return RequestError{20, fmt.Sprintf("record %d out of range 0-%d", w[1], maxFileRecord)}
+		}
+		m.Records = append(m.Records, FileRecordRef{w[0], w[1], w[2]})
+	}
+	return nil
+}
+
+// ReadFileRecordResponse contains the registers of every requested record.
+type ReadFileRecordResponse struct {
+	Records [][]uint16
+}
+
+func (m *ReadFileRecordResponse) FunctionCode() uint8 {
+	return 20
+}
+
+func (m *ReadFileRecordResponse) MarshalBinary() ([]byte, error) {
+	var data []byte
+	for _, values := range m.Records {
+		if len(values) > 127 {
+			return nil, fmt.Errorf("Too many registers (%d)", len(values))
+		}
+		data = append(append(data, uint8(len(values)*2+1), fileReference), wordsToByteArray(values...)...)
+	}
+	return marshalCounted(20, data)
+}
+
+func (m *ReadFileRecordResponse) UnmarshalBinary(pdu []byte) error {
+	data, err := unmarshalCounted(20, pdu)
+	if err != nil {
+		return err
+	}
+	m.Records = nil
+	for len(data) > 0 {
+		l := int(data[0])
+		if l%2 != 1 || len(data) < 1+l {
+			return ResponseError{20, fmt.Sprintf("invalid sub-response length %d", l)}
+		}
+		if data[1] != fileReference {
+			return ResponseError{20, fmt.Sprintf("reference type %d instead of %d", data[1], fileReference)}
+		}
+		m.Records = append(m.Records, bytesToWordArray(data[2:1+l]...))
+		data = data[1+l:]
+	}
+	return nil
+}
+
+/* Function Code 21 */
+
+// FileRecord contains registers of a file starting at a record.
+type FileRecord struct {
+	File, Record uint16
+	Values       []uint16
+}
+
+func marshalFileRecords(fn uint8, records []FileRecord) ([]byte, error) {
+	if len(records) == 0 {
+		return nil, RequestError{fn, "no records"}
+	}
+	var data []byte
+	for _, r := range records {
+		data = append(append(data, fileReference), wordsToByteArray(r.File, r.Record, uint16(len(r.Values)))...)
+		data = append(data, wordsToByteArray(r.Values...)...)
+	}
+	return marshalCounted(fn, data)
+}
+
+// unmarshalFileRecords parses the sub-requests of a write file record
+// request or its echo; invalid data is reported with fail.
+func unmarshalFileRecords(data []byte, fail func(string) error) ([]FileRecord, error) {
+	if len(data) == 0 {
+		return nil, fail("no records")
+	}
+	var records []FileRecord
+	for len(data) > 0 {
+		if len(data) < 7 {
+			return nil, fail(fmt.Sprintf("incomplete sub-request (%d bytes)", len(data)))
+		}
+		if data[0] != fileReference {
+			return nil, fail(fmt.Sprintf("reference type %d instead of %d", data[0], fileReference))
+		}
+		w := bytesToWordArray(data[1:7]...)
+		if w[1] > maxFileRecord {
+			return nil, fail(fmt.Sprintf("record %d out of range 0-%d", w[1], maxFileRecord))
+		}
+		n := 7 + int(w[2])*2
+		if len(data) < n {
+			return nil, fail(fmt.Sprintf("record length %d exceeds the data", w[2]))
+		}
+		records = append(records, FileRecord{w[0], w[1], bytesToWordArray(data[7:n]...)})
+		data = data[n:]
+	}
+	return records, nil
+}
+
+type WriteFileRecordRequest struct {
+	Records []FileRecord
+}
+
+func (m *WriteFileRecordRequest) FunctionCode() uint8 {
+	return 21
+}
+
+func (m *WriteFileRecordRequest) MarshalBinary() ([]byte, error) {
+	return marshalFileRecords(21, m.Records)
+}
+
+func (m *WriteFileRecordRequest) UnmarshalBinary(pdu []byte) (err error) {
+	data, err := unmarshalRequestCounted(21, pdu)
+	if err != nil {
+		return err
+	}
+	m.Records, err = unmarshalFileRecords(data, func(msg string) error { return RequestError{21, msg} })
+	return
+}
+
+// WriteFileRecordResponse is an echo of the request.
+type WriteFileRecordResponse struct {
+	Records []FileRecord
+}
+
+func (m *WriteFileRecordResponse) FunctionCode() uint8 {
+	return 21
+}
+
+func (m *WriteFileRecordResponse) MarshalBinary() ([]byte, error) {
+	return marshalFileRecords(21, m.Records)
+}
+
+func (m *WriteFileRecordResponse) UnmarshalBinary(pdu []byte) (err error) {
+	data, err := unmarshalCounted(21, pdu)
+	if err != nil {
+		return err
+	}
+	m.Records, err = unmarshalFileRecords(data, func(msg string) error { return ResponseError{21, msg} })
+	return
+}
+
+/* Function Code 22 */
+
+type MaskWriteRegisterRequest struct {
+	Address, AndMask, OrMask uint16
+}
+
+func (m *MaskWriteRegisterRequest) FunctionCode() uint8 {
+	return 22
+}
+
+func (m *MaskWriteRegisterRequest) MarshalBinary() ([]byte, error) {
+	return marshalWords(22, m.Address, m.AndMask, m.OrMask), nil
+}
+
+func (m *MaskWriteRegisterRequest) UnmarshalBinary(pdu []byte) error {
+	w, err := unmarshalRequestWords(22, pdu, 6)
+	if err != nil {
+		return err
+	}
+	m.Address, m.AndMask, m.OrMask = w[0], w[1], w[2]
+	return nil
+}
+
+type MaskWriteRegisterResponse struct {
+	Address, AndMask, OrMask uint16
+}
+
+func (m *MaskWriteRegisterResponse) FunctionCode() uint8 {
+	return 22
+}
+
+func (m *MaskWriteRegisterResponse) MarshalBinary() ([]byte, error) {
+	return marshalWords(22, m.Address, m.AndMask, m.OrMask), nil
+}
+
+func (m *MaskWriteRegisterResponse) UnmarshalBinary(pdu []byte) error {
+	w, err := unmarshalEcho(22, pdu, 6)
+	if err != nil {
+		return err
+	}
+	m.Address, m.AndMask, m.OrMask = w[0], w[1], w[2]
+	return nil
+}
+
+/* Function Code 23 */
+
+type ReadWriteMultipleRegistersRequest struct {
+	ReadAddress, ReadQuantity, WriteAddress uint16
+	Values                                  []uint16
+}
+
+func (m *ReadWriteMultipleRegistersRequest) FunctionCode() uint8 {
+	return 23
+}
+
+func (m *ReadWriteMultipleRegistersRequest) MarshalBinary() ([]byte, error) {
+	if err := checkCount(23, int(m.ReadQuantity), maxReadRegisters); err != nil {
+		return nil, err
+	}
+	if err := checkCount(23, len(m.Values), maxRwRegisters); err != nil {
+		return nil, err
+	}
+	bin := marshalWords(23, m.ReadAddress, m.ReadQuantity, m.WriteAddress, uint16(len(m.Values)))
+	return append(append(bin, uint8(len(m.Values)*2)), wordsToByteArray(m.Values...)...), nil
+}
+
+func (m *ReadWriteMultipleRegistersRequest) UnmarshalBinary(pdu []byte) error {
+	data, err := requestData(23, pdu)
+	if err != nil {
+		return err
+	}
+	if len(data) < 9 {
+		return RequestError{23, fmt.Sprintf("invalid data length (%d bytes)", len(data))}
+	}
+	w := bytesToWordArray(data[:8]...)
+	if err := checkQuantity(23, w[1], maxReadRegisters); err != nil {
+		return err
+	}
+	if err := checkQuantity(23, w[3], maxRwRegisters); err != nil {
+		return err
+	}
+	if bc := int(data[8]); bc != int(w[3])*2 || len(data) != 9+bc {
+		return RequestError{23, fmt.Sprintf("byte count %d does not match quantity %d", bc, w[3])}
+	}
+	m.ReadAddress, m.ReadQuantity, m.WriteAddress = w[0], w[1], w[2]
+	m.Values = bytesToWordArray(data[9:]...)
+	return nil
+}
+
+type ReadWriteMultipleRegistersResponse struct {
+	Values []uint16
+}
+
+func (m *ReadWriteMultipleRegistersResponse) FunctionCode() uint8 {
+	return 23
+}
+
+func (m *ReadWriteMultipleRegistersResponse) MarshalBinary() ([]byte, error) {
+	return marshalCounted(23, wordsToByteArray(m.Values...))
+}
+
+func (m *ReadWriteMultipleRegistersResponse) UnmarshalBinary(pdu []byte) (err error) {
+	m.Values, err = unmarshalRegisters(23, pdu)
+	return
+}
+
+/* Function Code 24 */
+
+type ReadFifoQueueRequest struct {
+	Address uint16
+}
+
+func (m *ReadFifoQueueRequest) FunctionCode() uint8 {
+	return 24
+}
+
+func (m *ReadFifoQueueRequest) MarshalBinary() ([]byte, error) {
+	return marshalWords(24, m.Address), nil
+}
+
+func (m *ReadFifoQueueRequest) UnmarshalBinary(pdu []byte) error {
+	w, err := unmarshalRequestWords(24, pdu, 2)
+	if err != nil {
+		return err
+	}
+	m.Address = w[0]
+	return nil
+}
+
+type ReadFifoQueueResponse struct {
+	Values []uint16
+}
+
+func (m *ReadFifoQueueResponse) FunctionCode() uint8 {
+	return 24
+}
+
+func (m *ReadFifoQueueResponse) MarshalBinary() ([]byte, error) {
+	n := len(m.Values)
+	if n > maxFifoCount {
+		return nil, fmt.Errorf("FIFO count %d exceeds %d", n, maxFifoCount)
+	}
+	return append(marshalWords(24, uint16(n*2+2), uint16(n)), wordsToByteArray(m.Values...)...), nil
+}
+
+func (m *ReadFifoQueueResponse) UnmarshalBinary(pdu []byte) error {
+	data, err := responseData(24, pdu)
+	if err != nil {
+		return err
+	}
+	if l := len(data); l < 4 {
+		return ResponseError{24, fmt.Sprintf("invalid data length (%d bytes)", l)}
+	}
+	n := int(binary.BigEndian.Uint16(data[2:]))
+	if n > maxFifoCount {
+		return ResponseError{24, fmt.Sprintf("FIFO count %d exceeds %d", n, maxFifoCount)}
+	}
+	if bc := int(binary.BigEndian.Uint16(data)); bc != n*2+2 {
+		return ResponseError{24, fmt.Sprintf("byte count %d instead of %d", bc, n*2+2)}
+	}
+	if err := responseLength(24, data, n*2+4); err != nil {
+		return err
+	}
+	m.Values = bytesToWordArray(data[4:]...)
+	return nil
+}
+
+/* Function Code 43 / MEI Type 14 */
+
+type ReadDeviceIdentificationRequest struct {
+	ReadCode, ObjectId uint8
+}
+
+func (m *ReadDeviceIdentificationRequest) FunctionCode() uint8 {
+	return 43
+}
+
+func (m *ReadDeviceIdentificationRequest) MarshalBinary() ([]byte, error) {
+	return []byte{43, meiReadDeviceId, m.ReadCode, m.ObjectId}, nil
+}
+
+func (m *ReadDeviceIdentificationRequest) UnmarshalBinary(pdu []byte) error {
+	data, err := requestData(43, pdu)
+	if err != nil {
+		return err
+	}
+	if err := requestLength(43, data, 3); err != nil {
+		return err
+	}
+	if data[0] != meiReadDeviceId {
+		return RequestError{43, fmt.Sprintf("MEI type %d instead of %d", data[0], meiReadDeviceId)}
+	}
+	if data[1] < DeviceIdBasic || data[1] > DeviceIdIndividual {
+		return RequestError{43, fmt.Sprintf("invalid read device id code %d", data[1])}
+	}
+	m.ReadCode, m.ObjectId = data[1], data[2]
+	return nil
+}
+
+type ReadDeviceIdentificationResponse struct {
+	ReadCode        uint8
+	ConformityLevel uint8
+	MoreFollows     bool
+	NextObjectId    uint8
+	Objects         map[uint8]string
+}
+
+func (m *ReadDeviceIdentificationResponse) FunctionCode() uint8 {
+	return 43
+}
+
+func (m *ReadDeviceIdentificationResponse) MarshalBinary() ([]byte, error) {
+	var more uint8
+	if m.MoreFollows {
+		more = 0xff
+	}
+	bin := []byte{43, meiReadDeviceId, m.ReadCode, m.ConformityLevel, more, m.NextObjectId, uint8(len(m.Objects))}
+	ids := make([]int, 0, len(m.Objects))
+	for id := range m.Objects {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		v := m.Objects[uint8(id)]
+		if len(v) > 255 {
+			return nil, fmt.Errorf("Object %d exceeds 255 bytes", id)
+		}
+		bin = append(append(bin, uint8(id), uint8(len(v))), v...)
+	}
+	if len(bin) > pduLength {
+		return nil, fmt.Errorf("Objects exceed the PDU length")
+	}
+	return bin, nil
+}
+
+func (m *ReadDeviceIdentificationResponse) UnmarshalBinary(pdu []byte) error {
+	data, err := responseData(43, pdu)
+	if err != nil {
+		return err
+	}
+	if l := len(data); l < 6 {
+		return ResponseError{43, fmt.Sprintf("invalid data length (%d bytes)", l)}
+	}
+	if data[0] != meiReadDeviceId {
+		return ResponseError{43, fmt.Sprintf("MEI type %d instead of %d", data[0], meiReadDeviceId)}
+	}
+	m.ReadCode, m.ConformityLevel, m.MoreFollows, m.NextObjectId = data[1], data[2], data[3] == 0xff, data[4]
+	m.Objects = map[uint8]string{}
+	count := int(data[5])
+	data = data[6:]
+	for o := 0; o < count; o++ {
+		if len(data) < 2 || len(data) < 2+int(data[1]) {
+			return ResponseError{43, fmt.Sprintf("object %d exceeds the response", o)}
+		}
+		m.Objects[data[0]] = string(data[2 : 2+int(data[1])])
+		data = data[2+int(data[1]):]
+	}
+	return nil
+}
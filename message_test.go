@@ -0,0 +1,153 @@
+package modbus
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func Test_Message(t *testing.T) {
+
+	Convey("Given messages of every function code", t, func() {
+		messages := []struct {
+			msg, empty Message
+			bin        []byte
+		}{
+			{&ReadCoilsRequest{0x13, 0x13}, &ReadCoilsRequest{}, []byte{1, 0x00, 0x13, 0x00, 0x13}},
+			{&ReadCoilsResponse{[]bool{true, false, true, true, false, false, true, true}}, &ReadCoilsResponse{}, []byte{1, 0x01, 0xcd}},
+			{&ReadDiscreteInputsRequest{0xc4, 0x16}, &ReadDiscreteInputsRequest{}, []byte{2, 0x00, 0xc4, 0x00, 0x16}},
+			{&ReadHoldingRegistersRequest{0x6b, 3}, &ReadHoldingRegistersRequest{}, []byte{3, 0x00, 0x6b, 0x00, 0x03}},
+			{&ReadHoldingRegistersResponse{[]uint16{555, 0, 100}}, &ReadHoldingRegistersResponse{}, []byte{3, 0x06, 0x02, 0x2b, 0x00, 0x00, 0x00, 0x64}},
+			{&ReadInputRegistersResponse{[]uint16{10}}, &ReadInputRegistersResponse{}, []byte{4, 0x02, 0x00, 0x0a}},
+			{&WriteSingleCoilRequest{0xac, true}, &WriteSingleCoilRequest{}, []byte{5, 0x00, 0xac, 0xff, 0x00}},
+			{&WriteSingleCoilResponse{0xac, false}, &WriteSingleCoilResponse{}, []byte{5, 0x00, 0xac, 0x00, 0x00}},
+			{&WriteSingleRegisterRequest{1, 3}, &WriteSingleRegisterRequest{}, []byte{6, 0x00, 0x01, 0x00, 0x03}},
+			{&ReadExceptionStatusResponse{0x6d}, &ReadExceptionStatusResponse{}, []byte{7, 0x6d}},
+			{&DiagnosticsRequest{0, []uint16{0xa537}}, &DiagnosticsRequest{}, []byte{8, 0x00, 0x00, 0xa5, 0x37}},
+			{&GetCommEventCounterResponse{0xffff, 0x0108}, &GetCommEventCounterResponse{}, []byte{11, 0xff, 0xff, 0x01, 0x08}},
+			{&GetCommEventLogResponse{0, 0x0108, 0x0121, []byte{0x20, 0x00}}, &GetCommEventLogResponse{},
+				[]byte{12, 0x08, 0x00, 0x00, 0x01, 0x08, 0x01, 0x21, 0x20, 0x00}},
+			{&WriteMultipleCoilsRequest{0x13, []bool{true, false, true, true, false, false, true, true, true, false}},
+				&WriteMultipleCoilsRequest{}, []byte{15, 0x00, 0x13, 0x00, 0x0a, 0x02, 0xcd, 0x01}},
+			{&WriteMultipleCoilsResponse{0x13, 0x0a}, &WriteMultipleCoilsResponse{}, []byte{15, 0x00, 0x13, 0x00, 0x0a}},
+			{&WriteMultipleRegistersRequest{1, []uint16{0x0a, 0x0102}}, &WriteMultipleRegistersRequest{},
+				[]byte{16, 0x00, 0x01, 0x00, 0x02, 0x04, 0x00, 0x0a, 0x01, 0x02}},
+			{&ReportServerIdResponse{[]byte{0x2a, 0xff}}, &ReportServerIdResponse{}, []byte{17, 0x02, 0x2a, 0xff}},
+			{&ReadFileRecordRequest{[]FileRecordRef{{4, 1, 2}, {3, 9, 2}}}, &ReadFileRecordRequest{},
+				[]byte{20, 0x0e, 0x06, 0x00, 0x04, 0x00, 0x01, 0x00, 0x02, 0x06, 0x00, 0x03, 0x00, 0x09, 0x00, 0x02}},
+			{&ReadFileRecordResponse{[][]uint16{{0x0dfe, 0x0020}, {0x33cd, 0x0040}}}, &ReadFileRecordResponse{},
+				[]byte{20, 0x0c, 0x05, 0x06, 0x0d, 0xfe, 0x00, 0x20, 0x05, 0x06, 0x33, 0xcd, 0x00, 0x40}},
+			{&WriteFileRecordRequest{[]FileRecord{{4, 7, []uint16{0x06af, 0x04be, 0x100d}}}}, &WriteFileRecordRequest{},
+				[]byte{21, 0x0d, 0x06, 0x00, 0x04, 0x00, 0x07, 0x00, 0x03, 0x06, 0xaf, 0x04, 0xbe, 0x10, 0x0d}},
+			{&WriteFileRecordResponse{[]FileRecord{{4, 7, []uint16{0x06af, 0x04be, 0x100d}}}}, &WriteFileRecordResponse{},
+				[]byte{21, 0x0d, 0x06, 0x00, 0x04, 0x00, 0x07, 0x00, 0x03, 0x06, 0xaf, 0x04, 0xbe, 0x10, 0x0d}},
+			{&MaskWriteRegisterRequest{4, 0xf2, 0x25}, &MaskWriteRegisterRequest{}, []byte{22, 0x00, 0x04, 0x00, 0xf2, 0x00, 0x25}},
+			{&ReadWriteMultipleRegistersRequest{3, 6, 14, []uint16{0xff, 0xff, 0xff}}, &ReadWriteMultipleRegistersRequest{},
+				[]byte{23, 0x00, 0x03, 0x00, 0x06, 0x00, 0x0e, 0x00, 0x03, 0x06, 0x00, 0xff, 0x00, 0xff, 0x00, 0xff}},
+			{&ReadFifoQueueRequest{0x04de}, &ReadFifoQueueRequest{}, []byte{24, 0x04, 0xde}},
+			{&ReadFifoQueueResponse{[]uint16{0x01b8, 0x1284}}, &ReadFifoQueueResponse{}, []byte{24, 0x00, 0x06, 0x00, 0x02, 0x01, 0xb8, 0x12, 0x84}},
+			{&ReadDeviceIdentificationRequest{DeviceIdBasic, 0}, &ReadDeviceIdentificationRequest{}, []byte{43, 0x0e, 0x01, 0x00}},
+			{&ReadDeviceIdentificationResponse{1, 1, false, 0, map[uint8]string{1: "b", 0: "a"}}, &ReadDeviceIdentificationResponse{},
+				[]byte{43, 0x0e, 0x01, 0x01, 0x00, 0x00, 0x02, 0x00, 0x01, 'a', 0x01, 0x01, 'b'}},
+			{&ExceptionResponse{3, ExceptionIllegalDataAddress}, &ExceptionResponse{}, []byte{0x83, 0x02}},
+		}
+
+		Convey("they should be marshaled", func() {
+			for _, m := range messages {
+				bin, err := m.msg.MarshalBinary()
+				So(err, ShouldBeNil)
+				So(bin, ShouldResemble, m.bin)
+				So(m.msg.FunctionCode(), ShouldEqual, m.bin[0])
+			}
+		})
+
+		Convey("they should be unmarshaled", func() {
+			for _, m := range messages {
+				So(m.empty.UnmarshalBinary(m.bin), ShouldBeNil)
+				So(m.empty, ShouldResemble, m.msg)
+			}
+		})
+	})
+
+	Convey("Given invalid requests", t, func() {
+
+		Convey("the quantity limits should be checked", func() {
+			So((&ReadCoilsRequest{}).UnmarshalBinary([]byte{1, 0, 0, 0x07, 0xd1}), ShouldHaveSameTypeAs, RequestError{})
+			So((&ReadHoldingRegistersRequest{}).UnmarshalBinary([]byte{3, 0, 0, 0, 0}), ShouldHaveSameTypeAs, RequestError{})
+			So((&ReadInputRegistersRequest{}).UnmarshalBinary([]byte{4, 0, 0, 0, 126}), ShouldHaveSameTypeAs, RequestError{})
+		})
+
+		Convey("the byte count should be checked", func() {
+			So((&WriteMultipleRegistersRequest{}).UnmarshalBinary([]byte{16, 0, 1, 0, 2, 0x02, 0, 1}), ShouldHaveSameTypeAs, RequestError{})
+			So((&WriteMultipleCoilsRequest{}).UnmarshalBinary([]byte{15, 0, 1, 0, 9, 0x01, 0xff}), ShouldHaveSameTypeAs, RequestError{})
+			So((&WriteFileRecordRequest{}).UnmarshalBinary([]byte{21, 0x09, 0x06, 0, 4, 0, 7, 0, 2, 0, 1}), ShouldHaveSameTypeAs, RequestError{})
+		})
+
+		Convey("the quantity limits should be checked when marshaling", func() {
+			_, err := (&WriteMultipleRegistersRequest{0, make([]uint16, maxWriteRegisters+1)}).MarshalBinary()
+			So(err, ShouldHaveSameTypeAs, RequestError{})
+			_, err = (&ReadWriteMultipleRegistersRequest{0, 1, 0, make([]uint16, maxRwRegisters+1)}).MarshalBinary()
+			So(err, ShouldHaveSameTypeAs, RequestError{})
+			_, err = (&WriteMultipleCoilsRequest{0, make([]bool, maxWriteBits+1)}).MarshalBinary()
+			So(err, ShouldHaveSameTypeAs, RequestError{})
+			_, err = (&ReadCoilsRequest{0, 0}).MarshalBinary()
+			So(err, ShouldHaveSameTypeAs, RequestError{})
+			_, err = (&ReadDiscreteInputsRequest{0, maxReadBits + 1}).MarshalBinary()
+			So(err, ShouldHaveSameTypeAs, RequestError{})
+			_, err = (&ReadHoldingRegistersRequest{0, maxReadRegisters + 1}).MarshalBinary()
+			So(err, ShouldHaveSameTypeAs, RequestError{})
+			_, err = (&ReadInputRegistersRequest{0, 0}).MarshalBinary()
+			So(err, ShouldHaveSameTypeAs, RequestError{})
+		})
+
+		Convey("reads beyond the address space should be rejected when marshaling", func() {
+			_, err := (&ReadHoldingRegistersRequest{0xfff0, 17}).MarshalBinary()
+			So(err, ShouldHaveSameTypeAs, RequestError{})
+			_, err = (&ReadCoilsRequest{0xffff, 2}).MarshalBinary()
+			So(err, ShouldHaveSameTypeAs, RequestError{})
+			_, err = (&ReadInputRegistersRequest{0xfff0, 16}).MarshalBinary()
+			So(err, ShouldBeNil)
+		})
+
+		Convey("file records beyond 9999 should be rejected", func() {
+			So((&ReadFileRecordRequest{}).UnmarshalBinary([]byte{20, 0x07, 0x06, 0, 4, 0x27, 0x10, 0, 2}), ShouldHaveSameTypeAs, RequestError{})
+		})
+
+		Convey("coil values other than 0xff00 and 0x0000 should be rejected", func() {
+			So((&WriteSingleCoilRequest{}).UnmarshalBinary([]byte{5, 0, 1, 0, 1}), ShouldHaveSameTypeAs, RequestError{})
+		})
+
+		Convey("a wrong function code should be rejected", func() {
+			So((&ReadCoilsRequest{}).UnmarshalBinary([]byte{2, 0, 0, 0, 1}), ShouldHaveSameTypeAs, RequestError{})
+		})
+	})
+
+	Convey("Given an exception response", t, func() {
+		bin := []byte{0x83, 0x02}
+
+		Convey("decoding it as response should return the exception", func() {
+			err := (&ReadHoldingRegistersResponse{}).UnmarshalBinary(bin)
+			So(err, ShouldResemble, Error{0x83, 0x02})
+		})
+	})
+
+	Convey("Given a PDU", t, func() {
+		pdu := &Pdu{}
+
+		Convey("it should be unmarshaled and marshaled", func() {
+			So(pdu.UnmarshalBinary([]byte{6, 0, 1, 0, 3}), ShouldBeNil)
+			So(pdu, ShouldResemble, &Pdu{6, []byte{0, 1, 0, 3}})
+			bin, err := pdu.MarshalBinary()
+			So(err, ShouldBeNil)
+			So(bin, ShouldResemble, []byte{6, 0, 1, 0, 3})
+		})
+
+		Convey("it should be converted from and into messages", func() {
+			pdu, err := NewPdu(&ReadExceptionStatusRequest{})
+			So(err, ShouldBeNil)
+			So(pdu, ShouldResemble, &Pdu{7, nil})
+			req := &WriteSingleRegisterRequest{}
+			So((&Pdu{6, []byte{0, 1, 0, 3}}).Decode(req), ShouldBeNil)
+			So(req, ShouldResemble, &WriteSingleRegisterRequest{1, 3})
+		})
+	})
+}
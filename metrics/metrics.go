@@ -0,0 +1,289 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Prometheus compatible metrics for Modbus clients and servers
+ */
+
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	modbus "github.com/flosse/go-modbus"
+)
+
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+const (
+	roleClient = "client"
+	roleServer = "server"
+)
+
+type Registry struct {
+	mu      sync.Mutex
+	buckets []float64
+	devices map[key]*device
+}
+
+type key struct {
+	role   string
+	device string
+}
+
+type exceptionKey struct {
+	function  uint8
+	exception uint8
+}
+
+type device struct {
+	requests   map[uint8]uint64
+	exceptions map[exceptionKey]uint64
+	errors     uint64
+	timeouts   uint64
+	connects   uint64
+	reconnects uint64
+
+	// histogram (non-cumulative bucket counts)
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// NewRegistry creates an empty registry. If no buckets are given
+// DefaultBuckets are used for the latency histograms.
+func NewRegistry(buckets ...float64) *Registry {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	b := append([]float64{}, buckets...)
+	sort.Float64s(b)
+	return &Registry{buckets: b, devices: map[key]*device{}}
+}
+
+func (r *Registry) device(k key) *device {
+	d, ok := r.devices[k]
+	if !ok {
+		d = &device{
+			requests:   map[uint8]uint64{},
+			exceptions: map[exceptionKey]uint64{},
+			buckets:    make([]uint64, len(r.buckets)),
+		}
+		r.devices[k] = d
+	}
+	return d
+}
+
+/* Recorder */
+
+type recorder struct {
+	r *Registry
+	k key
+}
+
+// Recorder returns a modbus.MetricsRecorder for the given role
+// ("client" or "server") and device name.
+func (r *Registry) Recorder(role, device string) modbus.MetricsRecorder {
+	return &recorder{r, key{role, device}}
+}
+
+func (rec *recorder) ObserveRequest(function uint8, duration time.Duration, err error) {
+	r := rec.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := r.device(rec.k)
+	d.requests[function]++
+
+	s := duration.Seconds()
+	d.sum += s
+	d.count++
+	for i, b := range r.buckets {
+		if s <= b {
+			d.buckets[i]++
+			break
+		}
+	}
+
+	if err == nil {
+		return
+	}
+	var e modbus.Error
+	if errors.As(err, &e) {
+		d.exceptions[exceptionKey{function, e.Exception}]++
+		return
+	}
+	d.errors++
+	if errors.As(err, &modbus.TimeoutError{}) {
+		d.timeouts++
+	}
+}
+
+func (rec *recorder) ObserveConnect(reconnect bool) {
+	r := rec.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := r.device(rec.k)
+	d.connects++
+	if reconnect {
+		d.reconnects++
+	}
+}
+
+/* Client */
+
+// Client returns a middleware that records the transactions of a client.
+// The connects of TCP and UDP transporters are counted as well.
+func (r *Registry) Client(device string) modbus.Middleware {
+	return modbus.Metrics(&recorder{r, key{roleClient, device}})
+}
+
+/* Server */
+
+type handler struct {
+	h   modbus.Handler
+	rec *recorder
+}
+
+func (h *handler) Handle(req *modbus.Pdu) *modbus.Pdu {
+	start := time.Now()
+	return h.observe(req, start, h.h.Handle(req))
+}
+
+// ServeModbus passes the request with its metadata to handlers that
+// support it, e.g. an AccessControl.
+func (h *handler) ServeModbus(r *modbus.Request) *modbus.Pdu {
+	start := time.Now()
+	if rh, ok := h.h.(modbus.RequestHandler); ok {
+		return h.observe(r.Pdu, start, rh.ServeModbus(r))
+	}
+	return h.observe(r.Pdu, start, h.h.Handle(r.Pdu))
+}
+
+func (h *handler) observe(req *modbus.Pdu, start time.Time, res *modbus.Pdu) *modbus.Pdu {
+	var err error
+	if res != nil && res.Function == req.Function|0x80 && len(res.Data) > 0 {
+		err = modbus.Error{Code: res.Function, Exception: res.Data[0]}
+	}
+	h.rec.ObserveRequest(req.Function, time.Since(start), err)
+	return res
+}
+
+// ServerHandler wraps a server handler to record the handled requests.
+// Requests are passed on with their metadata (unit, client and role).
+func (r *Registry) ServerHandler(device string, h modbus.Handler) modbus.Handler {
+	return &handler{h, &recorder{r, key{roleServer, device}}}
+}
+
+/* Exposition */
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := make([]key, 0, len(r.devices))
+	for k := range r.devices {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].role != keys[j].role {
+			return keys[i].role < keys[j].role
+		}
+		return keys[i].device < keys[j].device
+	})
+
+	var b strings.Builder
+
+	b.WriteString("# HELP modbus_requests_total Number of Modbus transactions.\n")
+	b.WriteString("# TYPE modbus_requests_total counter\n")
+	for _, k := range keys {
+		d := r.devices[k]
+		fns := make([]int, 0, len(d.requests))
+		for f := range d.requests {
+			fns = append(fns, int(f))
+		}
+		sort.Ints(fns)
+		for _, f := range fns {
+			fmt.Fprintf(&b, "modbus_requests_total{%s,function=\"%d\"} %d\n", labels(k), f, d.requests[uint8(f)])
+		}
+	}
+
+	b.WriteString("# HELP modbus_exceptions_total Number of exception responses.\n")
+	b.WriteString("# TYPE modbus_exceptions_total counter\n")
+	for _, k := range keys {
+		d := r.devices[k]
+		exs := make([]exceptionKey, 0, len(d.exceptions))
+		for e := range d.exceptions {
+			exs = append(exs, e)
+		}
+		sort.Slice(exs, func(i, j int) bool {
+			if exs[i].function != exs[j].function {
+				return exs[i].function < exs[j].function
+			}
+			return exs[i].exception < exs[j].exception
+		})
+		for _, e := range exs {
+			fmt.Fprintf(&b, "modbus_exceptions_total{%s,function=\"%d\",exception=\"%d\"} %d\n",
+				labels(k), e.function, e.exception, d.exceptions[e])
+		}
+	}
+
+	counters := []struct {
+		name, help string
+		value      func(d *device) uint64
+	}{
+		{"modbus_errors_total", "Number of failed transactions without exception response.", func(d *device) uint64 { return d.errors }},
+		{"modbus_timeouts_total", "Number of timed out transactions.", func(d *device) uint64 { return d.timeouts }},
+		{"modbus_connects_total", "Number of successful connects.", func(d *device) uint64 { return d.connects }},
+		{"modbus_reconnects_total", "Number of successful connects after the first one.", func(d *device) uint64 { return d.reconnects }},
+	}
+	for _, c := range counters {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+		for _, k := range keys {
+			fmt.Fprintf(&b, "%s{%s} %d\n", c.name, labels(k), c.value(r.devices[k]))
+		}
+	}
+
+	b.WriteString("# HELP modbus_request_duration_seconds Latency of Modbus transactions.\n")
+	b.WriteString("# TYPE modbus_request_duration_seconds histogram\n")
+	for _, k := range keys {
+		d := r.devices[k]
+		var cumulative uint64
+		for i, le := range r.buckets {
+			cumulative += d.buckets[i]
+			fmt.Fprintf(&b, "modbus_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels(k), formatFloat(le), cumulative)
+		}
+		fmt.Fprintf(&b, "modbus_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels(k), d.count)
+		fmt.Fprintf(&b, "modbus_request_duration_seconds_sum{%s} %s\n", labels(k), formatFloat(d.sum))
+		fmt.Fprintf(&b, "modbus_request_duration_seconds_count{%s} %d\n", labels(k), d.count)
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func labels(k key) string {
+	return fmt.Sprintf("role=\"%s\",device=\"%s\"", escape(k.role), escape(k.device))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,147 @@
+package metrics
+
+import (
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	modbus "github.com/flosse/go-modbus"
+)
+
+type dummyTransporter struct {
+	send func(pdu *modbus.Pdu) (*modbus.Pdu, error)
+}
+
+func (t *dummyTransporter) Connect() error {
+	return nil
+}
+
+func (t *dummyTransporter) Close() error {
+	return nil
+}
+
+func (t *dummyTransporter) Send(pdu *modbus.Pdu) (*modbus.Pdu, error) {
+	return t.send(pdu)
+}
+
+type dummyHandler struct{}
+
+func (h *dummyHandler) Handle(req *modbus.Pdu) *modbus.Pdu {
+	return &modbus.Pdu{Function: req.Function | 0x80, Data: []byte{modbus.ExceptionIllegalFunction}}
+}
+
+func scrape(r *Registry) string {
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	return rec.Body.String()
+}
+
+func Test_Metrics(t *testing.T) {
+
+	Convey("Given a registry with an instrumented client", t, func() {
+		r := NewRegistry(0.5, 1)
+		d := &dummyTransporter{send: func(pdu *modbus.Pdu) (*modbus.Pdu, error) {
+			return &modbus.Pdu{Function: pdu.Function, Data: []byte{0x02, 0x00, 0x01}}, nil
+		}}
+		c := modbus.NewClient(d, modbus.Retry(2, 0), r.Client("plc-1"))
+
+		Convey("requests should be counted per function", func() {
+			c.ReadHoldingRegisters(0, 1)
+			c.ReadHoldingRegisters(0, 1)
+			So(scrape(r), ShouldContainSubstring, `modbus_requests_total{role="client",device="plc-1",function="3"} 2`)
+		})
+
+		Convey("latencies should be observed", func() {
+			c.ReadHoldingRegisters(0, 1)
+			out := scrape(r)
+			So(out, ShouldContainSubstring, `modbus_request_duration_seconds_bucket{role="client",device="plc-1",le="0.5"} 1`)
+			So(out, ShouldContainSubstring, `modbus_request_duration_seconds_bucket{role="client",device="plc-1",le="+Inf"} 1`)
+			So(out, ShouldContainSubstring, `modbus_request_duration_seconds_count{role="client",device="plc-1"} 1`)
+		})
+
+		Convey("exceptions should be counted by code", func() {
+			d.send = func(pdu *modbus.Pdu) (*modbus.Pdu, error) {
+				return &modbus.Pdu{Function: pdu.Function | 0x80, Data: []byte{modbus.ExceptionIllegalDataAddress}}, nil
+			}
+			c.ReadHoldingRegisters(0, 1)
+			So(scrape(r), ShouldContainSubstring, `modbus_exceptions_total{role="client",device="plc-1",function="3",exception="2"} 1`)
+		})
+
+		Convey("timeouts should be counted", func() {
+			d.send = func(pdu *modbus.Pdu) (*modbus.Pdu, error) {
+				return nil, modbus.TimeoutError{Op: "read", Err: errors.New("timeout")}
+			}
+			c.ReadHoldingRegisters(0, 1)
+			out := scrape(r)
+			So(out, ShouldContainSubstring, `modbus_timeouts_total{role="client",device="plc-1"} 2`)
+			So(out, ShouldContainSubstring, `modbus_errors_total{role="client",device="plc-1"} 2`)
+			So(out, ShouldContainSubstring, `modbus_reconnects_total{role="client",device="plc-1"} 0`)
+		})
+	})
+
+	Convey("Given an instrumented TCP client", t, func() {
+		r := NewRegistry()
+
+		// the server drops every connection
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer l.Close()
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				conn.Close()
+			}
+		}()
+		port := l.Addr().(*net.TCPAddr).Port
+		c := modbus.NewTcpClientTimeout("127.0.0.1", uint(port), time.Second, modbus.Retry(3, 0), r.Client("plc-2"))
+
+		Convey("successful connects and reconnects should be counted", func() {
+			_, err := c.ReadHoldingRegisters(0, 1)
+			So(err, ShouldNotBeNil)
+			out := scrape(r)
+			So(out, ShouldContainSubstring, `modbus_connects_total{role="client",device="plc-2"} 3`)
+			So(out, ShouldContainSubstring, `modbus_reconnects_total{role="client",device="plc-2"} 2`)
+		})
+
+		Convey("failed connects should not be counted", func() {
+			l.Close()
+			c.ReadHoldingRegisters(0, 1)
+			So(scrape(r), ShouldContainSubstring, `modbus_connects_total{role="client",device="plc-2"} 0`)
+		})
+	})
+
+	Convey("Given a registry with an instrumented server handler", t, func() {
+		r := NewRegistry()
+		h := r.ServerHandler(`dev"1`, &dummyHandler{})
+
+		Convey("exception responses should be counted", func() {
+			h.Handle(&modbus.Pdu{Function: 43})
+			out := scrape(r)
+			So(out, ShouldContainSubstring, `modbus_exceptions_total{role="server",device="dev\"1",function="43",exception="1"} 1`)
+			So(strings.Count(out, "# TYPE"), ShouldEqual, 7)
+		})
+	})
+
+	Convey("Given an instrumented access control", t, func() {
+		r := NewRegistry()
+		acl := modbus.NewAccessControl(modbus.NewDataModel(0, 0, 0, 10))
+		acl.Allow(modbus.AccessRule{Roles: []string{"operator"}})
+		h := r.ServerHandler("plc", acl).(modbus.RequestHandler)
+		write := &modbus.Pdu{Function: 6, Data: []byte{0, 1, 0, 7}}
+
+		Convey("the request metadata should be passed on", func() {
+			res := h.ServeModbus(&modbus.Request{Role: "operator", Pdu: write})
+			So(res, ShouldResemble, write)
+			res = h.ServeModbus(&modbus.Request{Role: "guest", Pdu: write})
+			So(res.Function, ShouldEqual, 0x86)
+			So(scrape(r), ShouldContainSubstring, `modbus_requests_total{role="server",device="plc",function="6"} 2`)
+		})
+	})
+}
@@ -0,0 +1,268 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Transport middlewares
+ */
+
+package modbus
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Middleware func(Transporter) Transporter
+
+// Chain wraps the transporter with the given middlewares.
+// The first middleware is the outermost one.
+func Chain(t Transporter, mw ...Middleware) Transporter {
+	for i := len(mw) - 1; i >= 0; i-- {
+		t = mw[i](t)
+	}
+	return t
+}
+
+type sendTransporter struct {
+	Transporter
+	send func(next Transporter, pdu *Pdu) (*Pdu, error)
+}
+
+func (t *sendTransporter) Send(pdu *Pdu) (*Pdu, error) {
+	return t.send(t.Transporter, pdu)
+}
+
+// sendTo passes a request for another unit through the middleware.
+func (t *sendTransporter) sendTo(unit uint8, pdu *Pdu) (*Pdu, error) {
+	return t.send(unitSender{t.Transporter, unit}, pdu)
+}
+
+// WrapSend returns a middleware that replaces the Send method of
+// a transporter while Connect and Close are passed through.
+func WrapSend(fn func(next Transporter, pdu *Pdu) (*Pdu, error)) Middleware {
+	return func(next Transporter) Transporter {
+		return &sendTransporter{next, fn}
+	}
+}
+
+/* Logging */
+
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Logging writes one logfmt line per transaction to the logger.
+func Logging(l Logger) Middleware {
+	return WrapSend(func(next Transporter, req *Pdu) (*Pdu, error) {
+		start := time.Now()
+		res, err := next.Send(req)
+		d := time.Since(start)
+		if err != nil {
+			l.Printf("fn=%d name=%q req=\"% x\" duration=%s err=%q",
+				req.Function, FunctionName(req.Function), req.Data, d, err)
+			return res, err
+		}
+		if res == nil {
+			l.Printf("fn=%d name=%q req=\"% x\" duration=%s res=nil",
+				req.Function, FunctionName(req.Function), req.Data, d)
+			return res, err
+		}
+		l.Printf("fn=%d name=%q req=\"% x\" res_fn=%d res=\"% x\" duration=%s",
+			req.Function, FunctionName(req.Function), req.Data, res.Function, res.Data, d)
+		return res, err
+	})
+}
+
+/* Metrics */
+
+type MetricsRecorder interface {
+
+	// ObserveRequest is called after every transaction.
+	// An exception response is reported as Error.
+	ObserveRequest(function uint8, duration time.Duration, err error)
+}
+
+// ConnectRecorder can be implemented by a MetricsRecorder to count the
+// successful connects of TCP and UDP transporters, including the implicit
+// ones of Send.
+type ConnectRecorder interface {
+	ObserveConnect(reconnect bool)
+}
+
+// connectObserver is implemented by transporters that report connects.
+type connectObserver interface {
+	observeConnects(r ConnectRecorder)
+}
+
+// base returns the transporter below the middlewares.
+func base(t Transporter) Transporter {
+	for {
+		switch s := t.(type) {
+		case *sendTransporter:
+			t = s.Transporter
+		case unitSender:
+			t = s.Transporter
+		default:
+			return t
+		}
+	}
+}
+
+// Metrics reports the latency and the result of every transaction.
+func Metrics(r MetricsRecorder) Middleware {
+	send := WrapSend(func(next Transporter, req *Pdu) (*Pdu, error) {
+		start := time.Now()
+		res, err := next.Send(req)
+		if err == nil && res != nil && res.Function == req.Function|0x80 && len(res.Data) > 0 {
+			r.ObserveRequest(req.Function, time.Since(start), Error{res.Function, res.Data[0]})
+		} else {
+			r.ObserveRequest(req.Function, time.Since(start), err)
+		}
+		return res, err
+	})
+	return func(next Transporter) Transporter {
+		if c, ok := r.(ConnectRecorder); ok {
+			if t, ok := base(next).(connectObserver); ok {
+				t.observeConnects(c)
+			}
+		}
+		return send(next)
+	}
+}
+
+// Stats is a simple MetricsRecorder that counts transactions.
+type Stats struct {
+	requests   uint64
+	errors     uint64
+	exceptions uint64
+	latency    int64
+}
+
+func (s *Stats) ObserveRequest(function uint8, d time.Duration, err error) {
+	atomic.AddUint64(&s.requests, 1)
+	atomic.AddInt64(&s.latency, int64(d))
+	if err == nil {
+		return
+	}
+	if _, ok := err.(Error); ok {
+		atomic.AddUint64(&s.exceptions, 1)
+	} else {
+		atomic.AddUint64(&s.errors, 1)
+	}
+}
+
+func (s *Stats) Requests() uint64 {
+	return atomic.LoadUint64(&s.requests)
+}
+
+func (s *Stats) Errors() uint64 {
+	return atomic.LoadUint64(&s.errors)
+}
+
+func (s *Stats) Exceptions() uint64 {
+	return atomic.LoadUint64(&s.exceptions)
+}
+
+func (s *Stats) AverageLatency() time.Duration {
+	n := atomic.LoadUint64(&s.requests)
+	if n == 0 {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&s.latency) / int64(n))
+}
+
+/* Retries */
+
+// Retryable reports whether a request that failed with err may succeed
+// when it is sent again.
+func Retryable(err error) bool {
+	var e Error
+	if errors.As(err, &e) {
+		return e.Exception == ExceptionServerDeviceBusy ||
+			e.Exception == ExceptionGatewayTargetDeviceFailedToRespond
+	}
+	return errors.As(err, &TimeoutError{}) || errors.As(err, &TransportError{}) ||
+		errors.As(err, &FramingError{}) || errors.As(err, &CRCError{})
+}
+
+// Retry sends a read request up to attempts times as long as it fails
+// with a retryable error. The connection is closed after transport
+// failures so the next attempt reconnects. Write requests are sent once
+// since a write that timed out may have been executed; use RetryWrites
+// to retry them as well.
+func Retry(attempts int, delay time.Duration) Middleware {
+	return retry(attempts, delay, false)
+}
+
+// RetryWrites is like Retry but also resends write requests.
+func RetryWrites(attempts int, delay time.Duration) Middleware {
+	return retry(attempts, delay, true)
+}
+
+func retry(attempts int, delay time.Duration, writes bool) Middleware {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return WrapSend(func(next Transporter, req *Pdu) (res *Pdu, err error) {
+		n := attempts
+		if !writes && !readFunctions[req.Function] {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			if i > 0 && delay > 0 {
+				time.Sleep(delay)
+			}
+			res, err = next.Send(req)
+			if err == nil {
+				if res != nil && res.Function == req.Function|0x80 && len(res.Data) > 0 &&
+					Retryable(Error{res.Function, res.Data[0]}) {
+					continue
+				}
+				return
+			}
+			if !Retryable(err) {
+				return
+			}
+			if errors.As(err, &TransportError{}) || errors.As(err, &TimeoutError{}) {
+				next.Close()
+			}
+		}
+		return
+	})
+}
+
+/* Rate limiting */
+
+// RateLimit delays requests so that at least interval passes between the
+// start of two transactions.
+func RateLimit(interval time.Duration) Middleware {
+	var mu sync.Mutex
+	var last time.Time
+	return WrapSend(func(next Transporter, req *Pdu) (*Pdu, error) {
+		mu.Lock()
+		if wait := interval - time.Since(last); wait > 0 {
+			time.Sleep(wait)
+		}
+		last = time.Now()
+		mu.Unlock()
+		return next.Send(req)
+	})
+}
+
+/* Tracing */
+
+// TraceFunc is called before a request is sent. The returned function
+// is called with the result, e.g. to end a span.
+type TraceFunc func(req *Pdu) func(res *Pdu, err error)
+
+func Tracing(fn TraceFunc) Middleware {
+	return WrapSend(func(next Transporter, req *Pdu) (*Pdu, error) {
+		end := fn(req)
+		res, err := next.Send(req)
+		if end != nil {
+			end(res, err)
+		}
+		return res, err
+	})
+}
@@ -0,0 +1,151 @@
+package modbus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"log"
+	"testing"
+	"time"
+)
+
+func Test_Middleware(t *testing.T) {
+
+	Convey("Given a chain of middlewares", t, func() {
+		var calls []string
+		mark := func(name string) Middleware {
+			return WrapSend(func(next Transporter, pdu *Pdu) (*Pdu, error) {
+				calls = append(calls, name)
+				return next.Send(pdu)
+			})
+		}
+		c := NewClient(&dummyTransporter{resData: []byte{0x02, 0x00, 0x07}}, mark("a"), mark("b"))
+
+		Convey("the first middleware should be called first", func() {
+			v, err := c.ReadHoldingRegisters(0, 1)
+			So(err, ShouldBeNil)
+			So(v, ShouldResemble, []uint16{7})
+			So(calls, ShouldResemble, []string{"a", "b"})
+		})
+	})
+
+	Convey("Given a logging middleware", t, func() {
+		var buf bytes.Buffer
+		c := NewClient(&dummyTransporter{resData: []byte{0x02, 0x00, 0x07}}, Logging(log.New(&buf, "", 0)))
+
+		Convey("the transaction should be logged", func() {
+			c.ReadHoldingRegisters(0x10, 1)
+			So(buf.String(), ShouldContainSubstring, `name="Read Holding Registers"`)
+			So(buf.String(), ShouldContainSubstring, `req="00 10 00 01"`)
+			So(buf.String(), ShouldContainSubstring, `res="02 00 07"`)
+		})
+	})
+
+	Convey("Given a metrics middleware", t, func() {
+		stats := &Stats{}
+		d := &dummyTransporter{}
+		c := NewClient(d, Metrics(stats))
+
+		Convey("exceptions and errors should be counted", func() {
+			d.send = func(pdu *Pdu) (*Pdu, error) {
+				return &Pdu{pdu.Function | 0x80, []byte{ExceptionIllegalDataAddress}}, nil
+			}
+			c.ReadHoldingRegisters(0, 1)
+			d.send = func(pdu *Pdu) (*Pdu, error) {
+				return &Pdu{}, TimeoutError{"read", errors.New("timeout")}
+			}
+			c.ReadHoldingRegisters(0, 1)
+			So(stats.Requests(), ShouldEqual, 2)
+			So(stats.Exceptions(), ShouldEqual, 1)
+			So(stats.Errors(), ShouldEqual, 1)
+		})
+	})
+
+	Convey("Given a retry middleware", t, func() {
+		attempts := 0
+		d := &dummyTransporter{}
+		c := NewClient(d, Retry(3, 0))
+
+		Convey("retryable errors should be retried", func() {
+			d.send = func(pdu *Pdu) (*Pdu, error) {
+				attempts++
+				if attempts < 3 {
+					return &Pdu{}, TimeoutError{"read", errors.New("timeout")}
+				}
+				return &Pdu{pdu.Function, []byte{0x02, 0x00, 0x01}}, nil
+			}
+			_, err := c.ReadHoldingRegisters(0, 1)
+			So(err, ShouldBeNil)
+			So(attempts, ShouldEqual, 3)
+		})
+
+		Convey("exceptions should not be retried", func() {
+			d.send = func(pdu *Pdu) (*Pdu, error) {
+				attempts++
+				return &Pdu{pdu.Function | 0x80, []byte{ExceptionIllegalDataAddress}}, nil
+			}
+			_, err := c.ReadHoldingRegisters(0, 1)
+			So(errors.Is(err, ErrIllegalDataAddress), ShouldBeTrue)
+			So(attempts, ShouldEqual, 1)
+		})
+
+		Convey("writes should only be retried with RetryWrites", func() {
+			d.send = func(pdu *Pdu) (*Pdu, error) {
+				attempts++
+				return nil, TimeoutError{"read", errors.New("timeout")}
+			}
+			c.WriteSingleRegister(0, 1)
+			So(attempts, ShouldEqual, 1)
+			c = NewClient(d, RetryWrites(3, 0))
+			c.WriteSingleRegister(0, 1)
+			So(attempts, ShouldEqual, 4)
+		})
+
+		Convey("no attempts should send the request once", func() {
+			d.send = func(pdu *Pdu) (*Pdu, error) {
+				attempts++
+				return &Pdu{pdu.Function, []byte{0x02, 0x00, 0x01}}, nil
+			}
+			c = NewClient(d, Retry(0, 0))
+			v, err := c.ReadHoldingRegisters(0, 1)
+			So(err, ShouldBeNil)
+			So(v, ShouldResemble, []uint16{1})
+			So(attempts, ShouldEqual, 1)
+		})
+
+		Convey("wrapped errors should be classified", func() {
+			So(Retryable(fmt.Errorf("poll: %w", CRCError{})), ShouldBeTrue)
+			So(Retryable(fmt.Errorf("poll: %w", ErrServerDeviceBusy)), ShouldBeTrue)
+			So(Retryable(fmt.Errorf("poll: %w", ErrIllegalFunction)), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given a rate limiting middleware", t, func() {
+		c := NewClient(&dummyTransporter{resData: []byte{0x02, 0x00, 0x07}}, RateLimit(20*time.Millisecond))
+
+		Convey("requests should be delayed", func() {
+			start := time.Now()
+			c.ReadHoldingRegisters(0, 1)
+			c.ReadHoldingRegisters(0, 1)
+			c.ReadHoldingRegisters(0, 1)
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 40*time.Millisecond)
+		})
+	})
+
+	Convey("Given a tracing middleware", t, func() {
+		var started, ended uint8
+		c := NewClient(&dummyTransporter{resData: []byte{0x02, 0x00, 0x07}}, Tracing(func(req *Pdu) func(*Pdu, error) {
+			started = req.Function
+			return func(res *Pdu, err error) {
+				ended = res.Function
+			}
+		}))
+
+		Convey("the span should be started and ended", func() {
+			c.ReadHoldingRegisters(0, 1)
+			So(started, ShouldEqual, 3)
+			So(ended, ShouldEqual, 3)
+		})
+	})
+}
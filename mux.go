@@ -0,0 +1,304 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Request multiplexer for servers
+ */
+
+package modbus
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// BitHandler serves a range of coils or discrete inputs.
+// The addresses are absolute.
+type BitHandler interface {
+	ReadBits(addr, count uint16) ([]bool, error)
+	WriteBits(addr uint16, values []bool) error
+}
+
+// RegisterHandler serves a range of input or holding registers.
+// The addresses are absolute.
+type RegisterHandler interface {
+	ReadRegisters(addr, count uint16) ([]uint16, error)
+	WriteRegisters(addr uint16, values []uint16) error
+}
+
+// BitFuncs adapts functions to a BitHandler. Writes are rejected with
+// ILLEGAL FUNCTION if Write is nil.
+type BitFuncs struct {
+	Read  func(addr, count uint16) ([]bool, error)
+	Write func(addr uint16, values []bool) error
+}
+
+func (f BitFuncs) ReadBits(addr, count uint16) ([]bool, error) {
+	if f.Read == nil {
+		return nil, ErrIllegalFunction
+	}
+	return f.Read(addr, count)
+}
+
+func (f BitFuncs) WriteBits(addr uint16, values []bool) error {
+	if f.Write == nil {
+		return ErrIllegalFunction
+	}
+	return f.Write(addr, values)
+}
+
+// RegisterFuncs adapts functions to a RegisterHandler. Writes are
+// rejected with ILLEGAL FUNCTION if Write is nil.
+type RegisterFuncs struct {
+	Read  func(addr, count uint16) ([]uint16, error)
+	Write func(addr uint16, values []uint16) error
+}
+
+func (f RegisterFuncs) ReadRegisters(addr, count uint16) ([]uint16, error) {
+	if f.Read == nil {
+		return nil, ErrIllegalFunction
+	}
+	return f.Read(addr, count)
+}
+
+func (f RegisterFuncs) WriteRegisters(addr uint16, values []uint16) error {
+	if f.Write == nil {
+		return ErrIllegalFunction
+	}
+	return f.Write(addr, values)
+}
+
+type mapping struct {
+	AddressRange
+	bits BitHandler
+	regs RegisterHandler
+}
+
+// segment is the part of a request that is served by one mapping.
+type segment struct {
+	*mapping
+	addr   uint16
+	offset int
+	count  int
+}
+
+// ServeMux routes requests by function code and address range.
+// Requests of functions without any handler are answered with ILLEGAL
+// FUNCTION, requests of unmapped addresses with ILLEGAL DATA ADDRESS.
+// Requests spanning several ranges are split up. Writes spanning several
+// ranges are only passed on if all of them are mapped and writable.
+type ServeMux struct {
+	mu        sync.RWMutex
+	functions map[uint8]Handler
+	tables    map[Table][]*mapping
+}
+
+func NewServeMux() *ServeMux {
+	return &ServeMux{functions: map[uint8]Handler{}, tables: map[Table][]*mapping{}}
+}
+
+// HandleFunction registers a handler for all requests of a function code.
+// It takes precedence over the address ranges.
+func (m *ServeMux) HandleFunction(function uint8, h Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.functions[function] = h
+}
+
+// HandleBits registers a handler for the coils or discrete inputs
+// between start and end (inclusive).
+func (m *ServeMux) HandleBits(t Table, start, end uint16, h BitHandler) {
+	if t != TableCoils && t != TableDiscreteInputs {
+		panic(fmt.Sprintf("modbus: %s are no bits", t))
+	}
+	m.add(t, &mapping{AddressRange{start, end}, h, nil})
+}
+
+// HandleRegisters registers a handler for the input or holding registers
+// between start and end (inclusive).
+func (m *ServeMux) HandleRegisters(t Table, start, end uint16, h RegisterHandler) {
+	if t != TableInputRegisters && t != TableHoldingRegisters {
+		panic(fmt.Sprintf("modbus: %s are no registers", t))
+	}
+	m.add(t, &mapping{AddressRange{start, end}, nil, h})
+}
+
+func (m *ServeMux) add(t Table, mp *mapping) {
+	if mp.Start > mp.End {
+		panic(fmt.Sprintf("modbus: invalid address range %s", mp.AddressRange))
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, other := range m.tables[t] {
+		if mp.Start <= other.End && other.Start <= mp.End {
+			panic(fmt.Sprintf("modbus: %s %s overlap with %s", t, mp.AddressRange, other.AddressRange))
+		}
+	}
+	mappings := append(m.tables[t], mp)
+	sort.Slice(mappings, func(i, j int) bool {
+		return mappings[i].Start < mappings[j].Start
+	})
+	m.tables[t] = mappings
+}
+
+// route splits the addresses into the segments of the mappings.
+func (m *ServeMux) route(t Table, addr uint16, count int) ([]segment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	mappings := m.tables[t]
+	if len(mappings) == 0 {
+		return nil, ErrIllegalFunction
+	}
+	var segments []segment
+	last := int(addr) + count - 1
+	for cur := int(addr); cur <= last; {
+		i := sort.Search(len(mappings), func(i int) bool {
+			return int(mappings[i].End) >= cur
+		})
+		if i == len(mappings) || int(mappings[i].Start) > cur {
+			return nil, ErrIllegalDataAddress
+		}
+		end := int(mappings[i].End)
+		if end > last {
+			end = last
+		}
+		segments = append(segments, segment{mappings[i], uint16(cur), cur - int(addr), end - cur + 1})
+		cur = end + 1
+	}
+	return segments, nil
+}
+
+// writable rejects a write with ILLEGAL FUNCTION if any of its segments is
+// served by functions without Write, before a single value is written.
+func writable(segments []segment) error {
+	for _, s := range segments {
+		readOnly := false
+		switch h := s.bits.(type) {
+		case BitFuncs:
+			readOnly = h.Write == nil
+		case *BitFuncs:
+			readOnly = h.Write == nil
+		}
+		switch h := s.regs.(type) {
+		case RegisterFuncs:
+			readOnly = h.Write == nil
+		case *RegisterFuncs:
+			readOnly = h.Write == nil
+		}
+		if readOnly {
+			return ErrIllegalFunction
+		}
+	}
+	return nil
+}
+
+func (m *ServeMux) readBits(t Table, addr, count uint16) ([]bool, error) {
+	segments, err := m.route(t, addr, int(count))
+	if err != nil {
+		return nil, err
+	}
+	values := make([]bool, 0, count)
+	for _, s := range segments {
+		v, err := s.bits.ReadBits(s.addr, uint16(s.count))
+		if err != nil {
+			return nil, err
+		}
+		if len(v) != s.count {
+			return nil, ErrServerDeviceFailure
+		}
+		values = append(values, v...)
+	}
+	return values, nil
+}
+
+func (m *ServeMux) readRegisters(t Table, addr, count uint16) ([]uint16, error) {
+	segments, err := m.route(t, addr, int(count))
+	if err != nil {
+		return nil, err
+	}
+	values := make([]uint16, 0, count)
+	for _, s := range segments {
+		v, err := s.regs.ReadRegisters(s.addr, uint16(s.count))
+		if err != nil {
+			return nil, err
+		}
+		if len(v) != s.count {
+			return nil, ErrServerDeviceFailure
+		}
+		values = append(values, v...)
+	}
+	return values, nil
+}
+
+func (m *ServeMux) ReadCoils(addr, count uint16) ([]bool, error) {
+	return m.readBits(TableCoils, addr, count)
+}
+
+func (m *ServeMux) WriteCoils(addr uint16, values []bool) error {
+	segments, err := m.route(TableCoils, addr, len(values))
+	if err != nil {
+		return err
+	}
+	if err := writable(segments); err != nil {
+		return err
+	}
+	for _, s := range segments {
+		if err := s.bits.WriteBits(s.addr, values[s.offset:s.offset+s.count]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *ServeMux) ReadDiscreteInputs(addr, count uint16) ([]bool, error) {
+	return m.readBits(TableDiscreteInputs, addr, count)
+}
+
+func (m *ServeMux) ReadInputRegisters(addr, count uint16) ([]uint16, error) {
+	return m.readRegisters(TableInputRegisters, addr, count)
+}
+
+func (m *ServeMux) ReadHoldingRegisters(addr, count uint16) ([]uint16, error) {
+	return m.readRegisters(TableHoldingRegisters, addr, count)
+}
+
+func (m *ServeMux) WriteHoldingRegisters(addr uint16, values []uint16) error {
+	segments, err := m.route(TableHoldingRegisters, addr, len(values))
+	if err != nil {
+		return err
+	}
+	if err := writable(segments); err != nil {
+		return err
+	}
+	for _, s := range segments {
+		if err := s.regs.WriteRegisters(s.addr, values[s.offset:s.offset+s.count]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *ServeMux) maskWriteHoldingRegister(addr, and, or uint16) error {
+	v, err := m.ReadHoldingRegisters(addr, 1)
+	if err != nil {
+		return err
+	}
+	return m.WriteHoldingRegisters(addr, []uint16{(v[0] & and) | (or &^ and)})
+}
+
+// Handle passes the request to the handler of its function code or
+// answers it from the handlers of the addressed ranges.
+func (m *ServeMux) Handle(req *Pdu) *Pdu {
+	return m.ServeModbus(&Request{Pdu: req})
+}
+
+func (m *ServeMux) ServeModbus(r *Request) *Pdu {
+	m.mu.RLock()
+	h, ok := m.functions[r.Pdu.Function]
+	m.mu.RUnlock()
+	if ok {
+		return serveRequest(h, r)
+	}
+	res, err := handleTables(m, r.Pdu)
+	return respond(r.Pdu.Function, res, err)
+}
@@ -0,0 +1,115 @@
+package modbus
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func Test_ServeMux(t *testing.T) {
+
+	Convey("Given a mux with a callback and a data model", t, func() {
+		model := NewDataModel(0, 0, 0, 300)
+		var written []uint16
+		mux := NewServeMux()
+		mux.HandleRegisters(TableHoldingRegisters, 100, 199, RegisterFuncs{
+			Read: func(addr, count uint16) ([]uint16, error) {
+				values := make([]uint16, count)
+				for i := range values {
+					values[i] = addr + uint16(i)
+				}
+				return values, nil
+			},
+			Write: func(addr uint16, values []uint16) error {
+				written = append(written, addr)
+				return nil
+			},
+		})
+		mux.HandleRegisters(TableHoldingRegisters, 200, 299, RegisterFuncs{model.ReadHoldingRegisters, model.WriteHoldingRegisters})
+		mux.HandleBits(TableDiscreteInputs, 0, 7, BitFuncs{Read: func(addr, count uint16) ([]bool, error) {
+			return make([]bool, count), nil
+		}})
+		c := NewClient(&handlerTransporter{mux}).(SerialClient)
+
+		Convey("requests should be passed to the handler of the range", func() {
+			values, err := c.ReadHoldingRegisters(150, 2)
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []uint16{150, 151})
+			So(c.WriteSingleRegister(250, 9), ShouldBeNil)
+			values, _ = model.ReadHoldingRegisters(250, 1)
+			So(values, ShouldResemble, []uint16{9})
+		})
+
+		Convey("requests spanning several ranges should be split", func() {
+			So(c.WriteMultipleRegisters(198, []uint16{1, 2, 3, 4}), ShouldBeNil)
+			So(written, ShouldResemble, []uint16{198})
+			values, _ := model.ReadHoldingRegisters(200, 2)
+			So(values, ShouldResemble, []uint16{3, 4})
+			values, err := c.ReadHoldingRegisters(199, 2)
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []uint16{199, 3})
+		})
+
+		Convey("unmapped addresses should be answered with ILLEGAL DATA ADDRESS", func() {
+			_, err := c.ReadHoldingRegisters(90, 20)
+			So(err, ShouldResemble, Error{0x83, ExceptionIllegalDataAddress})
+			_, err = c.ReadHoldingRegisters(299, 2)
+			So(err, ShouldResemble, Error{0x83, ExceptionIllegalDataAddress})
+		})
+
+		Convey("unmapped tables should be answered with ILLEGAL FUNCTION", func() {
+			_, err := c.ReadCoils(0, 1)
+			So(err, ShouldResemble, Error{0x81, ExceptionIllegalFunction})
+			_, err = c.ReadInputRegisters(0, 1)
+			So(err, ShouldResemble, Error{0x84, ExceptionIllegalFunction})
+		})
+
+		Convey("read-only ranges should reject writes", func() {
+			mux.HandleBits(TableCoils, 0, 7, BitFuncs{Read: func(addr, count uint16) ([]bool, error) {
+				return make([]bool, count), nil
+			}})
+			So(c.WriteSingleCoil(1, true), ShouldResemble, Error{0x85, ExceptionIllegalFunction})
+		})
+
+		Convey("writes spanning a read-only range should not be applied partially", func() {
+			mux.HandleRegisters(TableHoldingRegisters, 300, 309, RegisterFuncs{Read: func(addr, count uint16) ([]uint16, error) {
+				return make([]uint16, count), nil
+			}})
+			So(c.WriteMultipleRegisters(298, []uint16{1, 2, 3, 4}), ShouldResemble, Error{0x90, ExceptionIllegalFunction})
+			values, _ := model.ReadHoldingRegisters(298, 2)
+			So(values, ShouldResemble, []uint16{0, 0})
+			So(c.WriteMultipleRegisters(196, []uint16{1, 2, 3, 4, 5}), ShouldBeNil)
+			So(written, ShouldResemble, []uint16{196})
+
+			var coils []uint16
+			mux.HandleBits(TableCoils, 0, 7, BitFuncs{Write: func(addr uint16, values []bool) error {
+				coils = append(coils, addr)
+				return nil
+			}})
+			mux.HandleBits(TableCoils, 8, 15, &BitFuncs{})
+			So(c.WriteMultipleCoils(6, []bool{true, true, true}), ShouldResemble, Error{0x8f, ExceptionIllegalFunction})
+			So(coils, ShouldBeEmpty)
+		})
+
+		Convey("mask writes should be applied by read and write", func() {
+			model.WriteHoldingRegisters(204, []uint16{0x12})
+			So(c.MaskWriteRegister(204, 0xf2, 0x25), ShouldBeNil)
+			values, _ := model.ReadHoldingRegisters(204, 1)
+			So(values, ShouldResemble, []uint16{0x17})
+		})
+
+		Convey("function handlers should take precedence", func() {
+			mux.HandleFunction(3, model)
+			values, err := c.ReadHoldingRegisters(150, 1)
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []uint16{0})
+			_, err = c.ReadExceptionStatus()
+			So(err, ShouldResemble, Error{0x87, ExceptionIllegalFunction})
+		})
+
+		Convey("overlapping ranges should be rejected", func() {
+			So(func() { mux.HandleRegisters(TableHoldingRegisters, 50, 100, RegisterFuncs{}) }, ShouldPanic)
+			So(func() { mux.HandleRegisters(TableCoils, 0, 1, RegisterFuncs{}) }, ShouldPanic)
+			So(func() { mux.HandleBits(TableCoils, 2, 1, BitFuncs{}) }, ShouldPanic)
+		})
+	})
+}
@@ -0,0 +1,125 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Change notifications of data models
+ */
+
+package modbus
+
+import (
+	"net"
+)
+
+// WriteEvent describes a write to the watched coils or holding registers.
+// The values are limited to the watched range.
+type WriteEvent struct {
+	Table   Table
+	Address uint16
+
+	OldBits, NewBits           []bool
+	OldRegisters, NewRegisters []uint16
+
+	// function code and client of the request, zero for writes of the
+	// application
+	Function   uint8
+	Unit       uint8
+	RemoteAddr net.Addr
+}
+
+type watcher struct {
+	table Table
+	AddressRange
+	veto func(e *WriteEvent) error
+	ch   chan<- *WriteEvent
+}
+
+type watcherEvent struct {
+	w *watcher
+	e *WriteEvent
+}
+
+// OnWrite calls fn before a write to the coils or holding registers between
+// start and end (inclusive) is committed. If fn returns an error (e.g.
+// ErrIllegalDataValue) the whole write is rejected with it. fn is called
+// while the data model is locked and must not access it.
+// The returned function removes the callback.
+func (m *DataModel) OnWrite(t Table, start, end uint16, fn func(e *WriteEvent) error) (cancel func()) {
+	return m.watch(&watcher{table: t, AddressRange: AddressRange{start, end}, veto: fn})
+}
+
+// Subscribe sends every committed write to the coils or holding registers
+// between start and end (inclusive) to ch. Events are dropped if ch is
+// not ready. The returned function ends the subscription.
+func (m *DataModel) Subscribe(t Table, start, end uint16, ch chan<- *WriteEvent) (cancel func()) {
+	return m.watch(&watcher{table: t, AddressRange: AddressRange{start, end}, ch: ch})
+}
+
+func (m *DataModel) watch(w *watcher) func() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.watchers = append(m.watchers, w)
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		for i, other := range m.watchers {
+			if other == w {
+				m.watchers = append(m.watchers[:i:i], m.watchers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// check creates the events of the change and asks the vetoing watchers.
+// The model must be locked.
+func (m *DataModel) check(c Change, r *Request) ([]watcherEvent, error) {
+	var events []watcherEvent
+	last := int(c.Address) + len(c.Bits) + len(c.Registers) - 1
+	for _, w := range m.watchers {
+		if w.table != c.Table || int(w.Start) > last || w.End < c.Address {
+			continue
+		}
+		start, end := int(c.Address), last
+		if int(w.Start) > start {
+			start = int(w.Start)
+		}
+		if int(w.End) < end {
+			end = int(w.End)
+		}
+		e := &WriteEvent{Table: c.Table, Address: uint16(start)}
+		from, to := start-int(c.Address), end-int(c.Address)+1
+		if c.Table == TableCoils {
+			e.OldBits = append([]bool{}, m.coils[start:end+1]...)
+			e.NewBits = append([]bool{}, c.Bits[from:to]...)
+		} else {
+			e.OldRegisters = append([]uint16{}, m.holdingRegisters[start:end+1]...)
+			e.NewRegisters = append([]uint16{}, c.Registers[from:to]...)
+		}
+		if r != nil {
+			e.Unit, e.RemoteAddr = r.Unit, r.RemoteAddr
+			if r.Pdu != nil {
+				e.Function = r.Pdu.Function
+			}
+		}
+		if w.veto != nil {
+			if err := w.veto(e); err != nil {
+				return nil, err
+			}
+		}
+		events = append(events, watcherEvent{w, e})
+	}
+	return events, nil
+}
+
+// notify sends the events of a committed change to the subscribers.
+func (m *DataModel) notify(events []watcherEvent) {
+	for _, we := range events {
+		if we.w.ch == nil {
+			continue
+		}
+		select {
+		case we.w.ch <- we.e:
+		default:
+		}
+	}
+}
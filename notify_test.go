@@ -0,0 +1,80 @@
+package modbus
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"net"
+	"testing"
+)
+
+func Test_WriteNotifications(t *testing.T) {
+
+	Convey("Given a watched data model", t, func() {
+		m := NewDataModel(10, 0, 0, 10)
+		m.WriteHoldingRegisters(0, []uint16{1, 2, 3, 4})
+		events := make(chan *WriteEvent, 10)
+		cancel := m.Subscribe(TableHoldingRegisters, 2, 5, events)
+		remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 4000}
+		serve := func(pdu *Pdu) *Pdu {
+			return m.ServeModbus(&Request{Unit: 1, RemoteAddr: remote, Pdu: pdu})
+		}
+
+		Convey("writes should be sent to the subscribers", func() {
+			So(serve(&Pdu{16, []byte{0, 1, 0, 3, 6, 0, 7, 0, 8, 0, 9}}), ShouldResemble, &Pdu{16, []byte{0, 1, 0, 3}})
+			So(len(events), ShouldEqual, 1)
+			e := <-events
+			So(e.Address, ShouldEqual, 2)
+			So(e.OldRegisters, ShouldResemble, []uint16{3, 4})
+			So(e.NewRegisters, ShouldResemble, []uint16{8, 9})
+			So(e.Function, ShouldEqual, 16)
+			So(e.Unit, ShouldEqual, 1)
+			So(e.RemoteAddr, ShouldEqual, remote)
+		})
+
+		Convey("writes outside the range should not be sent", func() {
+			So(m.WriteHoldingRegisters(6, []uint16{1}), ShouldBeNil)
+			m.WriteCoils(2, []bool{true})
+			So(events, ShouldBeEmpty)
+		})
+
+		Convey("writes of the application should be sent without request", func() {
+			So(m.WriteHoldingRegisters(5, []uint16{1}), ShouldBeNil)
+			e := <-events
+			So(e.Function, ShouldEqual, 0)
+			So(e.RemoteAddr, ShouldBeNil)
+		})
+
+		Convey("canceled subscriptions should not get events", func() {
+			cancel()
+			So(m.WriteHoldingRegisters(2, []uint16{1}), ShouldBeNil)
+			So(events, ShouldBeEmpty)
+		})
+
+		Convey("callbacks should be able to veto writes", func() {
+			var seen *WriteEvent
+			m.OnWrite(TableCoils, 0, 9, func(e *WriteEvent) error {
+				seen = e
+				if e.NewBits[0] {
+					return ErrIllegalDataValue
+				}
+				return nil
+			})
+			So(serve(&Pdu{5, []byte{0, 3, 0xff, 0}}), ShouldResemble, &Pdu{0x85, []byte{ExceptionIllegalDataValue}})
+			So(seen.Function, ShouldEqual, 5)
+			So(seen.OldBits, ShouldResemble, []bool{false})
+			bits, _ := m.ReadCoils(3, 1)
+			So(bits, ShouldResemble, []bool{false})
+			So(serve(&Pdu{5, []byte{0, 3, 0, 0}}), ShouldResemble, &Pdu{5, []byte{0, 3, 0, 0}})
+		})
+
+		Convey("vetoed writes should not be committed at all", func() {
+			m.OnWrite(TableHoldingRegisters, 3, 3, func(e *WriteEvent) error {
+				return ErrIllegalDataValue
+			})
+			So(serve(&Pdu{22, []byte{0, 3, 0, 0, 0, 1}}), ShouldResemble, &Pdu{0x96, []byte{ExceptionIllegalDataValue}})
+			So(m.WriteHoldingRegisters(2, []uint16{7, 7}), ShouldResemble, ErrIllegalDataValue)
+			values, _ := m.ReadHoldingRegisters(2, 2)
+			So(values, ShouldResemble, []uint16{3, 4})
+			So(events, ShouldBeEmpty)
+		})
+	})
+}
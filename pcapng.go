@@ -0,0 +1,161 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * pcapng capture files with MBAP over TCP/IPv4 packets
+ */
+
+package modbus
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	pcapngSectionHeader    = 0x0A0D0D0A
+	pcapngInterface        = 0x00000001
+	pcapngEnhancedPacket   = 0x00000006
+	pcapngByteOrderMagic   = 0x1A2B3C4D
+	pcapngLinkTypeRaw      = 101
+	pcapngClientPort       = 50200
+	pcapngServerPort       = 502
+	ipv4HeaderLength       = 20
+	tcpSegmentHeaderLength = 20
+)
+
+var (
+	pcapngClientIP = net.IPv4(10, 0, 0, 1).To4()
+	pcapngServerIP = net.IPv4(10, 0, 0, 2).To4()
+)
+
+type pcapngWriter struct {
+	w           io.Writer
+	transaction uint16
+	ipId        uint16
+	clientSeq   uint32
+	serverSeq   uint32
+}
+
+// NewPcapngWriter writes the records as TCP packets between 10.0.0.1 and
+// 10.0.0.2:502 into a pcapng file that can be inspected with Wireshark.
+// Records of serial transporters are encapsulated in MBAP as well.
+func NewPcapngWriter(w io.Writer) (RecordWriter, error) {
+	p := &pcapngWriter{w: w, clientSeq: 1, serverSeq: 1}
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:], pcapngByteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[4:], 1)
+	binary.LittleEndian.PutUint16(shb[6:], 0)
+	binary.LittleEndian.PutUint64(shb[8:], 0xFFFFFFFFFFFFFFFF)
+	if err := p.block(pcapngSectionHeader, shb); err != nil {
+		return nil, err
+	}
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb[0:], pcapngLinkTypeRaw)
+	if err := p.block(pcapngInterface, idb); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *pcapngWriter) block(typ uint32, body []byte) error {
+	pad := (4 - len(body)%4) % 4
+	l := uint32(12 + len(body) + pad)
+	b := make([]byte, l)
+	binary.LittleEndian.PutUint32(b[0:], typ)
+	binary.LittleEndian.PutUint32(b[4:], l)
+	copy(b[8:], body)
+	binary.LittleEndian.PutUint32(b[l-4:], l)
+	_, err := p.w.Write(b)
+	return err
+}
+
+func (p *pcapngWriter) packet(t time.Time, data []byte) error {
+	body := make([]byte, 20, 20+len(data))
+	ts := uint64(t.UnixNano() / 1000)
+	binary.LittleEndian.PutUint32(body[4:], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(body[8:], uint32(ts))
+	binary.LittleEndian.PutUint32(body[12:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(body[16:], uint32(len(data)))
+	return p.block(pcapngEnhancedPacket, append(body, data...))
+}
+
+func inetChecksum(data []byte, sum uint32) uint16 {
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// tcpPacket builds an IPv4 packet with a TCP segment carrying payload.
+func (p *pcapngWriter) tcpPacket(fromClient bool, payload []byte) []byte {
+	src, dst := pcapngClientIP, pcapngServerIP
+	srcPort, dstPort := uint16(pcapngClientPort), uint16(pcapngServerPort)
+	seq, ack := &p.clientSeq, &p.serverSeq
+	if !fromClient {
+		src, dst = dst, src
+		srcPort, dstPort = dstPort, srcPort
+		seq, ack = ack, seq
+	}
+	l := ipv4HeaderLength + tcpSegmentHeaderLength + len(payload)
+	b := make([]byte, l)
+
+	ip := b[:ipv4HeaderLength]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(l))
+	p.ipId++
+	binary.BigEndian.PutUint16(ip[4:], p.ipId)
+	binary.BigEndian.PutUint16(ip[6:], 0x4000)
+	ip[8] = 64
+	ip[9] = 6
+	copy(ip[12:], src)
+	copy(ip[16:], dst)
+	binary.BigEndian.PutUint16(ip[10:], inetChecksum(ip, 0))
+
+	tcp := b[ipv4HeaderLength:]
+	binary.BigEndian.PutUint16(tcp[0:], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:], *seq)
+	binary.BigEndian.PutUint32(tcp[8:], *ack)
+	tcp[12] = tcpSegmentHeaderLength / 4 << 4
+	tcp[13] = 0x18 // PSH, ACK
+	binary.BigEndian.PutUint16(tcp[14:], 0xffff)
+	copy(tcp[tcpSegmentHeaderLength:], payload)
+	pseudo := uint32(0)
+	for i := 0; i < 4; i += 2 {
+		pseudo += uint32(binary.BigEndian.Uint16(src[i:])) + uint32(binary.BigEndian.Uint16(dst[i:]))
+	}
+	pseudo += 6 + uint32(len(tcp))
+	binary.BigEndian.PutUint16(tcp[16:], inetChecksum(tcp, pseudo))
+
+	*seq += uint32(len(payload))
+	return b
+}
+
+func (p *pcapngWriter) WriteRecord(r *Record) error {
+	p.transaction++
+	h := &header{p.transaction, tcpProtocolId, uint16(len(r.Request.Data) + 2), r.Unit}
+	req, err := (&adu{h, r.Request}).pack()
+	if err != nil {
+		return err
+	}
+	if err := p.packet(r.Time, p.tcpPacket(true, req)); err != nil {
+		return err
+	}
+	if r.Response == nil {
+		return nil
+	}
+	h = &header{p.transaction, tcpProtocolId, uint16(len(r.Response.Data) + 2), r.Unit}
+	res, err := (&adu{h, r.Response}).pack()
+	if err != nil {
+		return err
+	}
+	return p.packet(r.Time.Add(r.Duration), p.tcpPacket(false, res))
+}
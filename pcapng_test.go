@@ -0,0 +1,63 @@
+package modbus
+
+import (
+	"bytes"
+	"encoding/binary"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func Test_Pcapng(t *testing.T) {
+
+	Convey("Given a pcapng writer", t, func() {
+		var buff bytes.Buffer
+		w, err := NewPcapngWriter(&buff)
+		So(err, ShouldBeNil)
+
+		Convey("the file should start with a section header and an interface", func() {
+			b := buff.Bytes()
+			So(len(b), ShouldEqual, 28+20)
+			So(binary.LittleEndian.Uint32(b[0:]), ShouldEqual, 0x0A0D0D0A)
+			So(binary.LittleEndian.Uint32(b[8:]), ShouldEqual, 0x1A2B3C4D)
+			So(binary.LittleEndian.Uint32(b[28:]), ShouldEqual, 1)
+			So(binary.LittleEndian.Uint16(b[36:]), ShouldEqual, 101)
+		})
+
+		Convey("a transaction should be written as two TCP packets", func() {
+			buff.Reset()
+			start := time.Unix(1425211200, 500000)
+			err := w.WriteRecord(&Record{start, time.Millisecond, 7,
+				&Pdu{3, []byte{0, 1, 0, 1}}, &Pdu{3, []byte{2, 0, 42}}, nil})
+			So(err, ShouldBeNil)
+			b := buff.Bytes()
+
+			// enhanced packet block with a 52 byte request packet
+			So(binary.LittleEndian.Uint32(b[0:]), ShouldEqual, 6)
+			So(binary.LittleEndian.Uint32(b[4:]), ShouldEqual, 84)
+			ts := uint64(binary.LittleEndian.Uint32(b[12:]))<<32 | uint64(binary.LittleEndian.Uint32(b[16:]))
+			So(ts, ShouldEqual, uint64(1425211200000500))
+			So(binary.LittleEndian.Uint32(b[20:]), ShouldEqual, 52)
+			ip := b[28:80]
+			So(inetChecksum(ip[:20], 0), ShouldEqual, 0)
+			So(ip[9], ShouldEqual, 6)
+			tcp := ip[20:]
+			So(binary.BigEndian.Uint16(tcp[2:]), ShouldEqual, 502)
+			So(tcp[20:], ShouldResemble, []byte{0, 1, 0, 0, 0, 6, 7, 3, 0, 1, 0, 1})
+
+			// the response acknowledges the request
+			res := b[84:]
+			So(binary.LittleEndian.Uint32(res[4:]), ShouldEqual, 84)
+			tcp = res[28+20:]
+			So(binary.BigEndian.Uint16(tcp[0:]), ShouldEqual, 502)
+			So(binary.BigEndian.Uint32(tcp[8:]), ShouldEqual, 13)
+			So(tcp[20:31], ShouldResemble, []byte{0, 1, 0, 0, 0, 5, 7, 3, 2, 0, 42})
+		})
+
+		Convey("failed transactions should only contain the request", func() {
+			buff.Reset()
+			w.WriteRecord(&Record{time.Now(), 0, 1, &Pdu{3, []byte{0, 1, 0, 1}}, nil, nil})
+			So(buff.Len(), ShouldEqual, 84)
+		})
+	})
+}
@@ -0,0 +1,192 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Modbus TCP proxy
+ */
+
+package modbus
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// unitTransporter is implemented by transporters that can address
+// other units than the configured one.
+type unitTransporter interface {
+	sendTo(unit uint8, pdu *Pdu) (*Pdu, error)
+}
+
+var errNoUnits = errors.New("Transporter can not address other units")
+
+// addressesUnits reports whether t can send requests to other units,
+// also if it is wrapped by middlewares.
+func addressesUnits(t Transporter) bool {
+	_, ok := base(t).(unitTransporter)
+	return ok
+}
+
+// unitSender addresses a fixed unit through a shared transporter.
+type unitSender struct {
+	Transporter
+	unit uint8
+}
+
+func (t unitSender) Send(pdu *Pdu) (*Pdu, error) {
+	if u, ok := t.Transporter.(unitTransporter); ok {
+		return u.sendTo(t.unit, pdu)
+	}
+	return nil, errNoUnits
+}
+
+type proxyCall struct {
+	done    chan struct{}
+	res     *Pdu
+	expires time.Time
+}
+
+type Proxy struct {
+	upstream Transporter
+	ttl      time.Duration
+
+	// serializes the access to the upstream transporter
+	mu sync.Mutex
+
+	cacheMu sync.Mutex
+	cache   map[string]*proxyCall
+
+	listener net.Listener
+}
+
+// NewProxy creates a proxy that forwards the requests of all connected
+// masters to the upstream transporter, also through its middlewares. If
+// cacheTTL is greater than zero identical read requests within that
+// duration are answered only once. Upstream transporters that can not
+// address units, e.g. custom ones, receive all requests for their own
+// unit.
+func NewProxy(upstream Transporter, cacheTTL time.Duration) *Proxy {
+	return &Proxy{upstream: upstream, ttl: cacheTTL, cache: map[string]*proxyCall{}}
+}
+
+func isReadFunction(f uint8) bool {
+	switch f {
+	case 1, 2, 3, 4:
+		return true
+	}
+	return false
+}
+
+func isWriteFunction(f uint8) bool {
+	switch f {
+	case 5, 6, 15, 16, 21, 22, 23:
+		return true
+	}
+	return false
+}
+
+// Forward sends the request to the upstream device and returns its
+// response or a gateway exception.
+func (p *Proxy) Forward(unit uint8, req *Pdu) *Pdu {
+	if isWriteFunction(req.Function) {
+		p.invalidate()
+	}
+	if p.ttl <= 0 || !isReadFunction(req.Function) {
+		return p.send(unit, req)
+	}
+
+	key := string(append([]byte{unit, req.Function}, req.Data...))
+	now := time.Now()
+
+	p.cacheMu.Lock()
+	if c, ok := p.cache[key]; ok && (c.expires.IsZero() || now.Before(c.expires)) {
+		p.cacheMu.Unlock()
+		<-c.done
+		return c.res
+	}
+	for k, c := range p.cache {
+		if !c.expires.IsZero() && !now.Before(c.expires) {
+			delete(p.cache, k)
+		}
+	}
+	c := &proxyCall{done: make(chan struct{})}
+	p.cache[key] = c
+	p.cacheMu.Unlock()
+
+	c.res = p.send(unit, req)
+
+	p.cacheMu.Lock()
+	if c.res.Function&0x80 != 0 {
+		if p.cache[key] == c {
+			delete(p.cache, key)
+		}
+	} else {
+		c.expires = time.Now().Add(p.ttl)
+	}
+	p.cacheMu.Unlock()
+	close(c.done)
+	return c.res
+}
+
+// invalidate drops the cached responses and the reads in flight, so
+// later reads do not wait for a response from before the write.
+func (p *Proxy) invalidate() {
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+	p.cache = map[string]*proxyCall{}
+}
+
+func (p *Proxy) send(unit uint8, req *Pdu) *Pdu {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var res *Pdu
+	var err error
+	if addressesUnits(p.upstream) {
+		res, err = p.upstream.(unitTransporter).sendTo(unit, req)
+	} else {
+		res, err = p.upstream.Send(req)
+	}
+	switch err.(type) {
+	case nil:
+		if res == nil {
+			return exceptionPdu(req.Function, ExceptionGatewayTargetDeviceFailedToRespond)
+		}
+		return res
+	case TransportError:
+		p.upstream.Close()
+		return exceptionPdu(req.Function, ExceptionGatewayPathUnavailable)
+	case TimeoutError:
+		p.upstream.Close()
+	}
+	return exceptionPdu(req.Function, ExceptionGatewayTargetDeviceFailedToRespond)
+}
+
+// Serve accepts MBAP requests on the listener until Close is called.
+// The transaction ids of the masters are restored in the responses.
+func (p *Proxy) Serve(l net.Listener) error {
+	p.cacheMu.Lock()
+	p.listener = l
+	p.cacheMu.Unlock()
+	return serveTcp(l, func(conn net.Conn, h *header, req *Pdu) *Pdu {
+		return p.Forward(h.unit, req)
+	})
+}
+
+func (p *Proxy) ListenAndServe(address string) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return p.Serve(l)
+}
+
+func (p *Proxy) Close() error {
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+	if p.listener == nil {
+		return nil
+	}
+	return p.listener.Close()
+}
@@ -0,0 +1,136 @@
+package modbus
+
+import (
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Proxy(t *testing.T) {
+
+	Convey("Given a proxy", t, func() {
+		var mu sync.Mutex
+		calls := 0
+		d := &dummyTransporter{send: func(pdu *Pdu) (*Pdu, error) {
+			mu.Lock()
+			calls++
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			if pdu.Function == 6 {
+				return pdu, nil
+			}
+			return &Pdu{pdu.Function, []byte{0x02, 0x00, 0x2a}}, nil
+		}}
+
+		Convey("without cache every request should be forwarded", func() {
+			p := NewProxy(d, 0)
+			p.Forward(1, &Pdu{3, []byte{0, 0, 0, 1}})
+			p.Forward(1, &Pdu{3, []byte{0, 0, 0, 1}})
+			So(calls, ShouldEqual, 2)
+		})
+
+		Convey("with cache duplicate reads should be collapsed", func() {
+			p := NewProxy(&dummyTransporter{send: d.send}, time.Minute)
+			var wg sync.WaitGroup
+			results := make([]*Pdu, 5)
+			for i := range results {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					results[i] = p.Forward(1, &Pdu{3, []byte{0, 0, 0, 1}})
+				}(i)
+			}
+			wg.Wait()
+			for _, res := range results {
+				So(res.Data, ShouldResemble, []byte{0x02, 0x00, 0x2a})
+			}
+			p.Forward(1, &Pdu{3, []byte{0, 0, 0, 1}})
+			So(calls, ShouldEqual, 1)
+
+			Convey("other requests should not be collapsed", func() {
+				p.Forward(2, &Pdu{3, []byte{0, 0, 0, 1}})
+				p.Forward(1, &Pdu{3, []byte{0, 1, 0, 1}})
+				So(calls, ShouldEqual, 3)
+			})
+
+			Convey("writes should invalidate the cache", func() {
+				p.Forward(1, &Pdu{6, []byte{0, 0, 0, 1}})
+				p.Forward(1, &Pdu{3, []byte{0, 0, 0, 1}})
+				So(calls, ShouldEqual, 3)
+			})
+		})
+
+		Convey("reads in flight should not be joined after a write", func() {
+			started := make(chan struct{}, 3)
+			p := NewProxy(&dummyTransporter{send: func(pdu *Pdu) (*Pdu, error) {
+				started <- struct{}{}
+				return d.send(pdu)
+			}}, time.Minute)
+			done := make(chan struct{})
+			go func() {
+				p.Forward(1, &Pdu{3, []byte{0, 0, 0, 1}})
+				close(done)
+			}()
+			<-started
+			p.Forward(1, &Pdu{6, []byte{0, 0, 0, 1}})
+			<-done
+			p.Forward(1, &Pdu{3, []byte{0, 0, 0, 1}})
+			So(calls, ShouldEqual, 3)
+		})
+
+		Convey("upstream failures should be answered with gateway exceptions", func() {
+			p := NewProxy(&dummyTransporter{send: func(pdu *Pdu) (*Pdu, error) {
+				return &Pdu{}, TimeoutError{"read", errors.New("timeout")}
+			}}, 0)
+			res := p.Forward(1, &Pdu{3, []byte{0, 0, 0, 1}})
+			So(res, ShouldResemble, &Pdu{0x83, []byte{ExceptionGatewayTargetDeviceFailedToRespond}})
+		})
+
+		Convey("upstreams with middlewares should address the units", func() {
+			bus := &busTransporter{units: map[uint8]Handler{
+				3: NewDataModel(1, 1, 1, 1),
+			}}
+			stats := &Stats{}
+			p := NewProxy(Chain(bus, Metrics(stats)), 0)
+			res := p.Forward(3, &Pdu{3, []byte{0, 0, 0, 1}})
+			So(res, ShouldResemble, &Pdu{3, []byte{2, 0, 0}})
+			res = p.Forward(1, &Pdu{3, []byte{0, 0, 0, 1}})
+			So(res, ShouldResemble, &Pdu{0x83, []byte{ExceptionGatewayTargetDeviceFailedToRespond}})
+			So(stats.Requests(), ShouldEqual, 2)
+		})
+
+		Convey("when serving many masters", func() {
+			p := NewProxy(d, 0)
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			So(err, ShouldBeNil)
+			go p.Serve(l)
+			defer p.Close()
+			port := uint(l.Addr().(*net.TCPAddr).Port)
+
+			Convey("every master should get its own response", func() {
+				var wg sync.WaitGroup
+				errs := make(chan error, 6)
+				for i := 0; i < 3; i++ {
+					wg.Add(1)
+					go func(i int) {
+						defer wg.Done()
+						c := NewTcpClientTimeout("127.0.0.1", port, time.Second)
+						defer c.Transporter().Close()
+						for j := 0; j <= i; j++ {
+							errs <- c.WriteSingleRegister(uint16(i), uint16(j))
+						}
+					}(i)
+				}
+				wg.Wait()
+				close(errs)
+				for err := range errs {
+					So(err, ShouldBeNil)
+				}
+				So(calls, ShouldEqual, 6)
+			})
+		})
+	})
+}
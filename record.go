@@ -0,0 +1,212 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Recording and replay of Modbus transactions
+ */
+
+package modbus
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Record is one recorded transaction.
+type Record struct {
+	Time     time.Time
+	Duration time.Duration
+	Unit     uint8
+	Request  *Pdu
+	Response *Pdu
+	Err      error
+}
+
+type jsonRecord struct {
+	Time     time.Time `json:"time"`
+	Duration string    `json:"duration"`
+	Unit     uint8     `json:"unit"`
+	Request  string    `json:"request"`
+	Response string    `json:"response,omitempty"`
+	Error    string    `json:"error,omitempty"`
+
+	// "timeout" or "transport" for the corresponding error types
+	ErrorType string `json:"error_type,omitempty"`
+	ErrorOp   string `json:"error_op,omitempty"`
+}
+
+func pduHex(pdu *Pdu) string {
+	if pdu == nil {
+		return ""
+	}
+	return hex.EncodeToString(append([]byte{pdu.Function}, pdu.Data...))
+}
+
+func hexPdu(s string) (*Pdu, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return unpackPdu(b)
+}
+
+func (r *Record) MarshalJSON() ([]byte, error) {
+	j := jsonRecord{
+		Time:     r.Time,
+		Duration: r.Duration.String(),
+		Unit:     r.Unit,
+		Request:  pduHex(r.Request),
+		Response: pduHex(r.Response),
+	}
+	switch e := r.Err.(type) {
+	case nil:
+	case TimeoutError:
+		j.ErrorType, j.ErrorOp, j.Error = "timeout", e.Op, e.Err.Error()
+	case TransportError:
+		j.ErrorType, j.ErrorOp, j.Error = "transport", e.Op, e.Err.Error()
+	default:
+		j.Error = e.Error()
+	}
+	return json.Marshal(j)
+}
+
+func (r *Record) UnmarshalJSON(data []byte) (err error) {
+	var j jsonRecord
+	if err = json.Unmarshal(data, &j); err != nil {
+		return
+	}
+	r.Time, r.Unit = j.Time, j.Unit
+	if r.Duration, err = time.ParseDuration(j.Duration); err != nil {
+		return
+	}
+	if r.Request, err = hexPdu(j.Request); err != nil {
+		return
+	}
+	if r.Request == nil {
+		return errors.New("Missing request")
+	}
+	if r.Response, err = hexPdu(j.Response); err != nil {
+		return
+	}
+	r.Err = nil
+	if j.Error != "" {
+		switch j.ErrorType {
+		case "timeout":
+			r.Err = TimeoutError{j.ErrorOp, errors.New(j.Error)}
+		case "transport":
+			r.Err = TransportError{j.ErrorOp, errors.New(j.Error)}
+		default:
+			r.Err = errors.New(j.Error)
+		}
+	}
+	return
+}
+
+type RecordWriter interface {
+	WriteRecord(r *Record) error
+}
+
+type jsonRecordWriter struct {
+	enc *json.Encoder
+}
+
+// NewJSONRecordWriter writes every record as one line of JSON.
+func NewJSONRecordWriter(w io.Writer) RecordWriter {
+	return &jsonRecordWriter{json.NewEncoder(w)}
+}
+
+func (w *jsonRecordWriter) WriteRecord(r *Record) error {
+	return w.enc.Encode(r)
+}
+
+// ReadRecords reads the JSON lines written by a JSON record writer.
+func ReadRecords(r io.Reader) (records []*Record, err error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 4096), 1<<20)
+	for line := 1; s.Scan(); line++ {
+		if len(bytes.TrimSpace(s.Bytes())) == 0 {
+			continue
+		}
+		rec := &Record{}
+		if err := json.Unmarshal(s.Bytes(), rec); err != nil {
+			return nil, fmt.Errorf("Invalid record in line %d: %s", line, err)
+		}
+		records = append(records, rec)
+	}
+	return records, s.Err()
+}
+
+// unitOf returns the unit id a transporter sends its requests to.
+func unitOf(t Transporter) uint8 {
+	switch t := t.(type) {
+	case *tcpTransporter:
+		return t.id
+	case *rtuTransporter:
+		return t.slave
+	case *asciiTransporter:
+		return t.slave
+	case *slaveTransporter:
+		return t.slave
+	case *sendTransporter:
+		return unitOf(t.Transporter)
+	case unitSender:
+		return t.unit
+	}
+	return 0
+}
+
+// Recording writes every transaction to w. Failing writes do not affect
+// the transaction.
+func Recording(w RecordWriter) Middleware {
+	var mu sync.Mutex
+	return WrapSend(func(next Transporter, req *Pdu) (*Pdu, error) {
+		start := time.Now()
+		res, err := next.Send(req)
+		mu.Lock()
+		w.WriteRecord(&Record{start, time.Since(start), unitOf(next), req, res, err})
+		mu.Unlock()
+		return res, err
+	})
+}
+
+type replayTransporter struct {
+	mu      sync.Mutex
+	records []*Record
+	used    []bool
+}
+
+// NewReplayTransporter creates a transporter that answers every request
+// with the response of the first unused record with the same request.
+func NewReplayTransporter(records []*Record) Transporter {
+	return &replayTransporter{records: records, used: make([]bool, len(records))}
+}
+
+func (t *replayTransporter) Connect() error {
+	return nil
+}
+
+func (t *replayTransporter) Close() error {
+	return nil
+}
+
+func (t *replayTransporter) Send(pdu *Pdu) (*Pdu, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, r := range t.records {
+		if t.used[i] || r.Request.Function != pdu.Function || !bytes.Equal(r.Request.Data, pdu.Data) {
+			continue
+		}
+		t.used[i] = true
+		return r.Response, r.Err
+	}
+	return nil, fmt.Errorf("No recorded transaction for request %s", pduHex(pdu))
+}
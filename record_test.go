@@ -0,0 +1,95 @@
+package modbus
+
+import (
+	"bytes"
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+	"time"
+)
+
+type recordList struct {
+	records []*Record
+}
+
+func (l *recordList) WriteRecord(r *Record) error {
+	l.records = append(l.records, r)
+	return nil
+}
+
+func Test_Record(t *testing.T) {
+
+	Convey("Given a recording client", t, func() {
+		list := &recordList{}
+		d := &dummyTransporter{send: func(pdu *Pdu) (*Pdu, error) {
+			if pdu.Function == 4 {
+				return nil, TimeoutError{"receive data", errors.New("i/o timeout")}
+			}
+			return &Pdu{3, []byte{0x02, 0x00, 0x2a}}, nil
+		}}
+		c := NewClient(d, Recording(list))
+
+		Convey("every transaction should be recorded", func() {
+			c.ReadHoldingRegisters(1, 1)
+			c.ReadInputRegisters(1, 1)
+			So(len(list.records), ShouldEqual, 2)
+			So(list.records[0].Request, ShouldResemble, &Pdu{3, []byte{0, 1, 0, 1}})
+			So(list.records[0].Response, ShouldResemble, &Pdu{3, []byte{0x02, 0x00, 0x2a}})
+			So(list.records[1].Err, ShouldHaveSameTypeAs, TimeoutError{})
+		})
+
+		Convey("the unit id of the transporter should be recorded", func() {
+			tcp := &tcpTransporter{id: 9}
+			So(unitOf(Chain(tcp, Logging(nil), Recording(list))), ShouldEqual, 9)
+		})
+	})
+
+	Convey("Given some records", t, func() {
+		now := time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)
+		records := []*Record{
+			{now, time.Millisecond, 1, &Pdu{3, []byte{0, 0, 0, 1}}, &Pdu{3, []byte{2, 0, 7}}, nil},
+			{now, 0, 1, &Pdu{3, []byte{0, 0, 0, 1}}, &Pdu{3, []byte{2, 0, 8}}, nil},
+			{now, time.Second, 1, &Pdu{6, []byte{0, 0, 0, 1}}, nil, TimeoutError{"receive data", errors.New("i/o timeout")}},
+			{now, 0, 1, &Pdu{5, []byte{0, 0, 0xff, 0}}, nil, TransportError{"write data", errors.New("broken pipe")}},
+			{now, 0, 1, &Pdu{1, []byte{0, 0, 0, 1}}, &Pdu{0x81, []byte{2}}, nil},
+		}
+
+		Convey("they should survive the JSON round trip", func() {
+			var buff bytes.Buffer
+			w := NewJSONRecordWriter(&buff)
+			for _, r := range records {
+				So(w.WriteRecord(r), ShouldBeNil)
+			}
+			So(strings.Count(buff.String(), "\n"), ShouldEqual, len(records))
+			So(buff.String(), ShouldContainSubstring, `"request":"0300000001","response":"03020007"`)
+			read, err := ReadRecords(&buff)
+			So(err, ShouldBeNil)
+			So(read, ShouldResemble, records)
+		})
+
+		Convey("invalid lines should be reported", func() {
+			_, err := ReadRecords(strings.NewReader("\n{\"request\":\"zz\"}\n"))
+			So(err.Error(), ShouldStartWith, "Invalid record in line 2")
+		})
+
+		Convey("a replay transporter should answer in the recorded order", func() {
+			c := NewClient(NewReplayTransporter(records))
+			v, err := c.ReadHoldingRegisters(0, 1)
+			So(err, ShouldBeNil)
+			So(v, ShouldResemble, []uint16{7})
+			v, _ = c.ReadHoldingRegisters(0, 1)
+			So(v, ShouldResemble, []uint16{8})
+			_, err = c.ReadHoldingRegisters(0, 1)
+			So(err.Error(), ShouldStartWith, "No recorded transaction")
+		})
+
+		Convey("a replay transporter should reproduce errors", func() {
+			c := NewClient(NewReplayTransporter(records))
+			So(c.WriteSingleRegister(0, 1), ShouldHaveSameTypeAs, TimeoutError{})
+			So(Retryable(c.WriteSingleCoil(0, true)), ShouldBeTrue)
+			_, err := c.ReadCoils(0, 1)
+			So(errors.Is(err, ErrIllegalDataAddress), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,310 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Modbus RTU (serial line) implementation
+ */
+
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	rtuMinLength = 4
+	rtuMaxLength = 256
+)
+
+type rtuAdu struct {
+	slave uint8
+	pdu   *Pdu
+}
+
+func crc16(data []byte) uint16 {
+	crc := uint16(0xffff)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = (crc >> 1) ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+func (adu *rtuAdu) pack() (bin []byte, err error) {
+	binPdu, err := adu.pdu.pack()
+	if err != nil {
+		return
+	}
+	bin = append([]byte{adu.slave}, binPdu...)
+	crc := crc16(bin)
+	return append(bin, uint8(crc&0xff), uint8(crc>>8)), nil
+}
+
+func unpackRtuAdu(data []byte) (*rtuAdu, error) {
+	l := len(data)
+	if l < rtuMinLength || l > rtuMaxLength {
+		return nil, FramingError{fmt.Sprintf("invalid RTU frame length: %d byte", l)}
+	}
+	if crc, sum := binary.LittleEndian.Uint16(data[l-2:]), crc16(data[:l-2]); crc != sum {
+		return nil, CRCError{sum, crc}
+	}
+	pdu, err := unpackPdu(data[1 : l-2])
+	if err != nil {
+		return nil, FramingError{err.Error()}
+	}
+	return &rtuAdu{data[0], pdu}, nil
+}
+
+// rtuResponseLength calculates the length of a response frame from its
+// first bytes. It returns 0 if more bytes are needed.
+func rtuResponseLength(head []byte) (int, error) {
+	if len(head) < 3 {
+		return 0, nil
+	}
+	fn := head[1]
+	if fn&0x80 != 0 {
+		return 5, nil
+	}
+	switch fn {
+	case 1, 2, 3, 4, 12, 17, 20, 21, 23:
+		return 3 + int(head[2]) + 2, nil
+	case 7:
+		return 5, nil
+	case 5, 6, 8, 11, 15, 16:
+		return 8, nil
+	case 22:
+		return 10, nil
+	case 24:
+		if len(head) < 4 {
+			return 0, nil
+		}
+		return 4 + int(binary.BigEndian.Uint16(head[2:])) + 2, nil
+	case 43:
+		// MEI type, read code, conformity level, more follows and next
+		// object id precede the number of objects and the objects
+		if len(head) < 8 {
+			return 0, nil
+		}
+		l := 8
+		for o := 0; o < int(head[7]); o++ {
+			if len(head) < l+2 {
+				return 0, nil
+			}
+			l += 2 + int(head[l+1])
+		}
+		return l + 2, nil
+	}
+	return 0, FramingError{fmt.Sprintf("unsupported function code %d", fn)}
+}
+
+// rtuRequestLength calculates the length of a request frame from its
+// first bytes. It returns 0 if more bytes are needed.
+func rtuRequestLength(head []byte) (int, error) {
+	if len(head) < 2 {
+		return 0, nil
+	}
+	switch fn := head[1]; fn {
+	case 1, 2, 3, 4, 5, 6, 8:
+		return 8, nil
+	case 7, 11, 12, 17:
+		return 4, nil
+	case 15, 16:
+		if len(head) < 7 {
+			return 0, nil
+		}
+		return 9 + int(head[6]), nil
+	case 20, 21:
+		if len(head) < 3 {
+			return 0, nil
+		}
+		return 5 + int(head[2]), nil
+	case 22:
+		return 10, nil
+	case 23:
+		if len(head) < 11 {
+			return 0, nil
+		}
+		return 13 + int(head[10]), nil
+	case 24:
+		return 6, nil
+	case 43:
+		return 7, nil
+	default:
+		return 0, FramingError{fmt.Sprintf("unsupported function code %d", fn)}
+	}
+}
+
+type deadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+type readResult struct {
+	data []byte
+	err  error
+}
+
+// deadlinePort adds read deadlines to a port without them. A read that
+// exceeds the deadline continues in the background and its data is
+// returned by the next Read, so at most one read is pending.
+type deadlinePort struct {
+	io.ReadWriter
+	deadline time.Time
+	pending  chan readResult
+	rest     []byte
+}
+
+// withReadDeadline returns the port if it supports read deadlines and
+// wraps it otherwise.
+func withReadDeadline(port io.ReadWriter) io.ReadWriter {
+	if _, ok := port.(deadliner); ok {
+		return port
+	}
+	return &deadlinePort{ReadWriter: port}
+}
+
+func (p *deadlinePort) Close() error {
+	if c, ok := p.ReadWriter.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (p *deadlinePort) SetReadDeadline(t time.Time) error {
+	p.deadline = t
+	return nil
+}
+
+func (p *deadlinePort) Read(b []byte) (int, error) {
+	if len(p.rest) > 0 {
+		n := copy(b, p.rest)
+		p.rest = p.rest[n:]
+		return n, nil
+	}
+	if p.pending == nil {
+		ch := make(chan readResult, 1)
+		p.pending = ch
+		go func(buf []byte) {
+			n, err := p.ReadWriter.Read(buf)
+			ch <- readResult{buf[:n], err}
+		}(make([]byte, len(b)))
+	}
+	var timeout <-chan time.Time
+	if !p.deadline.IsZero() {
+		timer := time.NewTimer(time.Until(p.deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case r := <-p.pending:
+		p.pending = nil
+		n := copy(b, r.data)
+		p.rest = r.data[n:]
+		return n, r.err
+	case <-timeout:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+type rtuTransporter struct {
+	port    io.ReadWriter
+	slave   uint8
+	timeout time.Duration
+
+	// silent interval before a request (t3.5)
+	gap  time.Duration
+	last time.Time
+}
+
+func (t *rtuTransporter) Connect() error {
+	return nil
+}
+
+func (t *rtuTransporter) Close() error {
+	if c, ok := t.port.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (t *rtuTransporter) Send(pdu *Pdu) (*Pdu, error) {
+	return t.sendTo(t.slave, pdu)
+}
+
+func (t *rtuTransporter) sendTo(slave uint8, pdu *Pdu) (*Pdu, error) {
+	bin, err := (&rtuAdu{slave, pdu}).pack()
+	if err != nil {
+		return nil, err
+	}
+	if wait := time.Until(t.last.Add(t.gap)); wait > 0 {
+		time.Sleep(wait)
+	}
+	defer func() {
+		t.last = time.Now()
+	}()
+	if d, ok := t.port.(deadliner); ok && t.timeout > 0 {
+		d.SetReadDeadline(time.Now().Add(t.timeout))
+	}
+	if _, err := t.port.Write(bin); err != nil {
+		return nil, transportError("write data", err)
+	}
+	if slave == 0 {
+		// broadcast requests are not answered
+		return nil, nil
+	}
+	buff := make([]byte, rtuMaxLength)
+	n, l := 0, 0
+	for l == 0 || n < l {
+		want := rtuMinLength
+		switch {
+		case l > 0:
+			want = l
+		case n >= want:
+			// the length is not known yet
+			want = n + 1
+		}
+		m, err := io.ReadAtLeast(t.port, buff[n:], want-n)
+		n += m
+		if err != nil {
+			return nil, transportError("receive data", err)
+		}
+		if l, err = rtuResponseLength(buff[:n]); err != nil {
+			return nil, err
+		}
+		if l > rtuMaxLength {
+			return nil, FramingError{fmt.Sprintf("invalid RTU frame length: %d byte", l)}
+		}
+	}
+	res, err := unpackRtuAdu(buff[:l])
+	if err != nil {
+		return nil, err
+	}
+	if res.slave != slave {
+		return nil, FramingError{fmt.Sprintf("slave id %d instead of %d", res.slave, slave)}
+	}
+	return res.pdu, nil
+}
+
+// NewRtuTransporter creates a transporter on port. Since the baud rate of
+// the port is unknown, requests are not preceded by the silent interval
+// t3.5; OpenRtuTransporter and buses of OpenRtuBus wait for it. The
+// timeout also applies to ports without read deadlines.
+func NewRtuTransporter(port io.ReadWriter, slave uint8, timeout time.Duration) Transporter {
+	return &rtuTransporter{port: withReadDeadline(port), slave: slave, timeout: timeout}
+}
+
+func NewRtuClient(port io.ReadWriter, slave uint8, mw ...Middleware) SerialClient {
+	return NewRtuClientTimeout(port, slave, 0, mw...)
+}
+
+func NewRtuClientTimeout(port io.ReadWriter, slave uint8, timeout time.Duration, mw ...Middleware) SerialClient {
+	return &mbClient{Chain(NewRtuTransporter(port, slave, timeout), mw...)}
+}
@@ -0,0 +1,146 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Modbus RTU server (slave) on a serial line
+ */
+
+package modbus
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// RtuServer answers the requests to its units on a serial line.
+// Only the units set by SetUnitHandler are answered. Requests to other
+// units and corrupted frames are ignored, broadcasts are passed to every
+// unit handler without being answered.
+type RtuServer struct {
+	units
+	background
+
+	// delay between a request and its response (default 3.5 characters)
+	Turnaround time.Duration
+
+	port     io.ReadWriter
+	charTime time.Duration
+	gap      time.Duration
+}
+
+// NewRtuServer creates a server on port. The baud rate is used to detect
+// the silent intervals between frames of 8E1 characters; with 0 frames
+// are only separated by their length and checksum.
+func NewRtuServer(port io.ReadWriter, baudRate int) *RtuServer {
+	return newRtuServer(port, (&SerialConfig{BaudRate: baudRate}).Timing())
+}
+
+func newRtuServer(port io.ReadWriter, timing SerialTiming) *RtuServer {
+	s := &RtuServer{Turnaround: timing.T35, port: port, charTime: timing.Char, gap: timing.T35}
+	s.unknown = UnknownUnitSilent
+	s.explicit = true
+	return s
+}
+
+// requestFrameLength returns the length of the request frame at the
+// beginning of b, 0 if more bytes are needed or -1 if b does not start
+// with a valid frame.
+func requestFrameLength(b []byte) int {
+	l, err := rtuRequestLength(b)
+	if err == nil {
+		switch {
+		case l > rtuMaxLength:
+			return -1
+		case l == 0 || l > len(b):
+			return 0
+		case validCRC(b[:l]):
+			return l
+		}
+		return -1
+	}
+	// unknown function codes can only be detected by their checksum
+	for l := rtuMinLength; l <= len(b) && l <= rtuMaxLength; l++ {
+		if validCRC(b[:l]) {
+			return l
+		}
+	}
+	if len(b) >= rtuMaxLength {
+		return -1
+	}
+	return 0
+}
+
+// Serve reads requests from the port until reading fails.
+func (s *RtuServer) Serve() error {
+	buff := make([]byte, rtuMaxLength)
+	var frame []byte
+	var last time.Time
+	for {
+		n, err := s.port.Read(buff)
+		if n > 0 {
+			now := time.Now()
+			start := now.Add(-time.Duration(n) * s.charTime)
+			if len(frame) > 0 && s.gap > 0 && start.Sub(last) >= s.gap {
+				// the rest of an incomplete frame
+				frame = nil
+			}
+			last = now
+			frame = append(frame, buff[:n]...)
+			for len(frame) > 0 {
+				l := requestFrameLength(frame)
+				if l == 0 {
+					break
+				}
+				if l < 0 {
+					frame = nil
+					break
+				}
+				if werr := s.handle(frame[:l]); werr != nil {
+					return werr
+				}
+				frame = frame[l:]
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *RtuServer) handle(bin []byte) error {
+	adu, err := unpackRtuAdu(bin)
+	if err != nil {
+		return nil
+	}
+	r := &Request{Unit: adu.slave, Transport: FramingRTU, Pdu: adu.pdu}
+	if adu.slave == 0 {
+		s.broadcast(r)
+		return nil
+	}
+	res := s.serve(r)
+	if res == nil {
+		return nil
+	}
+	out, err := (&rtuAdu{adu.slave, res}).pack()
+	if err != nil {
+		return nil
+	}
+	time.Sleep(s.Turnaround)
+	_, err = s.port.Write(out)
+	return err
+}
+
+// Start serves the port in the background. The error that stops it is
+// returned by Err.
+func (s *RtuServer) Start() error {
+	s.start(s.Serve)
+	return nil
+}
+
+// Stop closes the port.
+func (s *RtuServer) Stop() error {
+	if c, ok := s.port.(io.Closer); ok {
+		return c.Close()
+	}
+	return errors.New("Port can not be closed")
+}
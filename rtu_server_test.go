@@ -0,0 +1,87 @@
+package modbus
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_RtuServer(t *testing.T) {
+
+	Convey("Given a rtu server on a line", t, func() {
+		line, port := net.Pipe()
+		defer line.Close()
+		s := NewRtuServer(port, 19200)
+		s.Turnaround = 0
+		m := NewDataModel(0, 0, 0, 10)
+		m.WriteHoldingRegisters(0, []uint16{0x1234})
+		s.SetUnitHandler(17, m)
+		s.Start()
+		defer s.Stop()
+
+		readFrame := func(n int) []byte {
+			line.SetReadDeadline(time.Now().Add(time.Second))
+			buff := make([]byte, n)
+			_, err := io.ReadFull(line, buff)
+			So(err, ShouldBeNil)
+			return buff
+		}
+		read := rtuFrame(17, &Pdu{3, []byte{0, 0, 0, 1}})
+
+		Convey("requests to its units should be answered", func() {
+			line.Write(read)
+			So(readFrame(7), ShouldResemble, rtuFrame(17, &Pdu{3, []byte{2, 0x12, 0x34}}))
+		})
+
+		Convey("requests split into chunks should be reassembled", func() {
+			line.Write(read[:3])
+			line.Write(read[3:])
+			So(readFrame(7), ShouldResemble, rtuFrame(17, &Pdu{3, []byte{2, 0x12, 0x34}}))
+		})
+
+		Convey("unknown function codes should be answered with an exception", func() {
+			line.Write(rtuFrame(17, &Pdu{0x41, []byte{1, 2}}))
+			So(readFrame(5), ShouldResemble, rtuFrame(17, &Pdu{0xc1, []byte{ExceptionIllegalFunction}}))
+		})
+
+		Convey("requests to other units and corrupted frames should be ignored", func() {
+			line.Write(rtuFrame(18, &Pdu{3, []byte{0, 0, 0, 1}}))
+			bad := append([]byte{}, read...)
+			bad[7]++
+			line.Write(bad)
+			time.Sleep(5 * time.Millisecond)
+			line.Write(read)
+			So(readFrame(7), ShouldResemble, rtuFrame(17, &Pdu{3, []byte{2, 0x12, 0x34}}))
+		})
+
+		Convey("incomplete frames should be dropped after a silent interval", func() {
+			line.Write(read[:5])
+			time.Sleep(10 * time.Millisecond)
+			line.Write(read)
+			So(readFrame(7), ShouldResemble, rtuFrame(17, &Pdu{3, []byte{2, 0x12, 0x34}}))
+		})
+
+		Convey("the default handler should not answer other units", func() {
+			s.SetHandler(m)
+			line.Write(rtuFrame(18, &Pdu{3, []byte{0, 0, 0, 1}}))
+			time.Sleep(5 * time.Millisecond)
+			line.Write(read)
+			So(readFrame(7), ShouldResemble, rtuFrame(17, &Pdu{3, []byte{2, 0x12, 0x34}}))
+		})
+
+		Convey("the error that stops the server should be kept", func() {
+			So(s.Err(), ShouldBeNil)
+			line.Close()
+			time.Sleep(5 * time.Millisecond)
+			So(s.Err(), ShouldEqual, io.EOF)
+		})
+
+		Convey("broadcasts should be executed but not answered", func() {
+			line.Write(rtuFrame(0, &Pdu{6, []byte{0, 1, 0, 7}}))
+			line.Write(rtuFrame(17, &Pdu{3, []byte{0, 1, 0, 1}}))
+			So(readFrame(7), ShouldResemble, rtuFrame(17, &Pdu{3, []byte{2, 0, 7}}))
+		})
+	})
+}
@@ -0,0 +1,193 @@
+package modbus
+
+import (
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+type dummyPort struct {
+
+	// define a dummy slave that answers a request frame
+	handle func(frame []byte) []byte
+
+	// buffered response frames
+	resp bytes.Buffer
+}
+
+func (p *dummyPort) Write(b []byte) (int, error) {
+	if p.handle != nil {
+		p.resp.Write(p.handle(b))
+	}
+	return len(b), nil
+}
+
+func (p *dummyPort) Read(b []byte) (int, error) {
+	return p.resp.Read(b)
+}
+
+func rtuFrame(slave uint8, pdu *Pdu) []byte {
+	bin, _ := (&rtuAdu{slave, pdu}).pack()
+	return bin
+}
+
+func Test_Rtu(t *testing.T) {
+
+	Convey("Given some data", t, func() {
+		data := []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01}
+
+		Convey("the CRC should be calculated", func() {
+			So(crc16(data), ShouldEqual, 0x0a84)
+		})
+	})
+
+	Convey("Given a rtu adu struct", t, func() {
+		adu := &rtuAdu{1, &Pdu{3, []byte{0x00, 0x00, 0x00, 0x01}}}
+
+		Convey("When we pack it", func() {
+			bin, _ := adu.pack()
+
+			Convey("the CRC should be appended low byte first", func() {
+				So(bin, ShouldResemble, []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x0a})
+			})
+		})
+	})
+
+	Convey("Given a binary rtu adu", t, func() {
+		bin := []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x0a}
+
+		Convey("When we unpack it", func() {
+			adu, err := unpackRtuAdu(bin)
+
+			Convey("the slave and pdu should be decoded", func() {
+				So(err, ShouldBeNil)
+				So(adu.slave, ShouldEqual, 1)
+				So(adu.pdu.Function, ShouldEqual, 3)
+				So(len(adu.pdu.Data), ShouldEqual, 4)
+			})
+		})
+
+		Convey("When the CRC is invalid", func() {
+			bin[7] = 0
+			_, err := unpackRtuAdu(bin)
+
+			Convey("we should get a CRC error", func() {
+				So(err, ShouldResemble, CRCError{0x0a84, 0x0084})
+			})
+		})
+	})
+
+	Convey("Given a rtu client", t, func() {
+		port := &dummyPort{}
+		c := NewRtuClient(port, 17)
+
+		Convey("the response should be read", func() {
+			port.handle = func(frame []byte) []byte {
+				return rtuFrame(17, &Pdu{3, []byte{0x04, 0x00, 0x07, 0x01, 0x00}})
+			}
+			v, err := c.ReadHoldingRegisters(0, 2)
+			So(err, ShouldBeNil)
+			So(v, ShouldResemble, []uint16{7, 256})
+		})
+
+		Convey("exceptions should be read", func() {
+			port.handle = func(frame []byte) []byte {
+				return rtuFrame(17, &Pdu{0x83, []byte{0x02}})
+			}
+			_, err := c.ReadHoldingRegisters(0, 2)
+			So(err, ShouldResemble, Error{0x83, 0x02})
+		})
+
+		Convey("responses of other slaves should be rejected", func() {
+			port.handle = func(frame []byte) []byte {
+				return rtuFrame(18, &Pdu{6, frame[2:6]})
+			}
+			err := c.WriteSingleRegister(0, 1)
+			So(err, ShouldHaveSameTypeAs, FramingError{})
+		})
+
+		Convey("missing responses should fail", func() {
+			_, err := c.ReadCoils(0, 1)
+			So(err, ShouldHaveSameTypeAs, TransportError{})
+		})
+
+		Convey("device identifications should be read byte by byte", func() {
+			port.handle = func(frame []byte) []byte {
+				res, _ := NewPdu(&ReadDeviceIdentificationResponse{DeviceIdBasic, 1, false, 0,
+					map[uint8]string{0: "acme", 1: "meter", 2: "v1.2"}})
+				return rtuFrame(17, res)
+			}
+			c := NewRtuClient(struct {
+				io.Reader
+				io.Writer
+			}{iotest.OneByteReader(port), port}, 17)
+			objects, err := c.ReadDeviceIdentification(DeviceIdBasic, 0)
+			So(err, ShouldBeNil)
+			So(objects, ShouldResemble, map[uint8]string{0: "acme", 1: "meter", 2: "v1.2"})
+		})
+	})
+
+	Convey("Given the beginning of a device identification response", t, func() {
+		res, _ := NewPdu(&ReadDeviceIdentificationResponse{DeviceIdBasic, 1, false, 0, map[uint8]string{0: "ab", 1: "c"}})
+		frame := rtuFrame(17, res)
+
+		Convey("the length should only be known after all objects", func() {
+			for i := 0; i < len(frame)-4; i++ {
+				l, err := rtuResponseLength(frame[:i])
+				So(err, ShouldBeNil)
+				So(l, ShouldEqual, 0)
+			}
+			l, err := rtuResponseLength(frame[:len(frame)-3])
+			So(err, ShouldBeNil)
+			So(l, ShouldEqual, len(frame))
+		})
+	})
+
+	Convey("Given a port without read deadlines", t, func() {
+		r, w := io.Pipe()
+		raw := struct {
+			*io.PipeReader
+			io.Writer
+		}{r, ioutil.Discard}
+		port := withReadDeadline(raw).(*deadlinePort)
+
+		Convey("rtu clients should time out", func() {
+			c := NewRtuClientTimeout(raw, 17, 10*time.Millisecond)
+			_, err := c.ReadCoils(0, 1)
+			So(err, ShouldHaveSameTypeAs, TimeoutError{})
+		})
+
+		Convey("ascii clients should time out", func() {
+			c := NewAsciiClientTimeout(raw, 17, 10*time.Millisecond)
+			_, err := c.ReadCoils(0, 1)
+			So(err, ShouldHaveSameTypeAs, TimeoutError{})
+		})
+
+		Convey("closing a transporter should close the port", func() {
+			So(NewRtuTransporter(raw, 17, 0).Close(), ShouldBeNil)
+			_, err := r.Read(make([]byte, 1))
+			So(err, ShouldEqual, io.ErrClosedPipe)
+		})
+
+		Convey("reads should time out", func() {
+			port.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+			_, err := port.Read(make([]byte, 4))
+			So(transportError("receive data", err), ShouldHaveSameTypeAs, TimeoutError{})
+
+			Convey("and the late data should be returned by the next read", func() {
+				go w.Write([]byte{1, 2, 3})
+				port.SetReadDeadline(time.Now().Add(time.Second))
+				b := make([]byte, 2)
+				n, err := port.Read(b)
+				So(err, ShouldBeNil)
+				So(b[:n], ShouldResemble, []byte{1, 2})
+				n, err = port.Read(b)
+				So(b[:n], ShouldResemble, []byte{3})
+			})
+		})
+	})
+}
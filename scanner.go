@@ -0,0 +1,209 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Discovery of units and implemented address ranges
+ */
+
+package modbus
+
+import (
+	"errors"
+)
+
+type UnitReport struct {
+	Unit uint8
+
+	// valid address ranges per table; tables answered with
+	// ILLEGAL FUNCTION are missing
+	Tables map[Table][]AddressRange
+}
+
+type ScanReport struct {
+	Units []*UnitReport
+}
+
+type Scanner struct {
+
+	// units to probe (default 1-247)
+	Units []uint8
+
+	// tables to scan (default all)
+	Tables []Table
+
+	// distance between the probed addresses (default 256); ranges that
+	// fit between two probes completely may be missed
+	Step uint16
+
+	transport Transporter
+}
+
+// NewScanner creates a scanner that probes the units reachable through
+// the given transporter. The transporter must be able to address other
+// units, i.e. one of the transporters of this package, optionally wrapped
+// by middlewares.
+func NewScanner(t Transporter) *Scanner {
+	return &Scanner{transport: t}
+}
+
+type probeResult int
+
+const (
+	probeValid probeResult = iota
+	probeInvalid
+	probeUnsupported
+	probeNoResponse
+)
+
+func (s *Scanner) probe(c Client, table Table, addr uint16) (probeResult, error) {
+	var err error
+	switch table {
+	case TableCoils:
+		_, err = c.ReadCoils(addr, 1)
+	case TableDiscreteInputs:
+		_, err = c.ReadDiscreteInputs(addr, 1)
+	case TableHoldingRegisters:
+		_, err = c.ReadHoldingRegisters(addr, 1)
+	case TableInputRegisters:
+		_, err = c.ReadInputRegisters(addr, 1)
+	default:
+		return probeUnsupported, nil
+	}
+	if err == nil {
+		return probeValid, nil
+	}
+	var timeout TimeoutError
+	switch {
+	case errors.Is(err, ErrIllegalFunction):
+		return probeUnsupported, nil
+	case errors.Is(err, ErrGatewayPathUnavailable), errors.Is(err, ErrGatewayTargetDeviceFailedToRespond):
+		return probeNoResponse, nil
+	case errors.As(err, &timeout):
+		return probeNoResponse, nil
+	}
+	var e Error
+	var r ResponseError
+	if errors.As(err, &e) || errors.As(err, &r) {
+		return probeInvalid, nil
+	}
+	return 0, err
+}
+
+// Scan probes all units and returns the ones that responded.
+func (s *Scanner) Scan() (*ScanReport, error) {
+	units := s.Units
+	if units == nil {
+		for u := 1; u <= 247; u++ {
+			units = append(units, uint8(u))
+		}
+	}
+	if !addressesUnits(s.transport) {
+		return nil, errNoUnits
+	}
+	report := &ScanReport{}
+	for _, u := range units {
+		r, err := s.ScanUnit(u)
+		if err != nil {
+			return report, err
+		}
+		if r != nil {
+			report.Units = append(report.Units, r)
+		}
+	}
+	return report, nil
+}
+
+// ScanUnit determines the valid address ranges of the given unit.
+// It returns nil if the unit does not respond.
+func (s *Scanner) ScanUnit(unit uint8) (*UnitReport, error) {
+	if !addressesUnits(s.transport) {
+		return nil, errNoUnits
+	}
+	c := NewClient(unitSender{s.transport, unit})
+	tables := s.Tables
+	if tables == nil {
+		tables = allTables
+	}
+	step := int(s.Step)
+	if step == 0 {
+		step = 256
+	}
+	report := &UnitReport{Unit: unit, Tables: map[Table][]AddressRange{}}
+	alive := false
+	for _, table := range tables {
+		first, err := s.probe(c, table, 0)
+		if err != nil {
+			return nil, err
+		}
+		switch first {
+		case probeNoResponse:
+			if !alive {
+				return nil, nil
+			}
+			continue
+		case probeUnsupported:
+			alive = true
+			continue
+		}
+		alive = true
+		ranges, err := s.scanTable(c, table, first == probeValid, step)
+		if err != nil {
+			return nil, err
+		}
+		report.Tables[table] = ranges
+	}
+	if !alive {
+		return nil, nil
+	}
+	return report, nil
+}
+
+// scanTable probes every step-th address and binary-searches the
+// boundaries between probes with different results.
+func (s *Scanner) scanTable(c Client, table Table, valid bool, step int) (ranges []AddressRange, err error) {
+	isValid := func(addr int) (bool, error) {
+		r, err := s.probe(c, table, uint16(addr))
+		return r == probeValid, err
+	}
+	start := -1
+	if valid {
+		start = 0
+	}
+	for prev := 0; prev < 0xffff; {
+		next := prev + step
+		if next > 0xffff {
+			next = 0xffff
+		}
+		v, err := isValid(next)
+		if err != nil {
+			return nil, err
+		}
+		if v != valid {
+			// find the first address in (prev, next] with the state of next
+			lo, hi := prev+1, next
+			for lo < hi {
+				mid := (lo + hi) / 2
+				m, err := isValid(mid)
+				if err != nil {
+					return nil, err
+				}
+				if m == v {
+					hi = mid
+				} else {
+					lo = mid + 1
+				}
+			}
+			if v {
+				start = lo
+			} else {
+				ranges = append(ranges, AddressRange{uint16(start), uint16(lo - 1)})
+				start = -1
+			}
+			valid = v
+		}
+		prev = next
+	}
+	if start >= 0 {
+		ranges = append(ranges, AddressRange{uint16(start), 0xffff})
+	}
+	return ranges, nil
+}
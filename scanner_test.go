@@ -0,0 +1,113 @@
+package modbus
+
+import (
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+// busTransporter dispatches requests to the handler of the addressed unit
+type busTransporter struct {
+	units    map[uint8]Handler
+	requests int
+}
+
+func (t *busTransporter) Connect() error {
+	return nil
+}
+
+func (t *busTransporter) Close() error {
+	return nil
+}
+
+func (t *busTransporter) Send(pdu *Pdu) (*Pdu, error) {
+	return t.sendTo(1, pdu)
+}
+
+func (t *busTransporter) sendTo(unit uint8, pdu *Pdu) (*Pdu, error) {
+	t.requests++
+	h, ok := t.units[unit]
+	if !ok {
+		return nil, TimeoutError{"receive data", errors.New("i/o timeout")}
+	}
+	return h.Handle(pdu), nil
+}
+
+// gapModel answers holding register reads within [100, 199] and [1000, 1099]
+// and rejects all other functions
+type gapModel struct{}
+
+func (gapModel) Handle(req *Pdu) *Pdu {
+	if req.Function != 3 {
+		return exceptionPdu(req.Function, ExceptionIllegalFunction)
+	}
+	addr := int(req.Data[0])<<8 | int(req.Data[1])
+	if (addr >= 100 && addr < 200) || (addr >= 1000 && addr < 1100) {
+		return &Pdu{3, []byte{2, 0, 0}}
+	}
+	return exceptionPdu(req.Function, ExceptionIllegalDataAddress)
+}
+
+func Test_Scanner(t *testing.T) {
+
+	Convey("Given a bus with two units", t, func() {
+		bus := &busTransporter{units: map[uint8]Handler{
+			3: NewDataModel(10, 0, 300, 65536),
+			7: gapModel{},
+		}}
+		s := NewScanner(bus)
+		s.Units = []uint8{1, 2, 3, 4, 5, 6, 7, 8}
+
+		Convey("only the responding units should be reported", func() {
+			r, err := s.Scan()
+			So(err, ShouldBeNil)
+			So(len(r.Units), ShouldEqual, 2)
+			So(r.Units[0].Unit, ShouldEqual, 3)
+			So(r.Units[1].Unit, ShouldEqual, 7)
+		})
+
+		Convey("the address ranges should be found", func() {
+			r, err := s.ScanUnit(3)
+			So(err, ShouldBeNil)
+			So(r.Tables, ShouldResemble, map[Table][]AddressRange{
+				TableCoils:            {{0, 9}},
+				TableDiscreteInputs:   nil,
+				TableHoldingRegisters: {{0, 65535}},
+				TableInputRegisters:   {{0, 299}},
+			})
+		})
+
+		Convey("gaps and unsupported tables should be detected", func() {
+			s.Step = 64
+			r, err := s.ScanUnit(7)
+			So(err, ShouldBeNil)
+			So(r.Tables, ShouldResemble, map[Table][]AddressRange{
+				TableHoldingRegisters: {{100, 199}, {1000, 1099}},
+			})
+		})
+
+		Convey("silent units should be skipped after one probe", func() {
+			r, err := s.ScanUnit(5)
+			So(err, ShouldBeNil)
+			So(r, ShouldBeNil)
+			So(bus.requests, ShouldEqual, 1)
+		})
+	})
+
+	Convey("Given a transporter that can only reach one unit", t, func() {
+		s := NewScanner(&handlerTransporter{NewDataModel(1, 1, 1, 1)})
+
+		Convey("scanning all units should fail", func() {
+			_, err := s.Scan()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("scanning a single unit should fail as well", func() {
+			s.Units = []uint8{1}
+			_, err := s.Scan()
+			So(err, ShouldEqual, errNoUnits)
+			_, err = s.ScanUnit(1)
+			So(err, ShouldEqual, errNoUnits)
+		})
+	})
+}
@@ -0,0 +1,235 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Serial line configuration and port backends
+ */
+
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+type Parity byte
+
+const (
+	// even parity is the Modbus default
+	ParityEven Parity = 'E'
+	ParityOdd  Parity = 'O'
+	ParityNone Parity = 'N'
+)
+
+// RS485Config controls the transmitter of RS-485 line drivers by the RTS
+// signal.
+type RS485Config struct {
+	Enabled bool
+
+	// RTS level while sending and after sending
+	RtsHighDuringSend bool
+	RtsHighAfterSend  bool
+
+	DelayBeforeSend time.Duration
+	DelayAfterSend  time.Duration
+}
+
+type SerialConfig struct {
+
+	// device name, e.g. "/dev/ttyUSB0"
+	Address string
+
+	BaudRate int
+
+	// 7 or 8 (default 8)
+	DataBits int
+
+	// default even
+	Parity Parity
+
+	// 1 or 2 (default 1, or 2 without parity)
+	StopBits int
+
+	RS485 RS485Config
+
+	// maximum time to wait for a response (0 waits forever)
+	Timeout time.Duration
+
+	// opens the port (default DefaultBackend)
+	Backend PortBackend
+}
+
+func (c *SerialConfig) parity() Parity {
+	if c.Parity == 0 {
+		return ParityEven
+	}
+	return c.Parity
+}
+
+func (c *SerialConfig) dataBits() int {
+	if c.DataBits == 0 {
+		return 8
+	}
+	return c.DataBits
+}
+
+func (c *SerialConfig) stopBits() int {
+	switch {
+	case c.StopBits != 0:
+		return c.StopBits
+	case c.parity() == ParityNone:
+		return 2
+	}
+	return 1
+}
+
+// SerialTiming are the character timings of a serial line.
+type SerialTiming struct {
+
+	// transmission time of one character
+	Char time.Duration
+
+	// maximum silence between the characters of a frame; the framing of
+	// this package tolerates up to T35 since drivers and USB adapters
+	// deliver the characters in bursts
+	T15 time.Duration
+
+	// minimum silence between frames
+	T35 time.Duration
+}
+
+// Timing derives the timings from the configuration. Above 19200 baud
+// fixed values of 750µs and 1.75ms are used as recommended by the
+// Modbus serial line specification. Without a valid baud rate all
+// timings are zero.
+func (c *SerialConfig) Timing() SerialTiming {
+	if c.BaudRate <= 0 {
+		return SerialTiming{}
+	}
+	bits := 1 + c.dataBits() + c.stopBits()
+	if c.parity() != ParityNone {
+		bits++
+	}
+	char := time.Duration(int64(bits) * int64(time.Second) / int64(c.BaudRate))
+	if c.BaudRate > 19200 {
+		return SerialTiming{char, 750 * time.Microsecond, 1750 * time.Microsecond}
+	}
+	return SerialTiming{char, 3 * char / 2, 7 * char / 2}
+}
+
+func (c *SerialConfig) validate() error {
+	if c.BaudRate <= 0 {
+		return fmt.Errorf("Invalid baud rate: %d", c.BaudRate)
+	}
+	if d := c.dataBits(); d != 7 && d != 8 {
+		return fmt.Errorf("Invalid number of data bits: %d", d)
+	}
+	if p := c.parity(); p != ParityEven && p != ParityOdd && p != ParityNone {
+		return fmt.Errorf("Invalid parity: %q", byte(p))
+	}
+	if s := c.stopBits(); s != 1 && s != 2 {
+		return fmt.Errorf("Invalid number of stop bits: %d", s)
+	}
+	return nil
+}
+
+// PortBackend opens serial ports.
+type PortBackend interface {
+	Open(c *SerialConfig) (io.ReadWriteCloser, error)
+}
+
+// DefaultBackend is the termios backend on Linux and nil elsewhere.
+var DefaultBackend PortBackend
+
+// OpenPort opens the serial port of the configuration.
+func OpenPort(c *SerialConfig) (io.ReadWriteCloser, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	b := c.Backend
+	if b == nil {
+		b = DefaultBackend
+	}
+	if b == nil {
+		return nil, errors.New("No serial port backend available")
+	}
+	return b.Open(c)
+}
+
+// Loopback connects the ports that are opened with the same address,
+// e.g. a client and a server in tests. Timeouts are supported.
+type Loopback struct {
+	mu    sync.Mutex
+	peers map[string]net.Conn
+}
+
+func NewLoopback() *Loopback {
+	return &Loopback{peers: map[string]net.Conn{}}
+}
+
+// Open returns one end of a line if the address is opened for the first
+// time and the other end the second time.
+func (l *Loopback) Open(c *SerialConfig) (io.ReadWriteCloser, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if peer, ok := l.peers[c.Address]; ok {
+		delete(l.peers, c.Address)
+		return peer, nil
+	}
+	a, b := net.Pipe()
+	l.peers[c.Address] = b
+	return a, nil
+}
+
+// OpenRtuTransporter opens the port of the configuration. Requests are
+// preceded by the silent interval t3.5.
+func OpenRtuTransporter(c *SerialConfig, slave uint8) (Transporter, error) {
+	port, err := OpenPort(c)
+	if err != nil {
+		return nil, err
+	}
+	return &rtuTransporter{port: port, slave: slave, timeout: c.Timeout, gap: c.Timing().T35}, nil
+}
+
+func OpenAsciiTransporter(c *SerialConfig, slave uint8) (Transporter, error) {
+	port, err := OpenPort(c)
+	if err != nil {
+		return nil, err
+	}
+	return NewAsciiTransporter(port, slave, c.Timeout), nil
+}
+
+func OpenRtuClient(c *SerialConfig, slave uint8, mw ...Middleware) (SerialClient, error) {
+	t, err := OpenRtuTransporter(c, slave)
+	if err != nil {
+		return nil, err
+	}
+	return &mbClient{Chain(t, mw...)}, nil
+}
+
+func OpenAsciiClient(c *SerialConfig, slave uint8, mw ...Middleware) (SerialClient, error) {
+	t, err := OpenAsciiTransporter(c, slave)
+	if err != nil {
+		return nil, err
+	}
+	return &mbClient{Chain(t, mw...)}, nil
+}
+
+func OpenRtuServer(c *SerialConfig) (*RtuServer, error) {
+	port, err := OpenPort(c)
+	if err != nil {
+		return nil, err
+	}
+	return newRtuServer(port, c.Timing()), nil
+}
+
+func OpenAsciiServer(c *SerialConfig) (*AsciiServer, error) {
+	port, err := OpenPort(c)
+	if err != nil {
+		return nil, err
+	}
+	return NewAsciiServer(port), nil
+}
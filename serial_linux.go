@@ -0,0 +1,157 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Linux termios port backend
+ */
+
+package modbus
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func init() {
+	DefaultBackend = TermiosBackend{}
+}
+
+var baudRates = map[int]uint32{
+	1200:   syscall.B1200,
+	2400:   syscall.B2400,
+	4800:   syscall.B4800,
+	9600:   syscall.B9600,
+	19200:  syscall.B19200,
+	38400:  syscall.B38400,
+	57600:  syscall.B57600,
+	115200: syscall.B115200,
+	230400: syscall.B230400,
+	460800: syscall.B460800,
+	921600: syscall.B921600,
+}
+
+// serialRS485 is struct serial_rs485 of linux/serial.h.
+type serialRS485 struct {
+	flags              uint32
+	delayRtsBeforeSend uint32
+	delayRtsAfterSend  uint32
+	padding            [5]uint32
+}
+
+const (
+	rs485Enabled      = 1 << 0
+	rs485RtsOnSend    = 1 << 1
+	rs485RtsAfterSend = 1 << 2
+)
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// configure puts the terminal into raw mode with the line settings of c.
+func configure(f *os.File, c *SerialConfig) error {
+	speed, ok := baudRates[c.BaudRate]
+	if !ok {
+		return fmt.Errorf("Unsupported baud rate: %d", c.BaudRate)
+	}
+	var t termios
+	if err := ioctl(f, syscall.TCGETS, unsafe.Pointer(&t)); err != nil {
+		return err
+	}
+	t.iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON | syscall.IXOFF
+	t.oflag &^= syscall.OPOST
+	t.lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.cflag &^= syscall.CSIZE | syscall.PARENB | syscall.PARODD | syscall.CSTOPB
+	t.cflag |= syscall.CREAD | syscall.CLOCAL
+	t.setSpeed(speed)
+	if c.dataBits() == 7 {
+		t.cflag |= syscall.CS7
+	} else {
+		t.cflag |= syscall.CS8
+	}
+	switch c.parity() {
+	case ParityEven:
+		t.cflag |= syscall.PARENB
+	case ParityOdd:
+		t.cflag |= syscall.PARENB | syscall.PARODD
+	}
+	if c.stopBits() == 2 {
+		t.cflag |= syscall.CSTOPB
+	}
+	t.cc[syscall.VMIN], t.cc[syscall.VTIME] = 1, 0
+	return ioctl(f, syscall.TCSETS, unsafe.Pointer(&t))
+}
+
+func configureRS485(f *os.File, c *RS485Config) error {
+	rs := serialRS485{
+		flags:              rs485Enabled,
+		delayRtsBeforeSend: uint32(c.DelayBeforeSend.Milliseconds()),
+		delayRtsAfterSend:  uint32(c.DelayAfterSend.Milliseconds()),
+	}
+	if c.RtsHighDuringSend {
+		rs.flags |= rs485RtsOnSend
+	}
+	if c.RtsHighAfterSend {
+		rs.flags |= rs485RtsAfterSend
+	}
+	return ioctl(f, tiocsrs485, unsafe.Pointer(&rs))
+}
+
+// TermiosBackend opens serial devices on Linux.
+type TermiosBackend struct{}
+
+func (TermiosBackend) Open(c *SerialConfig) (io.ReadWriteCloser, error) {
+	f, err := os.OpenFile(c.Address, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := configure(f, c); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if c.RS485.Enabled {
+		if err := configureRS485(f, &c.RS485); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// OpenPty opens a pseudo terminal pair. The slave side is configured like
+// a serial port, so it can be passed to a server while a client uses the
+// master side (or the other way round).
+func OpenPty(c *SerialConfig) (master, slave *os.File, err error) {
+	if err := c.validate(); err != nil {
+		return nil, nil, err
+	}
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var n uint32
+	var unlock int32
+	if err = ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&n)); err == nil {
+		err = ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock))
+	}
+	if err == nil {
+		slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	}
+	if err == nil {
+		err = configure(slave, c)
+	}
+	if err != nil {
+		master.Close()
+		if slave != nil {
+			slave.Close()
+		}
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
@@ -0,0 +1,35 @@
+//go:build linux
+// +build linux
+
+package modbus
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func Test_RtuServerPty(t *testing.T) {
+
+	Convey("Given a rtu server on a pty", t, func() {
+		master, slave, err := OpenPty(&SerialConfig{BaudRate: 9600})
+		if err != nil {
+			t.Skip("no pty available:", err)
+		}
+		defer master.Close()
+		s := NewRtuServer(slave, 9600)
+		m := NewDataModel(10, 0, 0, 10)
+		s.SetUnitHandler(5, m)
+		s.Start()
+		defer s.Stop()
+
+		Convey("a rtu client should read and write its registers", func() {
+			c := NewRtuClientTimeout(master, 5, time.Second)
+			So(c.WriteMultipleRegisters(2, []uint16{0x0d0a, 0x0300}), ShouldBeNil)
+			values, err := c.ReadHoldingRegisters(2, 2)
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []uint16{0x0d0a, 0x0300})
+			So(c.WriteSingleCoil(1, true), ShouldBeNil)
+		})
+	})
+}
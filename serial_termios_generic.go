@@ -0,0 +1,26 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le && !ppc64 && !ppc64le
+// +build linux,!mips,!mipsle,!mips64,!mips64le,!ppc64,!ppc64le
+
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Linux termios layout of most architectures
+ */
+
+package modbus
+
+const (
+	cbaud      = 0x100f
+	tiocsrs485 = 0x542f
+)
+
+// termios is struct termios of asm-generic/termbits.h.
+type termios struct {
+	iflag, oflag, cflag, lflag uint32
+	line                       uint8
+	cc                         [19]uint8
+}
+
+func (t *termios) setSpeed(speed uint32) {
+	t.cflag = t.cflag&^cbaud | speed
+}
@@ -0,0 +1,27 @@
+//go:build linux && (mips || mipsle || mips64 || mips64le)
+// +build linux
+// +build mips mipsle mips64 mips64le
+
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Linux termios layout of MIPS
+ */
+
+package modbus
+
+const (
+	cbaud      = 0x100f
+	tiocsrs485 = 0xc020542f
+)
+
+// termios is struct termios of arch/mips/include/uapi/asm/termbits.h.
+type termios struct {
+	iflag, oflag, cflag, lflag uint32
+	line                       uint8
+	cc                         [23]uint8
+}
+
+func (t *termios) setSpeed(speed uint32) {
+	t.cflag = t.cflag&^cbaud | speed
+}
@@ -0,0 +1,29 @@
+//go:build linux && (ppc64 || ppc64le)
+// +build linux
+// +build ppc64 ppc64le
+
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Linux termios layout of PowerPC
+ */
+
+package modbus
+
+const (
+	cbaud      = 0xff
+	tiocsrs485 = 0x542f
+)
+
+// termios is struct termios of arch/powerpc/include/uapi/asm/termbits.h.
+type termios struct {
+	iflag, oflag, cflag, lflag uint32
+	cc                         [19]uint8
+	line                       uint8
+	ispeed, ospeed             uint32
+}
+
+func (t *termios) setSpeed(speed uint32) {
+	t.cflag = t.cflag&^cbaud | speed
+	t.ispeed, t.ospeed = speed, speed
+}
@@ -0,0 +1,80 @@
+package modbus
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func Test_SerialConfig(t *testing.T) {
+
+	Convey("Given serial configurations", t, func() {
+
+		Convey("the character time should depend on the frame format", func() {
+			So((&SerialConfig{BaudRate: 9600}).Timing().Char, ShouldEqual, 11*time.Second/9600)
+			So((&SerialConfig{BaudRate: 9600, Parity: ParityNone}).Timing().Char, ShouldEqual, 11*time.Second/9600)
+			So((&SerialConfig{BaudRate: 9600, Parity: ParityNone, StopBits: 1}).Timing().Char, ShouldEqual, 10*time.Second/9600)
+			So((&SerialConfig{BaudRate: 9600, DataBits: 7, Parity: ParityOdd}).Timing().Char, ShouldEqual, 10*time.Second/9600)
+		})
+
+		Convey("t1.5 and t3.5 should be derived from the character time", func() {
+			t := (&SerialConfig{BaudRate: 19200}).Timing()
+			So(t.T15, ShouldEqual, 3*t.Char/2)
+			So(t.T35, ShouldEqual, 7*t.Char/2)
+		})
+
+		Convey("fixed timings should be used above 19200 baud", func() {
+			t := (&SerialConfig{BaudRate: 115200}).Timing()
+			So(t.T15, ShouldEqual, 750*time.Microsecond)
+			So(t.T35, ShouldEqual, 1750*time.Microsecond)
+		})
+
+		Convey("the timings should be zero without a baud rate", func() {
+			So((&SerialConfig{}).Timing(), ShouldResemble, SerialTiming{})
+			So(NewSniffer(0), ShouldNotBeNil)
+			So(NewRtuServer(nil, 0).Turnaround, ShouldEqual, 0)
+		})
+
+		Convey("invalid configurations should be rejected", func() {
+			_, err := OpenPort(&SerialConfig{Backend: NewLoopback()})
+			So(err, ShouldNotBeNil)
+			_, err = OpenPort(&SerialConfig{BaudRate: 9600, DataBits: 5, Backend: NewLoopback()})
+			So(err, ShouldNotBeNil)
+			_, err = OpenPort(&SerialConfig{BaudRate: 9600, Parity: 'X', Backend: NewLoopback()})
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a loopback line", t, func() {
+		line := NewLoopback()
+		conf := &SerialConfig{Address: "bus", BaudRate: 115200, Timeout: time.Second, Backend: line}
+
+		Convey("rtu clients and servers should talk over it", func() {
+			s, err := OpenRtuServer(conf)
+			So(err, ShouldBeNil)
+			s.SetUnitHandler(3, NewDataModel(0, 0, 0, 10))
+			s.Start()
+			defer s.Stop()
+			c, err := OpenRtuClient(conf, 3)
+			So(err, ShouldBeNil)
+			So(c.WriteSingleRegister(1, 42), ShouldBeNil)
+			start := time.Now()
+			values, err := c.ReadHoldingRegisters(1, 1)
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []uint16{42})
+			// frames are separated by t3.5 twice: before the request and the response
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 2*conf.Timing().T35)
+		})
+
+		Convey("ascii clients and servers should talk over it", func() {
+			s, err := OpenAsciiServer(conf)
+			So(err, ShouldBeNil)
+			s.SetUnitHandler(3, NewDataModel(0, 0, 0, 10))
+			s.Start()
+			defer s.Stop()
+			c, err := OpenAsciiClient(conf, 3)
+			So(err, ShouldBeNil)
+			So(c.WriteSingleRegister(1, 42), ShouldBeNil)
+		})
+	})
+}
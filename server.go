@@ -0,0 +1,166 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Request metadata and unit routing of servers
+ */
+
+package modbus
+
+import (
+	"net"
+	"sync"
+)
+
+// Request is a request received by a server together with its metadata.
+type Request struct {
+	Unit       uint8
+	RemoteAddr net.Addr
+	Transport  Framing
+
+	// role of the client certificate on Modbus/TCP Security connections
+	Role string
+
+	Pdu *Pdu
+}
+
+// RequestHandler is implemented by handlers that need the metadata of a
+// request. Servers prefer it over Handle.
+type RequestHandler interface {
+	ServeModbus(r *Request) (res *Pdu)
+}
+
+// HandlerFunc adapts a function to a Handler and a RequestHandler.
+type HandlerFunc func(r *Request) *Pdu
+
+func (f HandlerFunc) Handle(req *Pdu) *Pdu {
+	return f(&Request{Pdu: req})
+}
+
+func (f HandlerFunc) ServeModbus(r *Request) *Pdu {
+	return f(r)
+}
+
+// UnknownUnit defines how requests to units without a handler are answered.
+type UnknownUnit uint8
+
+const (
+	// answer with GATEWAY PATH UNAVAILABLE (default)
+	UnknownUnitPathUnavailable UnknownUnit = iota
+	// answer with GATEWAY TARGET DEVICE FAILED TO RESPOND
+	UnknownUnitTargetFailed
+	// do not answer at all, like a missing device on a serial line
+	UnknownUnitSilent
+)
+
+// units routes requests to the handlers of their unit ids.
+type units struct {
+	mu       sync.RWMutex
+	handler  Handler
+	handlers map[uint8]Handler
+	unknown  UnknownUnit
+
+	// only units with an own handler are served; a slave on a serial
+	// line must not answer the addresses of the other devices
+	explicit bool
+}
+
+// SetHandler sets the handler for all units without an own handler.
+// Serial servers only answer the units set by SetUnitHandler.
+func (u *units) SetHandler(h Handler) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.handler = h
+}
+
+// SetUnitHandler sets the handler for requests to the given unit id.
+// A nil handler removes it.
+func (u *units) SetUnitHandler(unit uint8, h Handler) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.handlers == nil {
+		u.handlers = map[uint8]Handler{}
+	}
+	if h == nil {
+		delete(u.handlers, unit)
+	} else {
+		u.handlers[unit] = h
+	}
+}
+
+// SetUnknownUnit sets the response to requests of units without a handler.
+func (u *units) SetUnknownUnit(policy UnknownUnit) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.unknown = policy
+}
+
+// serve answers the request by the handler of its unit. It returns nil if
+// the request should not be answered.
+func (u *units) serve(r *Request) *Pdu {
+	u.mu.RLock()
+	h, ok := u.handlers[r.Unit]
+	if !ok && !u.explicit {
+		h = u.handler
+	}
+	unknown := u.unknown
+	u.mu.RUnlock()
+	if h == nil {
+		switch unknown {
+		case UnknownUnitSilent:
+			return nil
+		case UnknownUnitTargetFailed:
+			return exceptionPdu(r.Pdu.Function, ExceptionGatewayTargetDeviceFailedToRespond)
+		}
+		return exceptionPdu(r.Pdu.Function, ExceptionGatewayPathUnavailable)
+	}
+	return serveRequest(h, r)
+}
+
+// broadcast passes the request to every handler. Their responses are
+// dropped.
+func (u *units) broadcast(r *Request) {
+	u.mu.RLock()
+	handlers := make([]Handler, 0, len(u.handlers)+1)
+	for _, h := range u.handlers {
+		handlers = append(handlers, h)
+	}
+	if u.handler != nil && !u.explicit {
+		handlers = append(handlers, u.handler)
+	}
+	u.mu.RUnlock()
+	for _, h := range handlers {
+		serveRequest(h, r)
+	}
+}
+
+// background serves a serial server in the background and keeps the
+// error that stopped it.
+type background struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (b *background) start(serve func() error) {
+	go func() {
+		err := serve()
+		b.mu.Lock()
+		b.err = err
+		b.mu.Unlock()
+	}()
+}
+
+// Err returns the error that stopped the server started by Start, e.g.
+// a failed read of the port. It is nil while the server is running.
+func (b *background) Err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// serveRequest passes the request to h with its metadata if supported.
+func serveRequest(h Handler, r *Request) *Pdu {
+	if rh, ok := h.(RequestHandler); ok {
+		return rh.ServeModbus(r)
+	}
+	return h.Handle(r.Pdu)
+}
@@ -0,0 +1,51 @@
+package modbus
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func Test_Units(t *testing.T) {
+
+	Convey("Given virtual devices", t, func() {
+		u := &units{}
+		a := NewDataModel(0, 0, 0, 1)
+		a.WriteHoldingRegisters(0, []uint16{1})
+		b := NewDataModel(0, 0, 0, 1)
+		b.WriteHoldingRegisters(0, []uint16{2})
+		u.SetUnitHandler(1, a)
+		u.SetUnitHandler(2, b)
+		read := &Pdu{3, []byte{0, 0, 0, 1}}
+
+		Convey("requests should be answered by the device of their unit", func() {
+			So(u.serve(&Request{Unit: 1, Pdu: read}), ShouldResemble, &Pdu{3, []byte{2, 0, 1}})
+			So(u.serve(&Request{Unit: 2, Pdu: read}), ShouldResemble, &Pdu{3, []byte{2, 0, 2}})
+		})
+
+		Convey("unknown units should be answered as configured", func() {
+			So(u.serve(&Request{Unit: 3, Pdu: read}), ShouldResemble, &Pdu{0x83, []byte{ExceptionGatewayPathUnavailable}})
+			u.SetUnknownUnit(UnknownUnitTargetFailed)
+			So(u.serve(&Request{Unit: 3, Pdu: read}), ShouldResemble, &Pdu{0x83, []byte{ExceptionGatewayTargetDeviceFailedToRespond}})
+			u.SetUnknownUnit(UnknownUnitSilent)
+			So(u.serve(&Request{Unit: 3, Pdu: read}), ShouldBeNil)
+		})
+
+		Convey("the default handler should answer unknown units", func() {
+			u.SetHandler(b)
+			So(u.serve(&Request{Unit: 3, Pdu: read}), ShouldResemble, &Pdu{3, []byte{2, 0, 2}})
+			u.SetUnitHandler(1, nil)
+			So(u.serve(&Request{Unit: 1, Pdu: read}), ShouldResemble, &Pdu{3, []byte{2, 0, 2}})
+		})
+
+		Convey("request handlers should get the metadata", func() {
+			var got *Request
+			u.SetUnitHandler(4, HandlerFunc(func(r *Request) *Pdu {
+				got = r
+				return nil
+			}))
+			r := &Request{Unit: 4, Transport: FramingRTU, Pdu: read}
+			So(u.serve(r), ShouldBeNil)
+			So(got, ShouldEqual, r)
+		})
+	})
+}
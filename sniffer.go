@@ -0,0 +1,182 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Passive RTU bus sniffer
+ */
+
+package modbus
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// Transaction is a request observed on the bus together with its response.
+// Request is nil for responses without a preceding request and Response
+// is nil for unanswered or broadcast requests.
+type Transaction struct {
+	Request      *Frame
+	Response     *Frame
+	RequestTime  time.Time
+	ResponseTime time.Time
+}
+
+type Sniffer struct {
+
+	// maximum time between request and response (default 1s)
+	Timeout time.Duration
+
+	// number of bytes that could not be assigned to a frame
+	Dropped int
+
+	charTime time.Duration
+	gap      time.Duration
+	buff     []byte
+	last     time.Time
+
+	pending     *Frame
+	pendingTime time.Time
+	out         []*Transaction
+}
+
+// NewSniffer creates a sniffer for a bus with the given baud rate and 8E1
+// characters. Frames are separated by silent intervals (t3.5) and by
+// their checksums; with a baud rate of 0 only by their checksums.
+func NewSniffer(baudRate int) *Sniffer {
+	t := (&SerialConfig{BaudRate: baudRate}).Timing()
+	return &Sniffer{Timeout: time.Second, charTime: t.Char, gap: t.T35}
+}
+
+// Feed passes bytes received at t to the sniffer and returns the
+// completed transactions. t is the time the last byte was received.
+func (s *Sniffer) Feed(t time.Time, data []byte) []*Transaction {
+	start := t.Add(-time.Duration(len(data)) * s.charTime)
+	if len(s.buff) > 0 && s.gap > 0 && start.Sub(s.last) >= s.gap {
+		// the rest of an incomplete or corrupted frame
+		s.Dropped += len(s.buff)
+		s.buff = nil
+	}
+	s.last = t
+	s.buff = append(s.buff, data...)
+	s.split(t)
+	if s.pending != nil && t.Sub(s.pendingTime) > s.Timeout {
+		s.emit(&Transaction{Request: s.pending, RequestTime: s.pendingTime})
+	}
+	out := s.out
+	s.out = nil
+	return out
+}
+
+// Flush returns the pending request and drops incomplete frames.
+func (s *Sniffer) Flush() []*Transaction {
+	s.Dropped += len(s.buff)
+	s.buff = nil
+	if s.pending != nil {
+		s.emit(&Transaction{Request: s.pending, RequestTime: s.pendingTime})
+	}
+	out := s.out
+	s.out = nil
+	return out
+}
+
+// Sniff reads from r until it fails and passes every transaction to fn.
+func (s *Sniffer) Sniff(r io.Reader, fn func(*Transaction)) error {
+	buff := make([]byte, rtuMaxLength)
+	for {
+		n, err := r.Read(buff)
+		if n > 0 {
+			for _, t := range s.Feed(time.Now(), buff[:n]) {
+				fn(t)
+			}
+		}
+		if err != nil {
+			for _, t := range s.Flush() {
+				fn(t)
+			}
+			return err
+		}
+	}
+}
+
+func validCRC(frame []byte) bool {
+	l := len(frame)
+	return l >= rtuMinLength && binary.LittleEndian.Uint16(frame[l-2:]) == crc16(frame[:l-2])
+}
+
+// frameLength returns the length of the frame at the beginning of b or 0
+// if b does not start with a complete frame.
+func frameLength(b []byte) int {
+	known := false
+	for _, length := range []func([]byte) (int, error){rtuRequestLength, rtuResponseLength} {
+		l, err := length(b)
+		if err != nil {
+			continue
+		}
+		known = true
+		if l > 0 && l <= len(b) && validCRC(b[:l]) {
+			return l
+		}
+	}
+	if !known {
+		// unknown function codes can only be split by their checksum
+		for l := rtuMinLength; l <= len(b) && l <= rtuMaxLength; l++ {
+			if validCRC(b[:l]) {
+				return l
+			}
+		}
+	}
+	return 0
+}
+
+func (s *Sniffer) split(t time.Time) {
+	for {
+		l := frameLength(s.buff)
+		if l == 0 {
+			if len(s.buff) > rtuMaxLength {
+				s.Dropped++
+				s.buff = s.buff[1:]
+				continue
+			}
+			return
+		}
+		f, err := Decode(s.buff[:l], FramingRTU)
+		s.buff = s.buff[l:]
+		if err != nil {
+			s.Dropped += l
+			continue
+		}
+		s.frame(f, t)
+	}
+}
+
+func (s *Sniffer) isResponse(f *Frame) bool {
+	req := s.pending
+	return req != nil && f.Unit == req.Unit && f.Direction != DirectionRequest &&
+		(f.Pdu.Function == req.Pdu.Function || f.Pdu.Function == req.Pdu.Function|0x80)
+}
+
+func (s *Sniffer) frame(f *Frame, t time.Time) {
+	if s.isResponse(f) && t.Sub(s.pendingTime) <= s.Timeout {
+		s.emit(&Transaction{s.pending, f, s.pendingTime, t})
+		return
+	}
+	if s.pending != nil {
+		s.emit(&Transaction{Request: s.pending, RequestTime: s.pendingTime})
+	}
+	switch {
+	case f.Direction == DirectionResponse:
+		s.emit(&Transaction{Response: f, ResponseTime: t})
+	case f.Unit == 0:
+		s.emit(&Transaction{Request: f, RequestTime: t})
+	default:
+		s.pending, s.pendingTime = f, t
+	}
+}
+
+func (s *Sniffer) emit(t *Transaction) {
+	if t.Request != nil && t.Request == s.pending {
+		s.pending = nil
+	}
+	s.out = append(s.out, t)
+}
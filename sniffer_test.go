@@ -0,0 +1,114 @@
+package modbus
+
+import (
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"testing"
+	"time"
+)
+
+func Test_Sniffer(t *testing.T) {
+
+	req := rtuFrame(0x11, &Pdu{3, []byte{0x00, 0x6b, 0x00, 0x03}})
+	res := rtuFrame(0x11, &Pdu{3, []byte{0x06, 0x02, 0x2b, 0x00, 0x00, 0x00, 0x64}})
+	exc := rtuFrame(0x11, &Pdu{0x83, []byte{0x02}})
+	start := time.Unix(0, 0)
+	at := func(ms float64) time.Time {
+		return start.Add(time.Duration(ms * float64(time.Millisecond)))
+	}
+
+	Convey("Given a sniffer without a baud rate", t, func() {
+		s := NewSniffer(0)
+
+		Convey("frames should only be split by their checksums", func() {
+			So(s.Feed(at(5), req[:3]), ShouldBeEmpty)
+			So(s.Feed(at(100), req[3:]), ShouldBeEmpty)
+			tx := s.Feed(at(200), res)
+			So(len(tx), ShouldEqual, 1)
+			So(s.Dropped, ShouldEqual, 0)
+		})
+	})
+
+	Convey("Given a sniffer on a 9600 baud line", t, func() {
+		s := NewSniffer(9600)
+
+		Convey("frames split into chunks should be paired", func() {
+			So(s.Feed(at(5), req[:3]), ShouldBeEmpty)
+			So(s.Feed(at(10), req[3:]), ShouldBeEmpty)
+			So(s.Feed(at(30), res[:6]), ShouldBeEmpty)
+			tx := s.Feed(at(36), res[6:])
+			So(len(tx), ShouldEqual, 1)
+			So(tx[0].Request.Fields, ShouldResemble, []Field{{"address", "107"}, {"quantity", "3"}})
+			So(tx[0].Response.Fields[1], ShouldResemble, Field{"values", "555 0 100"})
+			So(tx[0].ResponseTime.Sub(tx[0].RequestTime), ShouldEqual, 26*time.Millisecond)
+		})
+
+		Convey("frames without a gap should be split by their checksums", func() {
+			tx := s.Feed(at(20), append(append([]byte{}, req...), exc...))
+			So(len(tx), ShouldEqual, 1)
+			So(tx[0].Response.Fields, ShouldResemble, []Field{{"exception", "2 (ILLEGAL DATA ADDRESS)"}})
+		})
+
+		Convey("noise should be dropped after a silent interval", func() {
+			So(s.Feed(at(1), []byte{0x00, 0xff}), ShouldBeEmpty)
+			So(s.Feed(at(20), req), ShouldBeEmpty)
+			tx := s.Feed(at(40), res)
+			So(len(tx), ShouldEqual, 1)
+			So(tx[0].Request, ShouldNotBeNil)
+			So(s.Dropped, ShouldEqual, 2)
+		})
+
+		Convey("device identifications should be split without a gap", func() {
+			devReq := rtuFrame(0x11, &Pdu{43, []byte{0x0e, DeviceIdBasic, 0}})
+			pdu, _ := NewPdu(&ReadDeviceIdentificationResponse{DeviceIdBasic, 1, false, 0, map[uint8]string{0: "acme", 1: "meter"}})
+			devRes := rtuFrame(0x11, pdu)
+			stream := append(append(append([]byte{}, devReq...), devRes...), req...)
+			tx := s.Feed(at(10), stream)
+			So(len(tx), ShouldEqual, 1)
+			So(tx[0].Response.Pdu.Function, ShouldEqual, 43)
+			tx = s.Feed(at(40), res)
+			So(len(tx), ShouldEqual, 1)
+			So(tx[0].Response.Pdu.Function, ShouldEqual, 3)
+			So(s.Dropped, ShouldEqual, 0)
+		})
+
+		Convey("unanswered requests should be reported", func() {
+			So(s.Feed(at(10), req), ShouldBeEmpty)
+			tx := s.Feed(at(2000), req)
+			So(len(tx), ShouldEqual, 1)
+			So(tx[0].Response, ShouldBeNil)
+			tx = s.Flush()
+			So(len(tx), ShouldEqual, 1)
+			So(tx[0].RequestTime, ShouldResemble, at(2000))
+		})
+
+		Convey("broadcasts should not wait for a response", func() {
+			tx := s.Feed(at(10), rtuFrame(0, &Pdu{6, []byte{0, 1, 0, 1}}))
+			So(len(tx), ShouldEqual, 1)
+			So(tx[0].Request.Unit, ShouldEqual, 0)
+		})
+
+		Convey("responses of other units should not be paired", func() {
+			s.Feed(at(10), req)
+			tx := s.Feed(at(30), rtuFrame(0x12, &Pdu{3, []byte{0x02, 0x00, 0x01}}))
+			So(len(tx), ShouldEqual, 2)
+			So(tx[0].Response, ShouldBeNil)
+			So(tx[1].Request, ShouldBeNil)
+			So(tx[1].Response.Unit, ShouldEqual, 0x12)
+		})
+
+		Convey("a recorded stream should be sniffed until it ends", func() {
+			var stream bytes.Buffer
+			stream.Write(req)
+			stream.Write(res)
+			var tx []*Transaction
+			err := s.Sniff(&stream, func(t *Transaction) {
+				tx = append(tx, t)
+			})
+			So(err, ShouldEqual, io.EOF)
+			So(len(tx), ShouldEqual, 1)
+			So(tx[0].Response, ShouldNotBeNil)
+		})
+	})
+}
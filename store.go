@@ -0,0 +1,355 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Persistence of data models
+ */
+
+package modbus
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// Snapshot is the content of the data tables of a data model.
+type Snapshot struct {
+	Coils            []bool   `json:"coils"`
+	DiscreteInputs   []bool   `json:"discrete_inputs"`
+	InputRegisters   []uint16 `json:"input_registers"`
+	HoldingRegisters []uint16 `json:"holding_registers"`
+}
+
+// Change is a write to the coils or holding registers.
+type Change struct {
+	Table     Table    `json:"table"`
+	Address   uint16   `json:"address"`
+	Bits      []bool   `json:"bits,omitempty"`
+	Registers []uint16 `json:"registers,omitempty"`
+}
+
+// apply writes the change to the snapshot and grows its tables if needed.
+func (s *Snapshot) apply(c Change) {
+	switch c.Table {
+	case TableCoils:
+		if end := int(c.Address) + len(c.Bits); end > len(s.Coils) {
+			s.Coils = append(s.Coils, make([]bool, end-len(s.Coils))...)
+		}
+		copy(s.Coils[c.Address:], c.Bits)
+	case TableHoldingRegisters:
+		if end := int(c.Address) + len(c.Registers); end > len(s.HoldingRegisters) {
+			s.HoldingRegisters = append(s.HoldingRegisters, make([]uint16, end-len(s.HoldingRegisters))...)
+		}
+		copy(s.HoldingRegisters[c.Address:], c.Registers)
+	}
+}
+
+func (s *Snapshot) copy() *Snapshot {
+	return &Snapshot{
+		Coils:            append([]bool{}, s.Coils...),
+		DiscreteInputs:   append([]bool{}, s.DiscreteInputs...),
+		InputRegisters:   append([]uint16{}, s.InputRegisters...),
+		HoldingRegisters: append([]uint16{}, s.HoldingRegisters...),
+	}
+}
+
+// Store persists the data tables of a data model.
+type Store interface {
+
+	// Load returns the persisted tables or nil if the store is empty.
+	Load() (*Snapshot, error)
+
+	// Save replaces the persisted tables.
+	Save(s *Snapshot) error
+
+	// Write persists a change before it is applied to the data model.
+	Write(c Change) error
+}
+
+func (m *DataModel) persist(c Change) error {
+	if m.store == nil {
+		return nil
+	}
+	return m.store.Write(c)
+}
+
+func (m *DataModel) snapshot() *Snapshot {
+	return (&Snapshot{m.coils, m.discreteInputs, m.inputRegisters, m.holdingRegisters}).copy()
+}
+
+// Persist restores the coils and holding registers from the store and
+// persists all further writes to them. Input tables are not restored
+// since they are written by the application.
+func (m *DataModel) Persist(s Store) error {
+	snap, err := s.Load()
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if snap != nil {
+		copy(m.coils, snap.Coils)
+		copy(m.holdingRegisters, snap.HoldingRegisters)
+	}
+	if err := s.Save(m.snapshot()); err != nil {
+		return err
+	}
+	m.store = s
+	return nil
+}
+
+// Snapshot returns a copy of all data tables.
+func (m *DataModel) Snapshot() *Snapshot {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.snapshot()
+}
+
+// Restore overwrites the data tables with the snapshot. Values beyond
+// the size of the tables are ignored.
+func (m *DataModel) Restore(s *Snapshot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.store != nil {
+		next := m.snapshot()
+		copy(next.Coils, s.Coils)
+		copy(next.HoldingRegisters, s.HoldingRegisters)
+		if err := m.store.Save(next); err != nil {
+			return err
+		}
+	}
+	copy(m.coils, s.Coils)
+	copy(m.discreteInputs, s.DiscreteInputs)
+	copy(m.inputRegisters, s.InputRegisters)
+	copy(m.holdingRegisters, s.HoldingRegisters)
+	return nil
+}
+
+// ExportJSON writes a snapshot of all data tables as JSON.
+func (m *DataModel) ExportJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(m.Snapshot())
+}
+
+// ImportJSON restores a snapshot written by ExportJSON.
+func (m *DataModel) ImportJSON(r io.Reader) error {
+	s := &Snapshot{}
+	if err := json.NewDecoder(r).Decode(s); err != nil {
+		return err
+	}
+	return m.Restore(s)
+}
+
+const (
+	snapshotFile = "snapshot.json"
+	walFile      = "wal.log"
+)
+
+// FileStore persists the tables in a directory. Every change is appended
+// to a write-ahead log that is replaced by a snapshot from time to time.
+// A change that was torn by a crash is discarded on recovery; other
+// damage of the log is reported by NewFileStore.
+type FileStore struct {
+
+	// number of logged changes after which a snapshot is written (default 1000)
+	SnapshotInterval int
+
+	dir     string
+	mu      sync.Mutex
+	state   *Snapshot
+	wal     *os.File
+	changes int
+}
+
+// NewFileStore opens the store in dir and recovers its content.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &FileStore{SnapshotInterval: 1000, dir: dir}
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileStore) recover() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, snapshotFile))
+	switch {
+	case err == nil:
+		s.state = &Snapshot{}
+		if err := json.Unmarshal(data, s.state); err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+	wal, err := os.OpenFile(filepath.Join(s.dir, walFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(wal)
+	var valid int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// an incomplete final line was torn while it was written
+			break
+		}
+		if err != nil {
+			wal.Close()
+			return err
+		}
+		var c Change
+		if err := json.Unmarshal(line, &c); err != nil {
+			wal.Close()
+			return fmt.Errorf("Corrupt change at offset %d of %s: %v", valid, walFile, err)
+		}
+		if s.state == nil {
+			s.state = &Snapshot{}
+		}
+		s.state.apply(c)
+		s.changes++
+		valid += int64(len(line))
+	}
+	if err := wal.Truncate(valid); err != nil {
+		wal.Close()
+		return err
+	}
+	if _, err := wal.Seek(valid, io.SeekStart); err != nil {
+		wal.Close()
+		return err
+	}
+	s.wal = wal
+	return nil
+}
+
+func (s *FileStore) Load() (*Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == nil {
+		return nil, nil
+	}
+	return s.state.copy(), nil
+}
+
+func (s *FileStore) Save(snap *Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save(snap.copy())
+}
+
+// save writes the snapshot atomically and empties the log.
+func (s *FileStore) save(snap *Snapshot) error {
+	if s.wal == nil {
+		return errors.New("Store closed")
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.dir, snapshotFile+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, snapshotFile)); err != nil {
+		return err
+	}
+	// the log must not be truncated before the rename is durable
+	if err := syncDir(s.dir); err != nil {
+		return err
+	}
+	// if the process dies before the log is truncated, it is replayed on
+	// the new snapshot which is harmless for compactions since changes
+	// are absolute writes
+	if err := s.wal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.state = snap
+	s.changes = 0
+	return nil
+}
+
+// syncDir flushes the entries of a directory. Windows can not sync
+// directories; renames are durable there once they returned.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+func (s *FileStore) Write(c Change) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wal == nil {
+		return errors.New("Store closed")
+	}
+	line, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if _, err := s.wal.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := s.wal.Sync(); err != nil {
+		return err
+	}
+	if s.state == nil {
+		s.state = &Snapshot{}
+	}
+	s.state.apply(c)
+	s.changes++
+	if s.SnapshotInterval > 0 && s.changes >= s.SnapshotInterval {
+		// the change is already logged, a failed snapshot is retried
+		// after the next one
+		s.save(s.state)
+	}
+	return nil
+}
+
+// Close writes a final snapshot and closes the log.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wal == nil {
+		return errors.New("Store closed")
+	}
+	var err error
+	if s.state != nil && s.changes > 0 {
+		err = s.save(s.state)
+	}
+	if cerr := s.wal.Close(); err == nil {
+		err = cerr
+	}
+	s.wal = nil
+	return err
+}
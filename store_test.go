@@ -0,0 +1,115 @@
+package modbus
+
+import (
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_FileStore(t *testing.T) {
+
+	Convey("Given a data model persisted in a directory", t, func() {
+		dir, err := ioutil.TempDir("", "modbus-store")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		store, err := NewFileStore(dir)
+		So(err, ShouldBeNil)
+		m := NewDataModel(10, 10, 10, 10)
+		So(m.Persist(store), ShouldBeNil)
+		c := NewClient(&handlerTransporter{m}).(SerialClient)
+
+		reopen := func() *DataModel {
+			s, err := NewFileStore(dir)
+			So(err, ShouldBeNil)
+			m := NewDataModel(10, 10, 10, 10)
+			So(m.Persist(s), ShouldBeNil)
+			return m
+		}
+
+		Convey("written coils and holding registers should survive a restart", func() {
+			So(c.WriteMultipleRegisters(2, []uint16{7, 8}), ShouldBeNil)
+			So(c.WriteSingleCoil(9, true), ShouldBeNil)
+			So(c.MaskWriteRegister(3, 0x00ff, 0x0100), ShouldBeNil)
+			m.WriteInputRegisters(0, []uint16{5})
+			// simulate a crash: the store is not closed
+			r := reopen()
+			v, _ := r.ReadHoldingRegisters(2, 2)
+			So(v, ShouldResemble, []uint16{7, 0x108})
+			b, _ := r.ReadCoils(9, 1)
+			So(b, ShouldResemble, []bool{true})
+			v, _ = r.ReadInputRegisters(0, 1)
+			So(v, ShouldResemble, []uint16{0})
+		})
+
+		Convey("a torn write at the end of the log should be discarded", func() {
+			So(c.WriteSingleRegister(1, 11), ShouldBeNil)
+			So(c.WriteSingleRegister(2, 12), ShouldBeNil)
+			f, _ := os.OpenFile(filepath.Join(dir, walFile), os.O_APPEND|os.O_WRONLY, 0644)
+			f.Write([]byte(`{"table":3,"address":3,"regis`))
+			f.Close()
+			r := reopen()
+			v, _ := r.ReadHoldingRegisters(1, 3)
+			So(v, ShouldResemble, []uint16{11, 12, 0})
+			So(r.WriteHoldingRegisters(3, []uint16{13}), ShouldBeNil)
+			v, _ = reopen().ReadHoldingRegisters(1, 3)
+			So(v, ShouldResemble, []uint16{11, 12, 13})
+		})
+
+		Convey("a corrupt change in the middle of the log should be reported", func() {
+			So(c.WriteSingleRegister(1, 11), ShouldBeNil)
+			So(c.WriteSingleRegister(2, 12), ShouldBeNil)
+			wal := filepath.Join(dir, walFile)
+			data, _ := ioutil.ReadFile(wal)
+			lines := bytes.SplitAfter(data, []byte("\n"))
+			corrupt := append(append(append([]byte{}, lines[0]...), "{\"table\":3,\"addr\n"...), lines[1]...)
+			So(ioutil.WriteFile(wal, corrupt, 0644), ShouldBeNil)
+			_, err := NewFileStore(dir)
+			So(err, ShouldNotBeNil)
+			data, _ = ioutil.ReadFile(wal)
+			So(data, ShouldResemble, corrupt)
+		})
+
+		Convey("the log should be compacted into snapshots", func() {
+			store.SnapshotInterval = 3
+			for i := uint16(0); i < 4; i++ {
+				So(c.WriteSingleRegister(i, i+1), ShouldBeNil)
+			}
+			info, err := os.Stat(filepath.Join(dir, walFile))
+			So(err, ShouldBeNil)
+			wal, _ := ioutil.ReadFile(filepath.Join(dir, walFile))
+			So(bytes.Count(wal, []byte("\n")), ShouldEqual, 1)
+			So(info.Size(), ShouldBeGreaterThan, 0)
+			v, _ := reopen().ReadHoldingRegisters(0, 4)
+			So(v, ShouldResemble, []uint16{1, 2, 3, 4})
+		})
+
+		Convey("closing the store should write a snapshot", func() {
+			So(c.WriteSingleRegister(0, 1), ShouldBeNil)
+			So(store.Close(), ShouldBeNil)
+			wal, _ := ioutil.ReadFile(filepath.Join(dir, walFile))
+			So(wal, ShouldBeEmpty)
+			So(m.WriteHoldingRegisters(0, []uint16{2}), ShouldNotBeNil)
+			v, _ := reopen().ReadHoldingRegisters(0, 1)
+			So(v, ShouldResemble, []uint16{1})
+		})
+
+		Convey("snapshots should be exported and imported as JSON", func() {
+			m.WriteHoldingRegisters(0, []uint16{42})
+			m.WriteDiscreteInputs(1, []bool{true})
+			var buff bytes.Buffer
+			So(m.ExportJSON(&buff), ShouldBeNil)
+			So(buff.String(), ShouldContainSubstring, `"holding_registers":[42,0,`)
+
+			other := NewDataModel(10, 10, 10, 10)
+			So(other.ImportJSON(bytes.NewReader(buff.Bytes())), ShouldBeNil)
+			So(other.Snapshot(), ShouldResemble, m.Snapshot())
+
+			So(m.ImportJSON(bytes.NewBufferString(`{"holding_registers":[7]}`)), ShouldBeNil)
+			v, _ := reopen().ReadHoldingRegisters(0, 1)
+			So(v, ShouldResemble, []uint16{7})
+		})
+	})
+}
@@ -83,25 +83,43 @@ func unpackAdu(data []byte) (*adu, error) {
 }
 
 type tcpTransporter struct {
+	network     string
 	host        string
 	port        uint
 	conn        net.Conn
 	transaction uint16
 	id          uint8
 	timeout     time.Duration
+
+	// number of successful connects
+	connects  uint64
+	recorders []ConnectRecorder
+}
+
+func (t *tcpTransporter) observeConnects(r ConnectRecorder) {
+	t.recorders = append(t.recorders, r)
 }
 
-func (t *tcpTransporter) Connect() error {
+func (t *tcpTransporter) Connect() (err error) {
 	address := t.host + ":" + strconv.Itoa(int(t.port))
+	network := t.network
+	if network == "" {
+		network = "tcp"
+	}
 	if t.timeout > 0 {
-		conn, err := net.DialTimeout("tcp", address, t.timeout)
-		t.conn = conn
-		return err
+		t.conn, err = net.DialTimeout(network, address, t.timeout)
 	} else {
-		conn, err := net.Dial("tcp", address)
-		t.conn = conn
-		return err
+		t.conn, err = net.Dial(network, address)
+	}
+	if err != nil {
+		t.conn = nil
+		return transportError("connect", err)
+	}
+	t.connects++
+	for _, r := range t.recorders {
+		r.ObserveConnect(t.connects > 1)
 	}
+	return
 }
 
 func (t *tcpTransporter) Close() (err error) {
@@ -116,39 +134,69 @@ func (t *tcpTransporter) Close() (err error) {
 }
 
 func (t *tcpTransporter) Send(pdu *Pdu) (*Pdu, error) {
+	return t.sendTo(t.id, pdu)
+}
+
+func (t *tcpTransporter) sendTo(unit uint8, pdu *Pdu) (*Pdu, error) {
 	if t.conn == nil {
 		if err := t.Connect(); err != nil {
 			return nil, err
 		}
 	}
 	t.transaction++
-	header := &header{t.transaction, tcpProtocolId, uint16(len(pdu.Data) + 2), t.id}
+	header := &header{t.transaction, tcpProtocolId, uint16(len(pdu.Data) + 2), unit}
 	binAdu, err := (&adu{header, pdu}).pack()
 	if err != nil {
 		return nil, err
 	}
+	// the timeout bounds the whole transaction; a zero timeout clears
+	// the deadline of a previous one
+	var deadline time.Time
+	if t.timeout > 0 {
+		deadline = time.Now().Add(t.timeout)
+	}
+	t.conn.SetDeadline(deadline)
 	if _, err := t.conn.Write(binAdu); err != nil {
-		return nil, fmt.Errorf("Could not write data: %s", err)
+		t.Close()
+		return nil, transportError("write data", err)
 	}
 	buff := make([]byte, aduLength)
 	l, err := t.conn.Read(buff)
 	if err != nil {
-		return nil, fmt.Errorf("Could not receive data: %s", err)
+		// a late response would be taken for the answer to the next
+		// request, so the next one reconnects
+		t.Close()
+		return nil, transportError("receive data", err)
 	}
 	res, err := unpackAdu(buff[:l])
 	if err != nil {
-		return nil, fmt.Errorf("Could not read PDU: %s", err)
+		return nil, FramingError{err.Error()}
 	}
 	if i := res.header.transaction; i != t.transaction {
-		return nil, fmt.Errorf("Invalid transaction id: %d instead of %d", i, t.transaction)
+		return nil, FramingError{fmt.Sprintf("transaction id %d instead of %d", i, t.transaction)}
 	}
 	return res.pdu, nil
 }
 
-func NewTcpClient(host string, port uint) IoClient {
-	return &mbClient{&tcpTransporter{host: host, port: port}}
+// NewTcpTransporter creates a transporter for Modbus TCP. The timeout
+// bounds connecting and every transaction (0 waits forever).
+func NewTcpTransporter(host string, port uint, unit uint8, timeout time.Duration) Transporter {
+	return &tcpTransporter{host: host, port: port, id: unit, timeout: timeout}
+}
+
+// NewUdpTransporter creates a transporter that sends MBAP frames as UDP datagrams.
+func NewUdpTransporter(host string, port uint, unit uint8, timeout time.Duration) Transporter {
+	return &tcpTransporter{network: "udp", host: host, port: port, id: unit, timeout: timeout}
+}
+
+func NewTcpClient(host string, port uint, mw ...Middleware) IoClient {
+	return NewClient(NewTcpTransporter(host, port, 0, 0), mw...)
+}
+
+func NewTcpClientTimeout(host string, port uint, timeout time.Duration, mw ...Middleware) IoClient {
+	return NewClient(NewTcpTransporter(host, port, 0, timeout), mw...)
 }
 
-func NewTcpClientTimeout(host string, port uint, timeout time.Duration) IoClient {
-	return &mbClient{&tcpTransporter{host: host, port: port, timeout: timeout}}
+func NewUdpClient(host string, port uint, timeout time.Duration, mw ...Middleware) IoClient {
+	return NewClient(NewUdpTransporter(host, port, 0, timeout), mw...)
 }
@@ -0,0 +1,200 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Modbus TCP server side framing
+ */
+
+package modbus
+
+import (
+	"crypto/tls"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// readAdu reads one MBAP framed request from r.
+func readAdu(r io.Reader) (*adu, error) {
+	buff := make([]byte, aduLength)
+	if _, err := io.ReadFull(r, buff[:headerLength]); err != nil {
+		return nil, err
+	}
+	h, err := unpackHeader(buff[:headerLength])
+	if err != nil {
+		return nil, err
+	}
+	if h.protocol != tcpProtocolId {
+		return nil, FramingError{fmt.Sprintf("protocol id %d instead of %d", h.protocol, tcpProtocolId)}
+	}
+	if h.length < 2 || int(h.length) > aduLength-headerLength+1 {
+		return nil, FramingError{fmt.Sprintf("invalid length field: %d byte", h.length)}
+	}
+	l := headerLength + int(h.length) - 1
+	if _, err := io.ReadFull(r, buff[headerLength:l]); err != nil {
+		return nil, err
+	}
+	return unpackAdu(buff[:l])
+}
+
+// writeAdu answers the request described by h with the given pdu.
+func writeAdu(w io.Writer, h *header, pdu *Pdu) error {
+	res := &header{h.transaction, h.protocol, uint16(len(pdu.Data) + 2), h.unit}
+	bin, err := (&adu{res, pdu}).pack()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(bin)
+	return err
+}
+
+// serveTcp accepts connections on l and answers every request with the
+// pdu returned by handle. Requests answered with nil are ignored.
+func serveTcp(l net.Listener, handle func(conn net.Conn, h *header, req *Pdu) *Pdu) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go serveTcpConn(conn, handle)
+	}
+}
+
+func serveTcpConn(conn net.Conn, handle func(conn net.Conn, h *header, req *Pdu) *Pdu) {
+	defer conn.Close()
+	for {
+		req, err := readAdu(conn)
+		if err != nil {
+			return
+		}
+		res := handle(conn, req.header, req.pdu)
+		if res == nil {
+			continue
+		}
+		if err := writeAdu(conn, req.header, res); err != nil {
+			return
+		}
+	}
+}
+
+type TcpServer struct {
+	units
+	address  string
+	config   *tls.Config
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]bool
+}
+
+// NewTcpServer creates a server that listens on the given address
+// (e.g. ":502") as soon as it is started.
+func NewTcpServer(address string) *TcpServer {
+	return &TcpServer{address: address, conns: map[net.Conn]bool{}}
+}
+
+// NewTlsServer creates a Modbus/TCP Security server. The config must
+// contain the server certificate and should require client certificates
+// (tls.RequireAndVerifyClientCert). The role extension of verified client
+// certificates is passed to the handlers as Request.Role; certificates
+// that were not verified have no role.
+func NewTlsServer(address string, config *tls.Config) *TcpServer {
+	s := NewTcpServer(address)
+	s.config = config
+	return s
+}
+
+// roleOid identifies the role extension of Modbus/TCP Security certificates.
+var roleOid = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 50316, 802, 1}
+
+// tlsRole returns the role of the verified client certificate of conn.
+func tlsRole(conn net.Conn) string {
+	c, ok := conn.(*tls.Conn)
+	if !ok {
+		return ""
+	}
+	chains := c.ConnectionState().VerifiedChains
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return ""
+	}
+	for _, ext := range chains[0][0].Extensions {
+		if ext.Id.Equal(roleOid) {
+			var role string
+			if _, err := asn1.Unmarshal(ext.Value, &role); err == nil {
+				return role
+			}
+		}
+	}
+	return ""
+}
+
+// Addr returns the address the server is listening on.
+func (s *TcpServer) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+func (s *TcpServer) Start() error {
+	var l net.Listener
+	var err error
+	if s.config != nil {
+		l, err = tls.Listen("tcp", s.address, s.config)
+	} else {
+		l, err = net.Listen("tcp", s.address)
+	}
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.listener = l
+	s.mu.Unlock()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.track(conn, true)
+			go func() {
+				serveTcpConn(conn, s.handle)
+				s.track(conn, false)
+			}()
+		}
+	}()
+	return nil
+}
+
+func (s *TcpServer) handle(conn net.Conn, h *header, req *Pdu) *Pdu {
+	return s.serve(&Request{Unit: h.unit, RemoteAddr: conn.RemoteAddr(), Transport: FramingTCP, Role: tlsRole(conn), Pdu: req})
+}
+
+func (s *TcpServer) track(conn net.Conn, active bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if active {
+		s.conns[conn] = true
+	} else {
+		delete(s.conns, conn)
+	}
+}
+
+// Stop closes the listener and all open connections.
+func (s *TcpServer) Stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return errors.New("Not started")
+	}
+	err := s.listener.Close()
+	s.listener = nil
+	for c := range s.conns {
+		c.Close()
+		delete(s.conns, c)
+	}
+	return err
+}
@@ -0,0 +1,176 @@
+package modbus
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	. "github.com/smartystreets/goconvey/convey"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testCertificate creates a self-signed certificate with the given role.
+func testCertificate(role string) (tls.Certificate, *x509.Certificate) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if role != "" {
+		value, _ := asn1.MarshalWithParams(role, "utf8")
+		tmpl.ExtraExtensions = []pkix.Extension{{Id: roleOid, Value: value}}
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	cert, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, cert
+}
+
+func Test_TcpServer(t *testing.T) {
+
+	Convey("Given a running tcp server", t, func() {
+		s := NewTcpServer("127.0.0.1:0")
+		m := NewDataModel(0, 0, 0, 10)
+		m.WriteHoldingRegisters(0, []uint16{1})
+		s.SetHandler(m)
+		So(s.Start(), ShouldBeNil)
+		defer s.Stop()
+		port := uint(s.Addr().(*net.TCPAddr).Port)
+
+		Convey("requests should be answered by the handler", func() {
+			c := NewTcpClientTimeout("127.0.0.1", port, time.Second)
+			defer c.Transporter().Close()
+			v, err := c.ReadHoldingRegisters(0, 1)
+			So(err, ShouldBeNil)
+			So(v, ShouldResemble, []uint16{1})
+		})
+
+		Convey("requests to other units should be routed to their handler", func() {
+			u := NewDataModel(0, 0, 0, 10)
+			u.WriteHoldingRegisters(0, []uint16{2})
+			s.SetUnitHandler(7, u)
+			c := NewClient(NewTcpTransporter("127.0.0.1", port, 7, time.Second))
+			defer c.Transporter().Close()
+			v, err := c.ReadHoldingRegisters(0, 1)
+			So(err, ShouldBeNil)
+			So(v, ShouldResemble, []uint16{2})
+		})
+
+		Convey("handlers should get the request metadata", func() {
+			var got Request
+			s.SetUnitHandler(9, HandlerFunc(func(r *Request) *Pdu {
+				got = *r
+				return m.Handle(r.Pdu)
+			}))
+			c := NewClient(NewTcpTransporter("127.0.0.1", port, 9, time.Second))
+			defer c.Transporter().Close()
+			_, err := c.ReadHoldingRegisters(0, 1)
+			So(err, ShouldBeNil)
+			So(got.Unit, ShouldEqual, 9)
+			So(got.Transport, ShouldEqual, FramingTCP)
+			So(got.RemoteAddr.String(), ShouldStartWith, "127.0.0.1:")
+		})
+
+		Convey("requests to unknown units should not be answered if silent", func() {
+			s.SetHandler(nil)
+			s.SetUnknownUnit(UnknownUnitSilent)
+			c := NewClient(NewTcpTransporter("127.0.0.1", port, 3, 100*time.Millisecond))
+			defer c.Transporter().Close()
+			_, err := c.ReadHoldingRegisters(0, 1)
+			So(err, ShouldHaveSameTypeAs, TimeoutError{})
+		})
+
+		Convey("open connections should be closed when the server stops", func() {
+			c := NewTcpClientTimeout("127.0.0.1", port, time.Second)
+			defer c.Transporter().Close()
+			_, err := c.ReadHoldingRegisters(0, 1)
+			So(err, ShouldBeNil)
+			s.Stop()
+			_, err = c.ReadHoldingRegisters(0, 1)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func Test_TlsServer(t *testing.T) {
+
+	Convey("Given a running TLS server", t, func() {
+		serverCert, serverX509 := testCertificate("")
+		clientCert, clientX509 := testCertificate("operator")
+		clients := x509.NewCertPool()
+		clients.AddCert(clientX509)
+		s := NewTlsServer("127.0.0.1:0", &tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    clients,
+		})
+		var got Request
+		s.SetHandler(HandlerFunc(func(r *Request) *Pdu {
+			got = *r
+			return &Pdu{r.Pdu.Function, []byte{2, 0, 1}}
+		}))
+		So(s.Start(), ShouldBeNil)
+		defer s.Stop()
+
+		Convey("the role of the client certificate should be passed to the handler", func() {
+			servers := x509.NewCertPool()
+			servers.AddCert(serverX509)
+			conn, err := tls.Dial("tcp", s.Addr().String(), &tls.Config{
+				Certificates: []tls.Certificate{clientCert},
+				RootCAs:      servers,
+			})
+			So(err, ShouldBeNil)
+			defer conn.Close()
+			_, err = conn.Write([]byte{0, 1, 0, 0, 0, 6, 1, 3, 0, 0, 0, 1})
+			So(err, ShouldBeNil)
+			res, err := readAdu(conn)
+			So(err, ShouldBeNil)
+			So(res.pdu, ShouldResemble, &Pdu{3, []byte{2, 0, 1}})
+			So(got.Role, ShouldEqual, "operator")
+		})
+	})
+
+	Convey("Given a TLS server that does not verify client certificates", t, func() {
+		serverCert, serverX509 := testCertificate("")
+		clientCert, _ := testCertificate("admin")
+		s := NewTlsServer("127.0.0.1:0", &tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+			ClientAuth:   tls.RequestClientCert,
+		})
+		got := Request{Role: "none"}
+		s.SetHandler(HandlerFunc(func(r *Request) *Pdu {
+			got = *r
+			return &Pdu{r.Pdu.Function, []byte{2, 0, 1}}
+		}))
+		So(s.Start(), ShouldBeNil)
+		defer s.Stop()
+
+		Convey("the role of an unverified certificate should be ignored", func() {
+			servers := x509.NewCertPool()
+			servers.AddCert(serverX509)
+			conn, err := tls.Dial("tcp", s.Addr().String(), &tls.Config{
+				Certificates: []tls.Certificate{clientCert},
+				RootCAs:      servers,
+			})
+			So(err, ShouldBeNil)
+			defer conn.Close()
+			_, err = conn.Write([]byte{0, 1, 0, 0, 0, 6, 1, 3, 0, 0, 0, 1})
+			So(err, ShouldBeNil)
+			_, err = readAdu(conn)
+			So(err, ShouldBeNil)
+			So(got.Role, ShouldEqual, "")
+		})
+	})
+}
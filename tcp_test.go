@@ -2,6 +2,7 @@ package modbus
 
 import (
 	"encoding/binary"
+	"errors"
 	. "github.com/smartystreets/goconvey/convey"
 	"net"
 	"testing"
@@ -19,7 +20,8 @@ func (a *dummyAddr) String() string {
 }
 
 type dummyConn struct {
-	respond func([]byte) (int, error)
+	respond  func([]byte) (int, error)
+	deadline time.Time
 }
 
 func (c *dummyConn) Read(b []byte) (n int, err error) {
@@ -47,6 +49,7 @@ func (c *dummyConn) RemoteAddr() net.Addr {
 }
 
 func (c *dummyConn) SetDeadline(t time.Time) error {
+	c.deadline = t
 	return nil
 }
 
@@ -213,6 +216,31 @@ func Test_Tcp(t *testing.T) {
 				So(tr.transaction, ShouldEqual, 1)
 				So(err, ShouldNotEqual, nil)
 			})
+
+			Convey("the connection should be dropped after a failed read", func() {
+				tr.conn = &dummyConn{respond: func(b []byte) (int, error) {
+					return 0, errors.New("i/o timeout")
+				}}
+				_, err := tr.Send(req)
+				So(err, ShouldHaveSameTypeAs, TransportError{})
+				So(tr.conn, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given a transporter with a timeout", t, func() {
+		conn := &dummyConn{}
+		tr := &tcpTransporter{conn: conn, timeout: time.Second}
+
+		Convey("every transaction should have a deadline", func() {
+			tr.Send(&Pdu{3, nil})
+			So(conn.deadline.After(time.Now()), ShouldBeTrue)
+
+			Convey("that is cleared if the timeout is disabled", func() {
+				tr.setTimeout(0)
+				tr.Send(&Pdu{3, nil})
+				So(conn.deadline.IsZero(), ShouldBeTrue)
+			})
 		})
 	})
 }
@@ -0,0 +1,210 @@
+/**
+ * Copyright (C) 2014 - 2015, Markus Kohlhase <mail@markus-kohlhase.de>
+ *
+ * Adaptive response timeouts
+ */
+
+package modbus
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// timeoutTransporter is implemented by transporters with a response
+// timeout. setTimeout returns the previous one.
+type timeoutTransporter interface {
+	setTimeout(d time.Duration) time.Duration
+}
+
+func (t *tcpTransporter) setTimeout(d time.Duration) (old time.Duration) {
+	old, t.timeout = t.timeout, d
+	return
+}
+
+func (t *rtuTransporter) setTimeout(d time.Duration) (old time.Duration) {
+	old, t.timeout = t.timeout, d
+	return
+}
+
+func (t *asciiTransporter) setTimeout(d time.Duration) (old time.Duration) {
+	old, t.timeout = t.timeout, d
+	return
+}
+
+func (t *slaveTransporter) setTimeout(d time.Duration) (old time.Duration) {
+	old, t.timeout = t.timeout, d
+	return
+}
+
+// findTimeout returns the transporter below the middlewares that has a
+// response timeout.
+func findTimeout(t Transporter) timeoutTransporter {
+	switch t := t.(type) {
+	case timeoutTransporter:
+		return t
+	case *sendTransporter:
+		return findTimeout(t.Transporter)
+	case unitSender:
+		return findTimeout(t.Transporter)
+	}
+	return nil
+}
+
+// ResponseTimes are the statistics learned by an AdaptiveTimeout.
+type ResponseTimes struct {
+
+	// number of samples in the window
+	Samples int
+
+	Mean time.Duration
+	P50  time.Duration
+	P90  time.Duration
+	P99  time.Duration
+	Max  time.Duration
+
+	// number of requests that timed out
+	Timeouts uint64
+
+	// timeout of the next request
+	Timeout time.Duration
+}
+
+// AdaptiveTimeout learns the response times of a device and sets the
+// timeout of every request to a quantile of them multiplied by a factor.
+// Requests that time out count with their timeout, so the timeout grows
+// for devices that became slower. Use one per device.
+type AdaptiveTimeout struct {
+
+	// bounds of the timeout; Max is used until enough samples are known
+	Min, Max time.Duration
+
+	// quantile of the response times (default 0.99)
+	Quantile float64
+
+	// multiplier of the quantile (default 2)
+	Factor float64
+
+	// number of recent responses that are used (default 200)
+	Window int
+
+	// number of responses needed to adapt the timeout (default 20)
+	MinSamples int
+
+	mu       sync.Mutex
+	samples  []time.Duration
+	next     int
+	timeouts uint64
+}
+
+func NewAdaptiveTimeout(min, max time.Duration) *AdaptiveTimeout {
+	return &AdaptiveTimeout{Min: min, Max: max, Quantile: 0.99, Factor: 2, Window: 200, MinSamples: 20}
+}
+
+func (a *AdaptiveTimeout) observe(d time.Duration, timeout bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if timeout {
+		a.timeouts++
+	}
+	window := a.Window
+	if window <= 0 {
+		window = 200
+	}
+	if len(a.samples) < window {
+		a.samples = append(a.samples, d)
+		return
+	}
+	a.samples[a.next%len(a.samples)] = d
+	a.next++
+}
+
+func quantile(sorted []time.Duration, q float64) time.Duration {
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+func (a *AdaptiveTimeout) timeout(sorted []time.Duration) time.Duration {
+	if len(sorted) == 0 || len(sorted) < a.MinSamples {
+		return a.Max
+	}
+	q, f := a.Quantile, a.Factor
+	if q <= 0 {
+		q = 0.99
+	}
+	if f <= 0 {
+		f = 2
+	}
+	d := time.Duration(float64(quantile(sorted, q)) * f)
+	if d < a.Min {
+		d = a.Min
+	}
+	if a.Max > 0 && d > a.Max {
+		d = a.Max
+	}
+	return d
+}
+
+func (a *AdaptiveTimeout) sorted() []time.Duration {
+	s := append([]time.Duration{}, a.samples...)
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+	return s
+}
+
+// Timeout returns the timeout of the next request.
+func (a *AdaptiveTimeout) Timeout() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.timeout(a.sorted())
+}
+
+func (a *AdaptiveTimeout) Stats() ResponseTimes {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s := a.sorted()
+	r := ResponseTimes{Samples: len(s), Timeouts: a.timeouts, Timeout: a.timeout(s)}
+	if len(s) == 0 {
+		return r
+	}
+	var sum time.Duration
+	for _, d := range s {
+		sum += d
+	}
+	r.Mean = sum / time.Duration(len(s))
+	r.P50, r.P90, r.P99 = quantile(s, 0.5), quantile(s, 0.9), quantile(s, 0.99)
+	r.Max = s[len(s)-1]
+	return r
+}
+
+// Middleware sets the timeout of the transporter before every request.
+// It works with TCP, UDP, RTU and ASCII transporters and the slaves of
+// a Bus.
+func (a *AdaptiveTimeout) Middleware() Middleware {
+	return WrapSend(func(next Transporter, req *Pdu) (*Pdu, error) {
+		t := findTimeout(next)
+		if t == nil {
+			return next.Send(req)
+		}
+		timeout := a.Timeout()
+		t.setTimeout(timeout)
+		start := time.Now()
+		res, err := next.Send(req)
+		switch err.(type) {
+		case nil, Error:
+			if res != nil {
+				a.observe(time.Since(start), false)
+			}
+		case TimeoutError:
+			a.observe(timeout, true)
+		}
+		return res, err
+	})
+}
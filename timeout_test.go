@@ -0,0 +1,131 @@
+package modbus
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"net"
+	"testing"
+	"time"
+)
+
+// slowTransporter answers after a delay or times out
+type slowTransporter struct {
+	delay   time.Duration
+	timeout time.Duration
+}
+
+func (t *slowTransporter) Connect() error {
+	return nil
+}
+
+func (t *slowTransporter) Close() error {
+	return nil
+}
+
+func (t *slowTransporter) Send(pdu *Pdu) (*Pdu, error) {
+	if t.timeout > 0 && t.delay > t.timeout {
+		time.Sleep(t.timeout)
+		return nil, TimeoutError{"receive data", nil}
+	}
+	time.Sleep(t.delay)
+	return &Pdu{pdu.Function, []byte{0}}, nil
+}
+
+func (t *slowTransporter) setTimeout(d time.Duration) (old time.Duration) {
+	old, t.timeout = t.timeout, d
+	return
+}
+
+func Test_AdaptiveTimeout(t *testing.T) {
+
+	Convey("Given an adaptive timeout", t, func() {
+		a := NewAdaptiveTimeout(5*time.Millisecond, 200*time.Millisecond)
+		a.MinSamples = 5
+		a.Window = 10
+
+		Convey("the maximum should be used until enough responses are known", func() {
+			So(a.Timeout(), ShouldEqual, 200*time.Millisecond)
+			for i := 0; i < 4; i++ {
+				a.observe(time.Millisecond, false)
+			}
+			So(a.Timeout(), ShouldEqual, 200*time.Millisecond)
+		})
+
+		Convey("the timeout should follow the quantile within the bounds", func() {
+			for i := 1; i <= 10; i++ {
+				a.observe(time.Duration(i)*time.Millisecond, false)
+			}
+			So(a.Timeout(), ShouldEqual, 20*time.Millisecond)
+			a.Factor = 100
+			So(a.Timeout(), ShouldEqual, 200*time.Millisecond)
+			a.Factor = 0.1
+			So(a.Timeout(), ShouldEqual, 5*time.Millisecond)
+		})
+
+		Convey("old responses should leave the window", func() {
+			for i := 0; i < 10; i++ {
+				a.observe(50*time.Millisecond, false)
+			}
+			for i := 0; i < 10; i++ {
+				a.observe(time.Millisecond, false)
+			}
+			s := a.Stats()
+			So(s.Samples, ShouldEqual, 10)
+			So(s.Max, ShouldEqual, time.Millisecond)
+			So(s.Timeout, ShouldEqual, 5*time.Millisecond)
+		})
+
+		Convey("the statistics should be exposed", func() {
+			for i := 1; i <= 100; i++ {
+				a.Window = 100
+				a.observe(time.Duration(i)*time.Millisecond, i == 100)
+			}
+			s := a.Stats()
+			So(s.Mean, ShouldEqual, 50500*time.Microsecond)
+			So(s.P50, ShouldEqual, 50*time.Millisecond)
+			So(s.P90, ShouldEqual, 90*time.Millisecond)
+			So(s.P99, ShouldEqual, 99*time.Millisecond)
+			So(s.Timeouts, ShouldEqual, 1)
+		})
+
+		Convey("the timeout of the transporter should be set before every request", func() {
+			slow := &slowTransporter{delay: time.Millisecond}
+			c := NewClient(slow, a.Middleware())
+			for i := 0; i < 5; i++ {
+				_, err := c.ReadHoldingRegisters(0, 1)
+				So(err, ShouldNotHaveSameTypeAs, TimeoutError{})
+			}
+			So(slow.timeout, ShouldEqual, 200*time.Millisecond)
+			c.ReadHoldingRegisters(0, 1)
+			So(slow.timeout, ShouldBeLessThan, 200*time.Millisecond)
+			So(slow.timeout, ShouldBeGreaterThanOrEqualTo, 5*time.Millisecond)
+
+			Convey("and grow when the device becomes slower", func() {
+				slow.delay = 100 * time.Millisecond
+				before := a.Timeout()
+				_, err := c.ReadHoldingRegisters(0, 1)
+				So(err, ShouldHaveSameTypeAs, TimeoutError{})
+				So(a.Stats().Timeouts, ShouldEqual, 1)
+				So(a.Timeout(), ShouldBeGreaterThan, before)
+			})
+		})
+	})
+
+	Convey("Given a tcp client with an adaptive timeout", t, func() {
+		s := NewTcpServer("127.0.0.1:0")
+		s.SetHandler(NewDataModel(0, 0, 0, 1))
+		So(s.Start(), ShouldBeNil)
+		defer s.Stop()
+		a := NewAdaptiveTimeout(10*time.Millisecond, time.Second)
+		c := NewTcpClientTimeout("127.0.0.1", uint(s.Addr().(*net.TCPAddr).Port), time.Second, a.Middleware())
+		defer c.Transporter().Close()
+
+		Convey("the response times should be learned", func() {
+			for i := 0; i < 30; i++ {
+				_, err := c.ReadHoldingRegisters(0, 1)
+				So(err, ShouldBeNil)
+			}
+			So(a.Stats().Samples, ShouldEqual, 30)
+			So(a.Timeout(), ShouldBeLessThan, time.Second)
+		})
+	})
+}
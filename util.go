@@ -44,3 +44,21 @@ func filter(s []byte, fn func(byte) bool) []byte {
 	}
 	return p
 }
+
+func packBits(bits []bool) []byte {
+	bytes := make([]byte, (len(bits)+7)/8)
+	for i, b := range bits {
+		if b {
+			bytes[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return bytes
+}
+
+func unpackBits(bytes []byte, count int) []bool {
+	bits := make([]bool, count)
+	for i := range bits {
+		bits[i] = bytes[i/8]&(1<<uint(i%8)) != 0
+	}
+	return bits
+}